@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/big"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	PRIVACY_BUDGETS_COLLECTION   = "privacy_budgets"
+	AGGREGATE_QUERIES_COLLECTION = "aggregate_queries"
+	// Total epsilon buyers may spend on one aggregate dataset
+	DP_DATASET_EPSILON = 10.0
+	// Largest epsilon a single query may spend
+	DP_MAX_QUERY_EPSILON = 1.0
+	// Delta spent by each Gaussian query
+	DP_DELTA = 1e-6
+)
+
+// Aggregate queries
+const (
+	AGGREGATE_WATCH_TIME_BY_HOUR = "watch_time_by_hour"
+	AGGREGATE_CHANNEL_OVERLAP    = "channel_audience_overlap"
+)
+
+// Noise mechanisms
+const (
+	DP_MECHANISM_LAPLACE  = "laplace"
+	DP_MECHANISM_GAUSSIAN = "gaussian"
+)
+
+// Data types offered as aggregate datasets. Every contribution of the type
+// belongs to its dataset.
+var aggregateDataTypes = []string{DEFAULT_DATA_TYPE, EVENT_EPOCH_DATA_TYPE}
+
+var errPrivacyBudgetExhausted = errors.New("privacy budget exhausted")
+
+// Create the privacy budget of every aggregate dataset and register the
+// datasets on QueryEngine so buyers can purchase access
+func initAggregates() {
+	ctx, cancel := context.WithTimeout(context.Background(), EVENT_INDEX_INIT_TIMEOUT)
+	defer cancel()
+
+	for _, dataType := range aggregateDataTypes {
+		budget, err := ensurePrivacyBudget(ctx, dataType)
+		if err != nil {
+			slog.Error("Failed to create privacy budget", "dataType", dataType, "error", err)
+			continue
+		}
+		if !budget.Registered {
+			enqueueJob(ctx, "register-aggregate-dataset", func(ctx context.Context) error {
+				return registerAggregateDataset(ctx, budget)
+			})
+		}
+	}
+}
+
+// On-chain ID of the aggregate dataset of dataType
+func aggregateDatasetID(dataType string) common.Hash {
+	return crypto.Keccak256Hash([]byte("tubedao:aggregate:" + dataType))
+}
+
+func ensurePrivacyBudget(ctx context.Context, dataType string) (PrivacyBudget, error) {
+	datasetID := aggregateDatasetID(dataType).Hex()
+	now := time.Now()
+
+	var budget PrivacyBudget
+	err := db.Collection(PRIVACY_BUDGETS_COLLECTION).FindOneAndUpdate(ctx,
+		bson.M{"_id": datasetID},
+		bson.M{"$setOnInsert": PrivacyBudget{
+			DatasetID:    datasetID,
+			DataType:     dataType,
+			EpsilonTotal: getEnvFloat("DP_DATASET_EPSILON", DP_DATASET_EPSILON),
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&budget)
+	if err != nil {
+		return budget, fmt.Errorf("failed to load privacy budget: %v", err)
+	}
+	return budget, nil
+}
+
+func registerAggregateDataset(ctx context.Context, budget PrivacyBudget) error {
+	accessPrice := big.NewInt(1000000000000000000)
+	if err := setDataAccessPermissions(ctx, common.HexToHash(budget.DatasetID), accessPrice, false); err != nil {
+		loggerFromContext(ctx).Warn("Failed to register aggregate dataset", "dataType", budget.DataType, "error", err)
+		return nil
+	}
+	_, err := db.Collection(PRIVACY_BUDGETS_COLLECTION).UpdateOne(ctx,
+		bson.M{"_id": budget.DatasetID},
+		bson.M{"$set": bson.M{"registered": true, "updatedAt": time.Now()}})
+	return err
+}
+
+// Spend epsilon (and delta) from a dataset's budget, refusing queries that
+// would overdraw it. The check and the spend are one atomic update.
+func spendPrivacyBudget(ctx context.Context, dataType string, epsilon, delta float64) (PrivacyBudget, error) {
+	budget, err := ensurePrivacyBudget(ctx, dataType)
+	if err != nil {
+		return budget, err
+	}
+
+	err = db.Collection(PRIVACY_BUDGETS_COLLECTION).FindOneAndUpdate(ctx,
+		bson.M{
+			"_id": budget.DatasetID,
+			// Tolerate float rounding when a query spends the exact remainder
+			"$expr": bson.M{"$lte": bson.A{bson.M{"$add": bson.A{"$epsilonSpent", epsilon}}, bson.M{"$add": bson.A{"$epsilonTotal", 1e-9}}}},
+		},
+		bson.M{
+			"$inc": bson.M{"epsilonSpent": epsilon, "deltaSpent": delta, "queries": 1},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&budget)
+	if err == mongo.ErrNoDocuments {
+		return budget, errPrivacyBudgetExhausted
+	}
+	if err != nil {
+		return budget, fmt.Errorf("failed to spend privacy budget: %v", err)
+	}
+	return budget, nil
+}
+
+// Give back budget spent by a query that released nothing
+func refundPrivacyBudget(ctx context.Context, datasetID string, epsilon, delta float64) {
+	_, err := db.Collection(PRIVACY_BUDGETS_COLLECTION).UpdateOne(ctx,
+		bson.M{"_id": datasetID},
+		bson.M{"$inc": bson.M{"epsilonSpent": -epsilon, "deltaSpent": -delta, "queries": -1}})
+	if err != nil {
+		loggerFromContext(ctx).Error("Failed to refund privacy budget", "datasetId", datasetID, "error", err)
+	}
+}
+
+// List aggregate datasets with their QueryEngine IDs and remaining budget
+func listAggregateDatasets(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	datasets := make([]PrivacyBudget, 0, len(aggregateDataTypes))
+	for _, dataType := range aggregateDataTypes {
+		budget, err := ensurePrivacyBudget(ctx, dataType)
+		if err != nil {
+			respondError(c, ERR_INTERNAL, "Failed to fetch aggregate datasets")
+			return
+		}
+		datasets = append(datasets, budget.withRemaining())
+	}
+
+	c.JSON(http.StatusOK, AggregateDatasetsResponse{Data: datasets})
+}
+
+func (b PrivacyBudget) withRemaining() PrivacyBudget {
+	b.EpsilonRemaining = math.Max(0, b.EpsilonTotal-b.EpsilonSpent)
+	return b
+}
+
+// Answer an aggregate query with calibrated noise. The buyer must hold
+// QueryEngine access to the dataset, and each query spends its epsilon
+// from the dataset's budget before anything is computed.
+func queryAggregate(c *gin.Context) {
+	var req AggregateQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	if !slices.Contains(aggregateDataTypes, req.DataType) {
+		respondError(c, ERR_UNSUPPORTED_DATA_TYPE, fmt.Sprintf("No aggregate dataset for %q", req.DataType))
+		return
+	}
+	if req.Mechanism == "" {
+		req.Mechanism = DP_MECHANISM_LAPLACE
+	}
+	maxEpsilon := getEnvFloat("DP_MAX_QUERY_EPSILON", DP_MAX_QUERY_EPSILON)
+	if req.Epsilon > maxEpsilon {
+		respondError(c, ERR_INVALID_REQUEST, fmt.Sprintf("epsilon may be at most %g per query", maxEpsilon))
+		return
+	}
+	// The classical Gaussian mechanism bound only holds for epsilon < 1
+	if req.Mechanism == DP_MECHANISM_GAUSSIAN && req.Epsilon >= 1 {
+		respondError(c, ERR_INVALID_REQUEST, "The Gaussian mechanism requires epsilon below 1")
+		return
+	}
+	if req.Query == AGGREGATE_CHANNEL_OVERLAP && len(req.Channels) != 2 {
+		respondError(c, ERR_INVALID_REQUEST, "channel_audience_overlap needs exactly two channels")
+		return
+	}
+
+	ctx := c.Request.Context()
+	logger := loggerFromContext(ctx)
+	buyer, _ := c.Get("address")
+	datasetID := aggregateDatasetID(req.DataType)
+
+	allowed, err := checkDataAccess(datasetID, common.HexToAddress(buyer.(string)))
+	if err != nil {
+		respondError(c, ERR_CHAIN_UNAVAILABLE, fmt.Sprintf("Failed to check dataset access: %v", err))
+		return
+	}
+	if !allowed {
+		respondError(c, ERR_DATASET_ACCESS_DENIED, "Purchase access to the dataset on QueryEngine first")
+		return
+	}
+
+	delta := 0.0
+	if req.Mechanism == DP_MECHANISM_GAUSSIAN {
+		delta = getEnvFloat("DP_DELTA", DP_DELTA)
+	}
+
+	budget, err := spendPrivacyBudget(ctx, req.DataType, req.Epsilon, delta)
+	if err == errPrivacyBudgetExhausted {
+		respondError(c, ERR_PRIVACY_BUDGET_EXHAUSTED, "The dataset's privacy budget cannot cover this query", budget.withRemaining())
+		return
+	}
+	if err != nil {
+		logger.Error("Privacy budget unavailable", "error", err)
+		respondError(c, ERR_INTERNAL, "Failed to spend privacy budget")
+		return
+	}
+
+	labels, values, l1, l2, err := runAggregateQuery(ctx, req)
+	if err != nil {
+		logger.Error("Aggregate query failed", "query", req.Query, "error", err)
+		refundPrivacyBudget(ctx, budget.DatasetID, req.Epsilon, delta)
+		respondError(c, ERR_INTERNAL, "Aggregate query failed")
+		return
+	}
+
+	var stdDev float64
+	results := make([]AggregateValue, len(values))
+	for i, value := range values {
+		var noise float64
+		switch req.Mechanism {
+		case DP_MECHANISM_GAUSSIAN:
+			sigma := math.Sqrt(2*math.Log(1.25/delta)) * l2 / req.Epsilon
+			noise, stdDev = gaussianNoise(sigma), sigma
+		default:
+			scale := l1 / req.Epsilon
+			noise, stdDev = laplaceNoise(scale), math.Sqrt2*scale
+		}
+		// Clamping is post-processing and costs no privacy
+		results[i] = AggregateValue{Label: labels[i], Value: math.Max(0, value+noise)}
+	}
+
+	_, err = db.Collection(AGGREGATE_QUERIES_COLLECTION).InsertOne(ctx, bson.M{
+		"datasetId": budget.DatasetID,
+		"buyer":     buyer,
+		"query":     req.Query,
+		"channels":  req.Channels,
+		"mechanism": req.Mechanism,
+		"epsilon":   req.Epsilon,
+		"delta":     delta,
+		"createdAt": time.Now(),
+	})
+	if err != nil {
+		logger.Warn("Failed to record aggregate query", "error", err)
+	}
+
+	c.JSON(http.StatusOK, AggregateQueryResponse{
+		DatasetID:   budget.DatasetID,
+		Query:       req.Query,
+		Mechanism:   req.Mechanism,
+		Epsilon:     req.Epsilon,
+		Delta:       delta,
+		NoiseStdDev: stdDev,
+		Values:      results,
+		Budget:      budget.withRemaining(),
+	})
+}
+
+// Compute the exact answer of a query together with its L1 and L2
+// sensitivity. Each contributor is one unit of privacy: a contributor's
+// contributions are merged and their influence on the answer is bounded.
+func runAggregateQuery(ctx context.Context, req AggregateQueryRequest) ([]string, []float64, float64, float64, error) {
+	switch req.Query {
+	case AGGREGATE_WATCH_TIME_BY_HOUR:
+		// Each contributor adds their share of views per UTC hour, summing to 1
+		hours := make(map[string]*[24]float64)
+		err := forEachAggregateContribution(ctx, req.DataType, func(address string, schema *YouTubeDataSchema) {
+			if granularity, ok := schema.Metadata["timestampGranularity"]; ok && granularity != TIME_BUCKET_HOUR {
+				return
+			}
+			counts := hours[address]
+			if counts == nil {
+				counts = new([24]float64)
+				hours[address] = counts
+			}
+			for _, entry := range schema.WatchHistory {
+				if entry.Kind != WATCH_ENTRY_AD && !entry.WatchTime.IsZero() {
+					counts[entry.WatchTime.UTC().Hour()]++
+				}
+			}
+		})
+		if err != nil {
+			return nil, nil, 0, 0, err
+		}
+
+		labels := make([]string, 24)
+		values := make([]float64, 24)
+		for hour := range labels {
+			labels[hour] = fmt.Sprintf("%02d:00", hour)
+		}
+		for _, counts := range hours {
+			total := 0.0
+			for _, count := range counts {
+				total += count
+			}
+			if total == 0 {
+				continue
+			}
+			for hour, count := range counts {
+				values[hour] += count / total
+			}
+		}
+		return labels, values, 1, 1, nil
+
+	case AGGREGATE_CHANNEL_OVERLAP:
+		// Contributors watching or subscribed to each channel and to both
+		first, second := channelKey(req.Channels[0], ""), channelKey(req.Channels[1], "")
+		audiences := make(map[string]uint8)
+		err := forEachAggregateContribution(ctx, req.DataType, func(address string, schema *YouTubeDataSchema) {
+			mark := func(key string) {
+				switch key {
+				case first:
+					audiences[address] |= 1
+				case second:
+					audiences[address] |= 2
+				}
+			}
+			for _, entry := range schema.WatchHistory {
+				if entry.Kind != WATCH_ENTRY_AD {
+					mark(channelKey(entry.ChannelID, entry.ChannelName))
+				}
+			}
+			for _, subscription := range schema.Subscriptions {
+				mark(channelKey(subscription.ChannelID, subscription.ChannelName))
+			}
+		})
+		if err != nil {
+			return nil, nil, 0, 0, err
+		}
+
+		values := make([]float64, 3)
+		for _, audience := range audiences {
+			if audience&1 != 0 {
+				values[0]++
+			}
+			if audience&2 != 0 {
+				values[1]++
+			}
+			if audience == 3 {
+				values[2]++
+			}
+		}
+		return []string{req.Channels[0], req.Channels[1], "both"}, values, 3, math.Sqrt(3), nil
+
+	default:
+		return nil, nil, 0, 0, fmt.Errorf("unknown aggregate query %q", req.Query)
+	}
+}
+
+// Call fn with the refined dataset of every unrevoked contribution of
+// dataType
+func forEachAggregateContribution(ctx context.Context, dataType string, fn func(address string, schema *YouTubeDataSchema)) error {
+	cursor, err := db.Collection("user_contributions").Find(ctx,
+		bson.M{"dataType": dataType, "revokedAt": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"address": 1, "dataContent": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to query contributions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var contribution struct {
+			Address     string            `bson:"address"`
+			DataContent YouTubeDataSchema `bson:"dataContent"`
+		}
+		if err := cursor.Decode(&contribution); err != nil {
+			loggerFromContext(ctx).Warn("Skipping undecodable contribution", "error", err)
+			continue
+		}
+		fn(contribution.Address, &contribution.DataContent)
+	}
+	return cursor.Err()
+}
+
+// Uniform sample in [0, 1) from the system CSPRNG
+func secureUniform() float64 {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return float64(binary.BigEndian.Uint64(buf[:])>>11) / (1 << 53)
+}
+
+// Sample Laplace(0, scale) by inverting its CDF
+func laplaceNoise(scale float64) float64 {
+	u := secureUniform() - 0.5
+	for u == -0.5 {
+		u = secureUniform() - 0.5
+	}
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
+
+// Sample N(0, sigma²) with the Box-Muller transform
+func gaussianNoise(sigma float64) float64 {
+	u1 := secureUniform()
+	for u1 == 0 {
+		u1 = secureUniform()
+	}
+	u2 := secureUniform()
+	return sigma * math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+}
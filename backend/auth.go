@@ -1,12 +1,14 @@
 package main
 
 import (
+	"container/list"
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
@@ -27,6 +29,8 @@ const (
 	JWT_EXPIRY_HOURS          = 24
 	TEMP_TOKEN_EXPIRY_MINUTES = 30
 	NONCE_EXPIRY_MINUTES      = 10
+	MEMBERSHIP_CACHE_TTL      = 30 * time.Second
+	MEMBERSHIP_CACHE_SIZE     = 10000
 )
 
 var (
@@ -36,22 +40,34 @@ var (
 	registryABI      abi.ABI
 )
 
+type membershipCacheEntry struct {
+	address   string
+	expiresAt time.Time
+}
+
+// Recently confirmed Registry members, least recently used first evicted
+var (
+	membershipCacheMu  sync.Mutex
+	membershipCache    = make(map[string]*list.Element)
+	membershipCacheLRU = list.New()
+)
+
 func initAuth(database *mongo.Database) {
 
 	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
 	if len(jwtSecret) == 0 {
-		log.Fatal("JWT_SECRET environment variable is required")
+		logFatal("JWT_SECRET environment variable is required")
 	}
 
 	var err error
-	ethClient, err = ethclient.Dial(VANA_MOKSHA_RPC)
+	ethClient, err = dialTracedEthClient(context.Background(), VANA_MOKSHA_RPC)
 	if err != nil {
-		log.Fatal("Failed to connect to Vana Moksha:", err)
+		logFatal("Failed to connect to Vana Moksha", "error", err)
 	}
 
 	registryContractAddr := os.Getenv("REGISTRY_CONTRACT_ADDRESS")
 	if registryContractAddr == "" {
-		log.Fatal("REGISTRY_CONTRACT_ADDRESS environment variable is required")
+		logFatal("REGISTRY_CONTRACT_ADDRESS environment variable is required")
 	}
 	registryContract = common.HexToAddress(registryContractAddr)
 
@@ -67,17 +83,17 @@ func initAuth(database *mongo.Database) {
 
 	registryABI, err = abi.JSON(strings.NewReader(registryABIJSON))
 	if err != nil {
-		log.Fatal("Failed to parse Registry ABI:", err)
+		logFatal("Failed to parse Registry ABI", "error", err)
 	}
 
-	log.Println("Auth system initialized with Vana Moksha connection")
+	slog.Info("Auth system initialized with Vana Moksha connection")
 }
 
 // Generate a nonce for SIWE authentication
 func generateNonce(c *gin.Context) {
 	var req NonceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
@@ -86,7 +102,7 @@ func generateNonce(c *gin.Context) {
 		"address":   req.Address,
 		"createdAt": bson.M{"$lt": time.Now().Add(-NONCE_EXPIRY_MINUTES * time.Minute)},
 	}
-	collection.DeleteMany(context.Background(), expiredFilter)
+	collection.DeleteMany(c.Request.Context(), expiredFilter)
 
 	nonce := strings.ReplaceAll(uuid.New().String(), "-", "")[:20]
 	nonceDoc := Nonce{
@@ -96,10 +112,10 @@ func generateNonce(c *gin.Context) {
 		Used:      false,
 	}
 
-	_, err := collection.InsertOne(context.Background(), nonceDoc)
+	_, err := collection.InsertOne(c.Request.Context(), nonceDoc)
 	if err != nil {
-		log.Printf("Failed to store nonce: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate nonce"})
+		loggerFromContext(c.Request.Context()).Error("Failed to store nonce", "error", err)
+		respondError(c, ERR_INTERNAL, "Failed to generate nonce")
 		return
 	}
 
@@ -110,19 +126,23 @@ func generateNonce(c *gin.Context) {
 func verifySIWE(c *gin.Context) {
 	var req SIWERequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		siweVerifications.WithLabelValues("invalid_request").Inc()
+		respondBindError(c, err)
 		return
 	}
 
 	message, err := siwe.ParseMessage(req.Message)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid SIWE message format"})
+		siweVerifications.WithLabelValues("invalid_message").Inc()
+		respondError(c, ERR_INVALID_SIWE_MESSAGE, "Invalid SIWE message format")
 		return
 	}
 
+	logger := loggerFromContext(c.Request.Context())
+
 	collection := db.Collection("nonces")
 	var nonceDoc Nonce
-	err = collection.FindOne(context.Background(), bson.M{
+	err = collection.FindOne(c.Request.Context(), bson.M{
 		"address":   message.GetAddress().Hex(),
 		"nonce":     message.GetNonce(),
 		"used":      false,
@@ -131,41 +151,45 @@ func verifySIWE(c *gin.Context) {
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired nonce"})
+			siweVerifications.WithLabelValues("invalid_nonce").Inc()
+			respondError(c, ERR_INVALID_NONCE, "Invalid or expired nonce")
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify nonce"})
+			siweVerifications.WithLabelValues("error").Inc()
+			respondError(c, ERR_INTERNAL, "Failed to verify nonce")
 		}
 		return
 	}
 
 	publicKey, err := message.VerifyEIP191(req.Signature)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		siweVerifications.WithLabelValues("invalid_signature").Inc()
+		respondError(c, ERR_INVALID_SIGNATURE, "Invalid signature")
 		return
 	}
 
 	if publicKey == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		siweVerifications.WithLabelValues("invalid_signature").Inc()
+		respondError(c, ERR_INVALID_SIGNATURE, "Invalid signature")
 		return
 	}
 
-	collection.UpdateOne(context.Background(),
+	collection.UpdateOne(c.Request.Context(),
 		bson.M{"_id": nonceDoc.ID},
 		bson.M{"$set": bson.M{"used": true}})
 
 	identityCollection := db.Collection("moksha_identities")
 	var identity MokshaIdentity
-	err = identityCollection.FindOne(context.Background(), bson.M{
+	err = identityCollection.FindOne(c.Request.Context(), bson.M{
 		"address":  message.GetAddress().Hex(),
 		"isActive": true,
 	}).Decode(&identity)
 
 	if err == nil {
-		log.Printf("User %s already has Moksha identity, proceeding with full auth", message.GetAddress().Hex())
+		logger.Info("User already has Moksha identity, proceeding with full auth", "address", message.GetAddress().Hex())
 		token, expiresAt, err := generateJWT(message.GetAddress().Hex(), message.GetChainID())
 		if err != nil {
-			log.Printf("Failed to generate JWT: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+			logger.Error("Failed to generate JWT", "error", err)
+			respondError(c, ERR_INTERNAL, "Failed to generate authentication token")
 			return
 		}
 
@@ -178,8 +202,9 @@ func verifySIWE(c *gin.Context) {
 			CreatedAt: time.Now(),
 			IsActive:  true,
 		}
-		sessionCollection.InsertOne(context.Background(), session)
+		sessionCollection.InsertOne(c.Request.Context(), session)
 
+		siweVerifications.WithLabelValues("success").Inc()
 		c.JSON(http.StatusOK, AuthResponse{
 			Token:     token,
 			Address:   message.GetAddress().Hex(),
@@ -189,15 +214,16 @@ func verifySIWE(c *gin.Context) {
 		return
 	}
 
-	log.Printf("User %s needs Moksha registration (identity not found: %v)", message.GetAddress().Hex(), err)
+	logger.Info("User needs Moksha registration", "address", message.GetAddress().Hex(), "error", err)
 
 	tempToken, tempExpiresAt, err := generateTempToken(message.GetAddress().Hex(), message.GetChainID())
 	if err != nil {
-		log.Printf("Failed to generate temp token: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate temporary token"})
+		logger.Error("Failed to generate temp token", "error", err)
+		respondError(c, ERR_INTERNAL, "Failed to generate temporary token")
 		return
 	}
 
+	siweVerifications.WithLabelValues("registration_required").Inc()
 	c.JSON(http.StatusAccepted, TempAuthResponse{
 		TempToken: tempToken,
 		Address:   message.GetAddress().Hex(),
@@ -207,7 +233,7 @@ func verifySIWE(c *gin.Context) {
 }
 
 // Check if address is a member of the Registry contract
-func checkRegistryMembership(address string) (bool, error) {
+func checkRegistryMembership(ctx context.Context, address string) (bool, error) {
 	memberAddress := common.HexToAddress(address)
 
 	data, err := registryABI.Pack("isMember", memberAddress)
@@ -215,7 +241,7 @@ func checkRegistryMembership(address string) (bool, error) {
 		return false, fmt.Errorf("failed to pack function call: %v", err)
 	}
 
-	result, err := ethClient.CallContract(context.Background(), ethereum.CallMsg{
+	result, err := ethClient.CallContract(ctx, ethereum.CallMsg{
 		To:   &registryContract,
 		Data: data,
 	}, nil)
@@ -232,6 +258,52 @@ func checkRegistryMembership(address string) (bool, error) {
 	return isMember, nil
 }
 
+// Check Registry membership, reusing recent positive results to avoid an
+// RPC call per request. Only members are cached, so newly registered members
+// are let in at once; removed members keep access for at most
+// MEMBERSHIP_CACHE_TTL. At most MEMBERSHIP_CACHE_SIZE members are kept.
+func checkRegistryMembershipCached(ctx context.Context, address string) (bool, error) {
+	key := strings.ToLower(address)
+
+	membershipCacheMu.Lock()
+	if element, found := membershipCache[key]; found {
+		if time.Now().Before(element.Value.(*membershipCacheEntry).expiresAt) {
+			membershipCacheLRU.MoveToFront(element)
+			membershipCacheMu.Unlock()
+			registryMembershipCache.WithLabelValues("hit").Inc()
+			return true, nil
+		}
+		membershipCacheLRU.Remove(element)
+		delete(membershipCache, key)
+	}
+	membershipCacheMu.Unlock()
+	registryMembershipCache.WithLabelValues("miss").Inc()
+
+	isMember, err := checkRegistryMembership(ctx, address)
+	if err != nil || !isMember {
+		return isMember, err
+	}
+
+	ttl := getEnvDuration("MEMBERSHIP_CACHE_TTL", MEMBERSHIP_CACHE_TTL)
+	if ttl <= 0 {
+		return true, nil
+	}
+	membershipCacheMu.Lock()
+	defer membershipCacheMu.Unlock()
+	if element, found := membershipCache[key]; found {
+		element.Value.(*membershipCacheEntry).expiresAt = time.Now().Add(ttl)
+		membershipCacheLRU.MoveToFront(element)
+		return true, nil
+	}
+	membershipCache[key] = membershipCacheLRU.PushFront(&membershipCacheEntry{address: key, expiresAt: time.Now().Add(ttl)})
+	for membershipCacheLRU.Len() > getEnvInt("MEMBERSHIP_CACHE_SIZE", MEMBERSHIP_CACHE_SIZE) {
+		oldest := membershipCacheLRU.Back()
+		membershipCacheLRU.Remove(oldest)
+		delete(membershipCache, oldest.Value.(*membershipCacheEntry).address)
+	}
+	return true, nil
+}
+
 // Generate JWT token
 func generateJWT(address string, chainID int) (string, int64, error) {
 	expiresAt := time.Now().Add(JWT_EXPIRY_HOURS * time.Hour)
@@ -285,15 +357,13 @@ func jwtMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+			respondError(c, ERR_AUTH_REQUIRED, "Authorization header required")
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bearer token required"})
-			c.Abort()
+			respondError(c, ERR_AUTH_REQUIRED, "Bearer token required")
 			return
 		}
 
@@ -305,23 +375,21 @@ func jwtMiddleware() gin.HandlerFunc {
 		})
 
 		if err != nil {
-			log.Printf("JWT middleware: token parsing failed: %v", err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+			loggerFromContext(c.Request.Context()).Warn("JWT middleware: token parsing failed", "error", err)
+			respondError(c, ERR_INVALID_TOKEN, "Invalid token")
 			return
 		}
 
 		claims, ok := token.Claims.(*JWTClaims)
 		if !ok || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
+			respondError(c, ERR_INVALID_TOKEN, "Invalid token claims")
 			return
 		}
 
 		if claims.Issuer != "tubedao-backend-temp" {
 			sessionCollection := db.Collection("auth_sessions")
 			var session AuthSession
-			err = sessionCollection.FindOne(context.Background(), bson.M{
+			err = sessionCollection.FindOne(c.Request.Context(), bson.M{
 				"address":   claims.Address,
 				"token":     tokenString,
 				"isActive":  true,
@@ -330,32 +398,29 @@ func jwtMiddleware() gin.HandlerFunc {
 
 			if err != nil {
 				if err == mongo.ErrNoDocuments {
-					c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or invalid"})
+					respondError(c, ERR_SESSION_EXPIRED, "Session expired or invalid")
 				} else {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify session"})
+					respondError(c, ERR_INTERNAL, "Failed to verify session")
 				}
-				c.Abort()
 				return
 			}
 		}
 
 		if claims.Issuer != "tubedao-backend-temp" {
-			isMember, err := checkRegistryMembership(claims.Address)
+			isMember, err := checkRegistryMembershipCached(c.Request.Context(), claims.Address)
 			if err != nil {
-				log.Printf("Failed to re-verify membership for %s: %v", claims.Address, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify membership"})
-				c.Abort()
+				loggerFromContext(c.Request.Context()).Error("Failed to re-verify membership", "address", claims.Address, "error", err)
+				respondError(c, ERR_CHAIN_UNAVAILABLE, "Failed to verify membership")
 				return
 			}
 
 			if !isMember {
 				sessionCollection := db.Collection("auth_sessions")
-				sessionCollection.UpdateOne(context.Background(),
+				sessionCollection.UpdateOne(c.Request.Context(),
 					bson.M{"token": tokenString},
 					bson.M{"$set": bson.M{"isActive": false}})
 
-				c.JSON(http.StatusForbidden, gin.H{"error": "Registry membership required"})
-				c.Abort()
+				respondError(c, ERR_REGISTRY_MEMBERSHIP_REQUIRED, "Registry membership required")
 				return
 			}
 		}
@@ -371,15 +436,13 @@ func jwtAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+			respondError(c, ERR_AUTH_REQUIRED, "Authorization header required")
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bearer token required"})
-			c.Abort()
+			respondError(c, ERR_AUTH_REQUIRED, "Bearer token required")
 			return
 		}
 
@@ -391,23 +454,21 @@ func jwtAuthMiddleware() gin.HandlerFunc {
 		})
 
 		if err != nil {
-			log.Printf("JWT middleware: token parsing failed: %v", err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+			loggerFromContext(c.Request.Context()).Warn("JWT middleware: token parsing failed", "error", err)
+			respondError(c, ERR_INVALID_TOKEN, "Invalid token")
 			return
 		}
 
 		claims, ok := token.Claims.(*JWTClaims)
 		if !ok || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
+			respondError(c, ERR_INVALID_TOKEN, "Invalid token claims")
 			return
 		}
 
 		if claims.Issuer != "tubedao-backend-temp" {
 			sessionCollection := db.Collection("auth_sessions")
 			var session AuthSession
-			err = sessionCollection.FindOne(context.Background(), bson.M{
+			err = sessionCollection.FindOne(c.Request.Context(), bson.M{
 				"address":   claims.Address,
 				"token":     tokenString,
 				"isActive":  true,
@@ -416,17 +477,19 @@ func jwtAuthMiddleware() gin.HandlerFunc {
 
 			if err != nil {
 				if err == mongo.ErrNoDocuments {
-					c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or invalid"})
+					respondError(c, ERR_SESSION_EXPIRED, "Session expired or invalid")
 				} else {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify session"})
+					respondError(c, ERR_INTERNAL, "Failed to verify session")
 				}
-				c.Abort()
 				return
 			}
 		}
 
 		c.Set("address", claims.Address)
 		c.Set("chainId", claims.ChainID)
+		if claims.ExpiresAt != nil {
+			c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		}
 		c.Next()
 	}
 }
@@ -435,40 +498,41 @@ func jwtAuthMiddleware() gin.HandlerFunc {
 func logout(c *gin.Context) {
 	authHeader := c.GetHeader("Authorization")
 	if authHeader == "" {
-		c.JSON(http.StatusOK, gin.H{"message": "Already logged out"})
+		c.JSON(http.StatusOK, MessageResponse{Message: "Already logged out"})
 		return
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 	if tokenString == authHeader {
-		c.JSON(http.StatusOK, gin.H{"message": "Already logged out"})
+		c.JSON(http.StatusOK, MessageResponse{Message: "Already logged out"})
 		return
 	}
 
 	sessionCollection := db.Collection("auth_sessions")
-	sessionCollection.UpdateMany(context.Background(),
+	sessionCollection.UpdateMany(c.Request.Context(),
 		bson.M{"token": tokenString},
 		bson.M{"$set": bson.M{"isActive": false}})
+	notices.ExpireToken(tokenString)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Logged out successfully"})
 }
 
 // Bind Moksha identity for existing users
 func bindMokshaIdentity(c *gin.Context) {
 	var req BindMokshaRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
 	address, exists := c.Get("address")
 	if !exists {
-		log.Printf("bindMokshaIdentity: address not found in context")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		loggerFromContext(c.Request.Context()).Warn("bindMokshaIdentity: address not found in context")
+		respondError(c, ERR_INVALID_TOKEN, "Invalid token")
 		return
 	}
 
-	log.Printf("bindMokshaIdentity: processing request for address %s", address)
+	loggerFromContext(c.Request.Context()).Info("bindMokshaIdentity: processing request", "address", address)
 
 	// Verify binding signature
 	// TODO: Add signature verification for binding message
@@ -476,7 +540,7 @@ func bindMokshaIdentity(c *gin.Context) {
 	// Check if user already has identity
 	identityCollection := db.Collection("moksha_identities")
 	var identity MokshaIdentity
-	err := identityCollection.FindOne(context.Background(), bson.M{
+	err := identityCollection.FindOne(c.Request.Context(), bson.M{
 		"address":  address,
 		"isActive": true,
 	}).Decode(&identity)
@@ -485,7 +549,7 @@ func bindMokshaIdentity(c *gin.Context) {
 		// User already has identity, generate final token
 		token, expiresAt, err := generateJWT(address.(string), VANA_MOKSHA_CHAIN_ID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			respondError(c, ERR_INTERNAL, "Failed to generate token")
 			return
 		}
 
@@ -499,9 +563,9 @@ func bindMokshaIdentity(c *gin.Context) {
 	}
 
 	// User needs registration
-	c.JSON(http.StatusAccepted, gin.H{
-		"error":              "registration required",
-		"registrationNeeded": true,
+	c.JSON(http.StatusAccepted, RegistrationNeededResponse{
+		Error:              "registration required",
+		RegistrationNeeded: true,
 	})
 }
 
@@ -509,7 +573,7 @@ func bindMokshaIdentity(c *gin.Context) {
 func registerWithMoksha(c *gin.Context) {
 	var req RegisterMokshaRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
@@ -538,16 +602,19 @@ func registerWithMoksha(c *gin.Context) {
 		BindingSignature: req.BindingSignature,
 		Status:           "pending",
 		CreatedAt:        time.Now(),
+		RequestID:        requestIDFromContext(c.Request.Context()),
 	}
 
-	_, err := registrationCollection.InsertOne(context.Background(), registration)
+	_, err := registrationCollection.InsertOne(c.Request.Context(), registration)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create registration"})
+		respondError(c, ERR_INTERNAL, "Failed to create registration")
 		return
 	}
 
 	// Start relayer process (async)
-	go processRegistration(registrationID)
+	enqueueJob(c.Request.Context(), "process-registration", func(ctx context.Context) error {
+		return processRegistration(ctx, registrationID)
+	})
 
 	c.JSON(http.StatusOK, RegistrationResponse{
 		RegistrationID: registrationID,
@@ -561,15 +628,15 @@ func checkRegistrationStatus(c *gin.Context) {
 
 	registrationCollection := db.Collection("moksha_registrations")
 	var registration MokshaRegistration
-	err := registrationCollection.FindOne(context.Background(), bson.M{
+	err := registrationCollection.FindOne(c.Request.Context(), bson.M{
 		"registrationId": registrationID,
 	}).Decode(&registration)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Registration not found"})
+			respondError(c, ERR_REGISTRATION_NOT_FOUND, "Registration not found")
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check registration"})
+			respondError(c, ERR_INTERNAL, "Failed to check registration")
 		}
 		return
 	}
@@ -578,7 +645,7 @@ func checkRegistrationStatus(c *gin.Context) {
 		// Generate final auth token
 		token, expiresAt, err := generateJWT(registration.Address, VANA_MOKSHA_CHAIN_ID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			respondError(c, ERR_INTERNAL, "Failed to generate token")
 			return
 		}
 
@@ -609,25 +676,31 @@ func checkRegistrationStatus(c *gin.Context) {
 }
 
 // Process registration with relayer (mock implementation)
-func processRegistration(registrationID string) {
+func processRegistration(ctx context.Context, registrationID string) error {
 	registrationCollection := db.Collection("moksha_registrations")
 
 	// Update status to processing
-	registrationCollection.UpdateOne(context.Background(),
+	registrationCollection.UpdateOne(ctx,
 		bson.M{"registrationId": registrationID},
 		bson.M{"$set": bson.M{"status": "processing"}})
 
 	// Simulate relayer processing time
-	time.Sleep(10 * time.Second)
+	if err := sleepContext(ctx, 10*time.Second); err != nil {
+		// Leave the registration retryable instead of stuck in processing
+		registrationCollection.UpdateOne(context.Background(),
+			bson.M{"registrationId": registrationID},
+			bson.M{"$set": bson.M{"status": "pending"}})
+		return err
+	}
 
 	// Get registration
 	var registration MokshaRegistration
-	err := registrationCollection.FindOne(context.Background(), bson.M{
+	err := registrationCollection.FindOne(ctx, bson.M{
 		"registrationId": registrationID,
 	}).Decode(&registration)
 
 	if err != nil {
-		return
+		return fmt.Errorf("failed to load registration %s: %v", registrationID, err)
 	}
 
 	// Simulate successful registration
@@ -636,7 +709,7 @@ func processRegistration(registrationID string) {
 	completedAt := time.Now()
 
 	// Update registration as completed
-	registrationCollection.UpdateOne(context.Background(),
+	registrationCollection.UpdateOne(ctx,
 		bson.M{"registrationId": registrationID},
 		bson.M{"$set": bson.M{
 			"status":      "completed",
@@ -654,9 +727,10 @@ func processRegistration(registrationID string) {
 		LastVerified:  completedAt,
 	}
 
-	identityCollection.InsertOne(context.Background(), identity)
+	identityCollection.InsertOne(ctx, identity)
 
-	log.Printf("Registration completed for %s -> %s", registration.Address, registration.MokshaAddress)
+	loggerFromContext(ctx).Info("Registration completed", "address", registration.Address, "mokshaAddress", registration.MokshaAddress)
+	return nil
 }
 
 // Generate Moksha address (mock implementation)
@@ -670,14 +744,13 @@ func generateMokshaAddress(address string) string {
 func authStatus(c *gin.Context) {
 	address, exists := c.Get("address")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"authenticated": false})
+		c.JSON(http.StatusUnauthorized, AuthStatusResponse{Authenticated: false})
 		return
 	}
 
-	chainId, _ := c.Get("chainId")
-	c.JSON(http.StatusOK, gin.H{
-		"authenticated": true,
-		"address":       address,
-		"chainId":       chainId,
+	c.JSON(http.StatusOK, AuthStatusResponse{
+		Authenticated: true,
+		Address:       address.(string),
+		ChainID:       c.GetInt("chainId"),
 	})
 }
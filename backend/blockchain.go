@@ -4,14 +4,19 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/big"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -27,6 +32,16 @@ var (
 	backendAuth       *bind.TransactOpts
 )
 
+// Longest a submitted transaction is watched for its receipt
+const TX_RECEIPT_TIMEOUT = 10 * time.Minute
+
+// Receipt watchers run outside the job queue so slow blocks cannot starve
+// other jobs or hold up draining
+var (
+	txWatchers              sync.WaitGroup
+	txWatchCtx, stopTxWatch = context.WithCancel(context.Background())
+)
+
 var _, _, _ = tubeTokenAddress, governanceAddress, tubeTokenABI
 
 // Initialize blockchain connections and contracts
@@ -50,12 +65,13 @@ func initBlockchain() error {
 		}
 	}
 
-	log.Println("Blockchain integration initialized")
+	slog.Info("Blockchain integration initialized")
 	return nil
 }
 
 // Submit data contribution to smart contract
 func submitContributionToChain(
+	ctx context.Context,
 	contributor common.Address,
 	dataType string,
 	dataHash [32]byte,
@@ -69,8 +85,9 @@ func submitContributionToChain(
 		ethClient,
 	)
 
-	tx, err := contract.Transact(
-		backendAuth,
+	tx, err := transactContract(
+		ctx,
+		contract,
 		"submitDataContribution",
 		dataType,
 		dataHash,
@@ -81,11 +98,95 @@ func submitContributionToChain(
 		return common.Hash{}, fmt.Errorf("failed to submit contribution: %v", err)
 	}
 
+	trackTransaction(ctx, "submitDataContribution", tx, nil)
 	return tx.Hash(), nil
 }
 
-// Validate contribution through TEE integration
-func validateContribution(contributionHash [32]byte, qualityScore uint8) error {
+// Send a contract transaction from the backend signer and count the submission
+func transactContract(ctx context.Context, contract *bind.BoundContract, method string, params ...interface{}) (*types.Transaction, error) {
+	if backendAuth == nil {
+		return nil, fmt.Errorf("backend signer not configured")
+	}
+
+	ctx, end := startSpan(ctx, "chain.transact "+method, attribute.String("chain.method", method))
+
+	opts := *backendAuth
+	opts.Context = ctx
+
+	tx, err := contract.Transact(&opts, method, params...)
+	if err != nil {
+		chainTxFailures.WithLabelValues(method, "submit").Inc()
+		end(err)
+		return nil, err
+	}
+
+	chainTxSubmissions.WithLabelValues(method).Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("chain.tx_hash", tx.Hash().Hex()))
+	end(nil)
+	return tx, nil
+}
+
+// Wait until tx is mined and record its receipt
+func waitMined(ctx context.Context, method string, tx *types.Transaction) (*types.Receipt, error) {
+	ctx, end := startSpan(ctx, "chain.wait_mined "+method,
+		attribute.String("chain.method", method),
+		attribute.String("chain.tx_hash", tx.Hash().Hex()),
+	)
+
+	receipt, err := bind.WaitMined(ctx, ethClient, tx)
+	if err != nil {
+		chainTxFailures.WithLabelValues(method, "receipt").Inc()
+		end(err)
+		return nil, err
+	}
+
+	observeReceipt(method, receipt)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("chain.gas_used", int64(receipt.GasUsed)))
+	end(nil)
+	return receipt, nil
+}
+
+// Wait for a submitted transaction in its own goroutine and record its
+// receipt, giving up after TX_RECEIPT_TIMEOUT or on shutdown. onSuccess,
+// if set, runs once the transaction is mined without reverting.
+func trackTransaction(ctx context.Context, method string, tx *types.Transaction, onSuccess func(ctx context.Context)) {
+	loggerFromContext(ctx).Info("Transaction submitted", "method", method, "txHash", tx.Hash().Hex())
+
+	// Keep the submitting request's ID and trace, not its cancellation
+	watchCtx := contextWithRequestID(txWatchCtx, requestIDFromContext(ctx))
+	watchCtx = trace.ContextWithSpanContext(watchCtx, trace.SpanContextFromContext(ctx))
+
+	txWatchers.Add(1)
+	go func() {
+		defer txWatchers.Done()
+		ctx, cancel := context.WithTimeout(watchCtx, getEnvDuration("TX_RECEIPT_TIMEOUT", TX_RECEIPT_TIMEOUT))
+		defer cancel()
+
+		receipt, err := waitMined(ctx, method, tx)
+		if err != nil {
+			loggerFromContext(ctx).Warn("Stopped waiting for transaction", "method", method, "txHash", tx.Hash().Hex(), "error", err)
+			return
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			loggerFromContext(ctx).Warn("Transaction reverted", "method", method, "txHash", tx.Hash().Hex())
+			return
+		}
+		if onSuccess != nil {
+			onSuccess(ctx)
+		}
+	}()
+}
+
+// Stop watching for receipts. Pending transactions are still mined; only
+// their receipts go unrecorded.
+func stopTransactionWatchers() {
+	stopTxWatch()
+	txWatchers.Wait()
+}
+
+// Validate contribution through TEE integration, calling onValidated once
+// the validation is mined
+func validateContribution(ctx context.Context, contributionHash [32]byte, qualityScore uint8, onValidated func(ctx context.Context)) error {
 	contract := bind.NewBoundContract(
 		dataPoolAddress,
 		dataPoolABI,
@@ -94,8 +195,9 @@ func validateContribution(contributionHash [32]byte, qualityScore uint8) error {
 		ethClient,
 	)
 
-	tx, err := contract.Transact(
-		backendAuth,
+	tx, err := transactContract(
+		ctx,
+		contract,
 		"validateContribution",
 		contributionHash,
 		big.NewInt(int64(qualityScore)),
@@ -104,7 +206,8 @@ func validateContribution(contributionHash [32]byte, qualityScore uint8) error {
 		return fmt.Errorf("failed to validate contribution: %v", err)
 	}
 
-	log.Printf("Contribution validated: %s, tx: %s", contributionHash, tx.Hash())
+	trackTransaction(ctx, "validateContribution", tx, onValidated)
+	loggerFromContext(ctx).Info("Contribution validated", "dataHash", common.Hash(contributionHash).Hex(), "txHash", tx.Hash().Hex())
 	return nil
 }
 
@@ -169,7 +272,7 @@ func getContributorScore(address common.Address) (*big.Int, error) {
 }
 
 // Create TEE validation job
-func createTEEValidationJob(dataHash [32]byte, dataType string) ([32]byte, error) {
+func createTEEValidationJob(ctx context.Context, dataHash [32]byte, dataType string) ([32]byte, error) {
 	contract := bind.NewBoundContract(
 		teeIntegrationAddr,
 		dataPoolABI,
@@ -178,8 +281,9 @@ func createTEEValidationJob(dataHash [32]byte, dataType string) ([32]byte, error
 		ethClient,
 	)
 
-	tx, err := contract.Transact(
-		backendAuth,
+	tx, err := transactContract(
+		ctx,
+		contract,
 		"createValidationJob",
 		dataHash,
 		dataType,
@@ -188,7 +292,7 @@ func createTEEValidationJob(dataHash [32]byte, dataType string) ([32]byte, error
 		return [32]byte{}, fmt.Errorf("failed to create validation job: %v", err)
 	}
 
-	receipt, err := bind.WaitMined(context.Background(), ethClient, tx)
+	receipt, err := waitMined(ctx, "createValidationJob", tx)
 	if err != nil {
 		return [32]byte{}, fmt.Errorf("failed to wait for transaction: %v", err)
 	}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const EVENT_CONSENTS_COLLECTION = "event_consents"
+
+// Whether address currently allows event collection. Users who never
+// changed the setting are considered to have consented when they installed
+// and unlocked the extension.
+func eventConsentGranted(ctx context.Context, address string) (bool, error) {
+	var consent EventConsent
+	err := db.Collection(EVENT_CONSENTS_COLLECTION).FindOne(ctx, bson.M{"address": address}).Decode(&consent)
+	if err == mongo.ErrNoDocuments {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return consent.Enabled, nil
+}
+
+// Abort with an error unless address allows event collection
+func requireEventConsent(c *gin.Context, address string) bool {
+	granted, err := eventConsentGranted(c.Request.Context(), address)
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to check consent")
+		return false
+	}
+	if !granted {
+		respondError(c, ERR_CONSENT_REVOKED, "Event collection consent has been revoked")
+		return false
+	}
+	return true
+}
+
+// Get the event collection consent of a user
+func getEventConsent(c *gin.Context) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	consent := EventConsent{Address: address, Enabled: true}
+	err := db.Collection(EVENT_CONSENTS_COLLECTION).FindOne(c.Request.Context(), bson.M{"address": address}).Decode(&consent)
+	if err != nil && err != mongo.ErrNoDocuments {
+		respondError(c, ERR_INTERNAL, "Failed to fetch consent")
+		return
+	}
+
+	c.JSON(http.StatusOK, consent)
+}
+
+// Grant or revoke event collection consent. Revoking notifies every
+// connected collector of the user so it stops capturing immediately.
+func updateEventConsent(c *gin.Context) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	var req UpdateConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	consent := EventConsent{Address: address, Enabled: *req.Enabled, UpdatedAt: time.Now()}
+	_, err := db.Collection(EVENT_CONSENTS_COLLECTION).UpdateOne(c.Request.Context(),
+		bson.M{"address": address},
+		bson.M{"$set": consent},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to update consent")
+		return
+	}
+
+	if consent.Enabled {
+		notices.RestoreConsent(address)
+	} else {
+		notices.Publish(address, Notice{Type: NOTICE_CONSENT_REVOKED})
+	}
+
+	c.JSON(http.StatusOK, consent)
+}
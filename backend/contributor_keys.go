@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const CONTRIBUTOR_KEYS_COLLECTION = "contributor_keys"
+
+// Parse a hex secp256k1 public key, compressed or uncompressed, naming
+// field in errors
+func parsePublicKey(field, value string) (*ecdsa.PublicKey, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("%s must be hex-encoded", field)
+	}
+	switch len(raw) {
+	case 33:
+		return crypto.DecompressPubkey(raw)
+	case 65:
+		return crypto.UnmarshalPubkey(raw)
+	default:
+		return nil, fmt.Errorf("%s must be a 33- or 65-byte secp256k1 key", field)
+	}
+}
+
+// Encryption key of a contributor, or nil if they hold none
+func loadContributorKey(ctx context.Context, address string) (*ContributorKey, *ecies.PublicKey, error) {
+	var stored ContributorKey
+	err := db.Collection(CONTRIBUTOR_KEYS_COLLECTION).FindOne(ctx, bson.M{"_id": address}).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load contributor key: %v", err)
+	}
+
+	publicKey, err := parsePublicKey("publicKey", stored.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("stored contributor key is invalid: %v", err)
+	}
+	return &stored, ecies.ImportECDSAPublic(publicKey), nil
+}
+
+// Encrypt a data key to a contributor's key
+func encryptToContributor(publicKey *ecies.PublicKey, key []byte) ([]byte, error) {
+	encrypted, err := ecies.Encrypt(rand.Reader, publicKey, key, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt data key to contributor: %v", err)
+	}
+	return encrypted, nil
+}
+
+// Give a contributor a copy of the data keys of their datasets under their
+// current encryption key, replacing copies under older keys. Revoked keys
+// are skipped: the backend no longer holds them.
+func wrapContributorDataKeys(ctx context.Context, address string) (int, error) {
+	if keyManager == nil {
+		return 0, fmt.Errorf("key management is not configured")
+	}
+	contributorKey, publicKey, err := loadContributorKey(ctx, address)
+	if err != nil || contributorKey == nil {
+		return 0, err
+	}
+
+	keys := db.Collection(DATA_KEYS_COLLECTION)
+	cursor, err := keys.Find(ctx, bson.M{
+		"contributor":           address,
+		"revokedAt":             bson.M{"$exists": false},
+		"contributorKeyAddress": bson.M{"$ne": contributorKey.KeyAddress},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query data keys: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	wrapped := 0
+	for cursor.Next(ctx) {
+		var stored WrappedDataKey
+		if err := cursor.Decode(&stored); err != nil {
+			return wrapped, fmt.Errorf("failed to decode data key: %v", err)
+		}
+		datasetHash := common.HexToHash(stored.DatasetHash)
+
+		key, err := keyManager.Unwrap(ctx, stored.KeyID, stored.WrappedKey, datasetHash[:])
+		if err != nil {
+			return wrapped, fmt.Errorf("dataset %s: %v", stored.DatasetHash, err)
+		}
+		encrypted, err := encryptToContributor(publicKey, key)
+		if err != nil {
+			return wrapped, fmt.Errorf("dataset %s: %v", stored.DatasetHash, err)
+		}
+
+		result, err := keys.UpdateOne(ctx,
+			bson.M{"_id": stored.DatasetHash, "revokedAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"contributorKey": encrypted, "contributorKeyAddress": contributorKey.KeyAddress}})
+		if err != nil {
+			return wrapped, fmt.Errorf("failed to store contributor key copy: %v", err)
+		}
+		wrapped += int(result.ModifiedCount)
+	}
+	if err := cursor.Err(); err != nil {
+		return wrapped, err
+	}
+
+	loggerFromContext(ctx).Info("Encrypted data keys to contributor", "address", address, "keys", wrapped)
+	return wrapped, nil
+}
+
+// Get the encryption key a user's datasets are encrypted to
+func getContributorKey(c *gin.Context) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	contributorKey, _, err := loadContributorKey(c.Request.Context(), address)
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to fetch encryption key")
+		return
+	}
+	if contributorKey == nil {
+		respondError(c, ERR_CONTRIBUTOR_KEY_NOT_FOUND, "No encryption key is registered")
+		return
+	}
+
+	c.JSON(http.StatusOK, contributorKey)
+}
+
+// Register the public key a user's data keys are encrypted to. Wallets
+// cannot decrypt ECIES with their signing key, so clients derive a keypair
+// from a signature and send its public key. Data keys of earlier datasets
+// are encrypted to it in the background.
+func setContributorKey(c *gin.Context) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	var req SetContributorKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	publicKey, err := parsePublicKey("publicKey", req.PublicKey)
+	if err != nil {
+		respondError(c, ERR_INVALID_REQUEST, err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	contributorKey := ContributorKey{
+		Address:    address,
+		PublicKey:  "0x" + hex.EncodeToString(crypto.FromECDSAPub(publicKey)),
+		KeyAddress: crypto.PubkeyToAddress(*publicKey).Hex(),
+		CreatedAt:  time.Now(),
+	}
+	_, err = db.Collection(CONTRIBUTOR_KEYS_COLLECTION).ReplaceOne(ctx,
+		bson.M{"_id": address}, contributorKey, options.Replace().SetUpsert(true))
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to store encryption key")
+		return
+	}
+
+	enqueueJob(ctx, "wrap-contributor-data-keys", func(ctx context.Context) error {
+		_, err := wrapContributorDataKeys(ctx, address)
+		return err
+	})
+
+	c.JSON(http.StatusOK, contributorKey)
+}
+
+// Stop encrypting new data keys to the user. Copies they already hold stay
+// valid.
+func deleteContributorKey(c *gin.Context) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	result, err := db.Collection(CONTRIBUTOR_KEYS_COLLECTION).DeleteOne(c.Request.Context(), bson.M{"_id": address})
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to delete encryption key")
+		return
+	}
+	if result.DeletedCount == 0 {
+		respondError(c, ERR_CONTRIBUTOR_KEY_NOT_FOUND, "No encryption key is registered")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// List the data keys of a user's datasets with the copies encrypted to them
+func getContributorDataKeys(c *gin.Context) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	ctx := c.Request.Context()
+	cursor, err := db.Collection(DATA_KEYS_COLLECTION).Find(ctx, bson.M{"contributor": address})
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to fetch data keys")
+		return
+	}
+	var stored []WrappedDataKey
+	if err := cursor.All(ctx, &stored); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to fetch data keys")
+		return
+	}
+
+	keys := make([]ContributorDataKey, 0, len(stored))
+	for _, key := range stored {
+		entry := ContributorDataKey{
+			DatasetHash: key.DatasetHash,
+			DataType:    key.DataType,
+			Variant:     key.Variant,
+			KeyAddress:  key.ContributorKeyAddress,
+			CreatedAt:   key.CreatedAt,
+			RevokedAt:   key.RevokedAt,
+		}
+		if len(key.ContributorKey) > 0 {
+			entry.EncryptedKey = "0x" + hex.EncodeToString(key.ContributorKey)
+		}
+		keys = append(keys, entry)
+	}
+
+	c.JSON(http.StatusOK, ContributorDataKeysResponse{Data: keys})
+}
+
+// Revoke a contribution through one of its datasets. Every masked variant
+// refined from the same upload goes with it: the backend deletes its copies
+// of their data keys, so it can no longer decrypt them or release their keys
+// to buyers, and drops the plaintext kept on the contribution, which stops
+// feeding aggregates. Only allowed once the contributor holds a copy of
+// each key, or the datasets would be lost. Buyers who already received a
+// key keep it.
+func revokeContributorDataKey(c *gin.Context) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	rawID, err := hex.DecodeString(strings.TrimPrefix(c.Param("datasetId"), "0x"))
+	if err != nil || len(rawID) != common.HashLength {
+		respondError(c, ERR_INVALID_REQUEST, "datasetId must be a 32-byte hex hash")
+		return
+	}
+	datasetHash := common.BytesToHash(rawID).Hex()
+	ctx := c.Request.Context()
+	keys := db.Collection(DATA_KEYS_COLLECTION)
+	contributions := db.Collection("user_contributions")
+
+	var stored WrappedDataKey
+	err = keys.FindOne(ctx, bson.M{"_id": datasetHash, "contributor": address}).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, ERR_DATASET_KEY_NOT_FOUND, "No key is stored for the dataset")
+		return
+	}
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to fetch data key")
+		return
+	}
+	if stored.RevokedAt != nil {
+		respondError(c, ERR_DATASET_KEY_REVOKED, "The dataset key was already revoked")
+		return
+	}
+
+	// Datasets refined from the same upload as this one
+	var refined []UserContribution
+	cursor, err := contributions.Find(ctx,
+		bson.M{"address": address, "variants.dataHash": datasetHash},
+		options.Find().SetProjection(bson.M{"_id": 1, "variants": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &refined)
+	}
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to fetch contributions")
+		return
+	}
+	datasetHashes := []string{datasetHash}
+	contributionIDs := make([]primitive.ObjectID, 0, len(refined))
+	for _, contribution := range refined {
+		contributionIDs = append(contributionIDs, contribution.ID)
+		for _, variant := range contribution.Variants {
+			if variant.DataHash != datasetHash {
+				datasetHashes = append(datasetHashes, variant.DataHash)
+			}
+		}
+	}
+
+	var held []WrappedDataKey
+	cursor, err = keys.Find(ctx, bson.M{
+		"_id":         bson.M{"$in": datasetHashes},
+		"contributor": address,
+		"revokedAt":   bson.M{"$exists": false},
+	}, options.Find().SetProjection(bson.M{"_id": 1, "contributorKey": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &held)
+	}
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to fetch data keys")
+		return
+	}
+	revoked := make([]string, 0, len(held))
+	for _, key := range held {
+		if len(key.ContributorKey) == 0 {
+			respondError(c, ERR_DATASET_KEY_NOT_HELD, "Register an encryption key and wait for a copy of every variant's data key first")
+			return
+		}
+		revoked = append(revoked, key.DatasetHash)
+	}
+
+	// Purge the plaintext first, so a failure leaves the revocation to retry
+	revokedAt := time.Now()
+	_, err = contributions.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": contributionIDs}, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": revokedAt}, "$unset": bson.M{"dataContent": ""}})
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to revoke contributions")
+		return
+	}
+
+	result, err := keys.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": revoked}, "revokedAt": bson.M{"$exists": false}, "contributorKey": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{"revokedAt": revokedAt}, "$unset": bson.M{"wrappedKey": ""}})
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to revoke data keys")
+		return
+	}
+	if result.ModifiedCount == 0 {
+		respondError(c, ERR_DATASET_KEY_REVOKED, "The dataset key was already revoked")
+		return
+	}
+
+	response := RevokeDataKeysResponse{DatasetHashes: revoked, ContributionIDs: make([]string, len(contributionIDs))}
+	for i, id := range contributionIDs {
+		response.ContributionIDs[i] = id.Hex()
+	}
+
+	loggerFromContext(ctx).Info("Revoked dataset keys", "datasetHashes", revoked, "contributions", response.ContributionIDs, "address", address)
+	c.JSON(http.StatusOK, response)
+}
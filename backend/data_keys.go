@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	DATA_KEYS_COLLECTION = "data_keys"
+	KMS_REWRAP_INTERVAL  = time.Hour
+)
+
+// Set up key management, index wrapped keys by master key and keep
+// rewrapping keys left on retired master keys
+func initDataKeys() {
+	// Refinement cannot encrypt anything without master keys
+	if err := initKeyManagement(); err != nil {
+		logFatal("Key management initialization failed", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), EVENT_INDEX_INIT_TIMEOUT)
+	defer cancel()
+
+	_, err := db.Collection(DATA_KEYS_COLLECTION).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "keyId", Value: 1}},
+	})
+	if err != nil {
+		slog.Error("Failed to create data key index", "error", err)
+	}
+
+	schedulePeriodicJob("rewrap-data-keys", getEnvDuration("KMS_REWRAP_INTERVAL", KMS_REWRAP_INTERVAL), func(ctx context.Context) error {
+		_, err := rewrapDataKeys(ctx)
+		return err
+	})
+}
+
+var errDataKeyRevoked = fmt.Errorf("data key was revoked by its contributor")
+
+// Data key of a dataset. Identical datasets hash alike, so an existing key
+// is reused; otherwise a new key is generated, wrapped and stored before
+// anything is encrypted with it. A key its contributor revoked is replaced,
+// since contributing the dataset again shares it anew. Contributors holding
+// an encryption key also get a copy encrypted to it.
+func dataKeyFor(ctx context.Context, datasetHash [32]byte, envelope *SchemaEnvelope) ([]byte, error) {
+	key, err := loadDataKey(ctx, datasetHash)
+	revoked := err == errDataKeyRevoked
+	if err == nil || (err != mongo.ErrNoDocuments && !revoked) {
+		return key, err
+	}
+
+	key = make([]byte, 32) // AES-256
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption key: %v", err)
+	}
+
+	keyID, wrapped, err := keyManager.Wrap(ctx, key, datasetHash[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %v", err)
+	}
+
+	variant, _ := envelope.Metadata["variant"].(string)
+	stored := WrappedDataKey{
+		DatasetHash: common.Hash(datasetHash).Hex(),
+		KeyID:       keyID,
+		WrappedKey:  wrapped,
+		Contributor: envelope.Contributor,
+		DataType:    envelope.DataType,
+		Variant:     variant,
+		CreatedAt:   time.Now(),
+	}
+
+	contributorKey, publicKey, err := loadContributorKey(ctx, envelope.Contributor)
+	if err != nil {
+		return nil, err
+	}
+	if contributorKey != nil {
+		if stored.ContributorKey, err = encryptToContributor(publicKey, key); err != nil {
+			return nil, err
+		}
+		stored.ContributorKeyAddress = contributorKey.KeyAddress
+	}
+
+	if revoked {
+		result, err := db.Collection(DATA_KEYS_COLLECTION).ReplaceOne(ctx,
+			bson.M{"_id": stored.DatasetHash, "revokedAt": bson.M{"$exists": true}}, stored)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store data key: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			// Replaced concurrently; use the key that won
+			return loadDataKey(ctx, datasetHash)
+		}
+		return key, nil
+	}
+
+	_, err = db.Collection(DATA_KEYS_COLLECTION).InsertOne(ctx, stored)
+	if mongo.IsDuplicateKeyError(err) {
+		// Refined concurrently; use the key that won
+		return loadDataKey(ctx, datasetHash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store data key: %v", err)
+	}
+	return key, nil
+}
+
+// Unwrap the stored data key of a dataset. Returns mongo.ErrNoDocuments
+// when the dataset has none and errDataKeyRevoked when its contributor
+// revoked it.
+func loadDataKey(ctx context.Context, datasetHash [32]byte) ([]byte, error) {
+	if keyManager == nil {
+		return nil, fmt.Errorf("key management is not configured")
+	}
+
+	var stored WrappedDataKey
+	err := db.Collection(DATA_KEYS_COLLECTION).FindOne(ctx, bson.M{"_id": common.Hash(datasetHash).Hex()}).Decode(&stored)
+	if err != nil {
+		return nil, err
+	}
+	if stored.RevokedAt != nil {
+		return nil, errDataKeyRevoked
+	}
+	return keyManager.Unwrap(ctx, stored.KeyID, stored.WrappedKey, datasetHash[:])
+}
+
+// Rewrap every unrevoked data key not wrapped with the current master key,
+// e.g. after a rotation. Keys are updated only if nobody rewrapped them since
+// they were read, so concurrent runs are safe.
+func rewrapDataKeys(ctx context.Context) (int, error) {
+	if keyManager == nil {
+		return 0, fmt.Errorf("key management is not configured")
+	}
+	current, err := keyManager.CurrentKeyID(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	keys := db.Collection(DATA_KEYS_COLLECTION)
+	cursor, err := keys.Find(ctx, bson.M{"keyId": bson.M{"$ne": current}, "revokedAt": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query data keys: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	rewrapped := 0
+	for cursor.Next(ctx) {
+		var stored WrappedDataKey
+		if err := cursor.Decode(&stored); err != nil {
+			return rewrapped, fmt.Errorf("failed to decode data key: %v", err)
+		}
+		datasetHash := common.HexToHash(stored.DatasetHash)
+
+		key, err := keyManager.Unwrap(ctx, stored.KeyID, stored.WrappedKey, datasetHash[:])
+		if err != nil {
+			return rewrapped, fmt.Errorf("dataset %s: %v", stored.DatasetHash, err)
+		}
+		keyID, wrapped, err := keyManager.Wrap(ctx, key, datasetHash[:])
+		if err != nil {
+			return rewrapped, fmt.Errorf("dataset %s: %v", stored.DatasetHash, err)
+		}
+
+		result, err := keys.UpdateOne(ctx,
+			bson.M{"_id": stored.DatasetHash, "keyId": stored.KeyID},
+			bson.M{"$set": bson.M{"keyId": keyID, "wrappedKey": wrapped, "rewrappedAt": time.Now()}})
+		if err != nil {
+			return rewrapped, fmt.Errorf("failed to store rewrapped key: %v", err)
+		}
+		rewrapped += int(result.ModifiedCount)
+	}
+	if err := cursor.Err(); err != nil {
+		return rewrapped, err
+	}
+
+	if rewrapped > 0 {
+		loggerFromContext(ctx).Info("Rewrapped data keys", "keys", rewrapped, "masterKey", current)
+	}
+	return rewrapped, nil
+}
@@ -1,40 +1,72 @@
 package main
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
-	"strings"
+	"math"
+	"slices"
 	"time"
 )
 
+func init() {
+	registerDataSchema(DataSchema{
+		DataType:     "youtube_takeout",
+		Platform:     "youtube",
+		Description:  "YouTube watch history, search history and subscriptions",
+		MaskingRules: []string{"titles", "channelNames", "searchQueries", "searchTokens", "pseudonymizeIds", "timestamps"},
+		Validate:     validateYouTubeData,
+		Normalize: func(rawData interface{}, contributor string) (RefinedSchema, error) {
+			return normalizeYouTubeData(rawData, contributor)
+		},
+		Mask:    maskYouTubeSchema,
+		Quality: scoreYouTubeSchema,
+	})
+
+	// Built by the event epoch job from extension events; never uploaded
+	registerDataSchema(DataSchema{
+		DataType:     EVENT_EPOCH_DATA_TYPE,
+		Platform:     "youtube",
+		Description:  "YouTube watch sessions and subscriptions captured by the extension",
+		MaskingRules: []string{"titles", "channelNames", "pseudonymizeIds", "timestamps"},
+		Validate: func(rawData interface{}) error {
+			return fmt.Errorf("%s datasets are built from uploaded extension events", EVENT_EPOCH_DATA_TYPE)
+		},
+		Normalize: func(rawData interface{}, contributor string) (RefinedSchema, error) {
+			return nil, fmt.Errorf("%s datasets cannot be uploaded", EVENT_EPOCH_DATA_TYPE)
+		},
+		Mask:    maskYouTubeSchema,
+		Quality: scoreYouTubeSchema,
+	})
+}
+
 // YouTube data schema definition following VRC-15
 type YouTubeDataSchema struct {
-	Version       string                 `json:"version"`
-	Contributor   string                 `json:"contributor"`
-	Timestamp     int64                  `json:"timestamp"`
-	DataType      string                 `json:"dataType"`
-	WatchHistory  []WatchHistoryEntry    `json:"watchHistory,omitempty"`
-	SearchHistory []SearchHistoryEntry   `json:"searchHistory,omitempty"`
-	Subscriptions []SubscriptionEntry    `json:"subscriptions,omitempty"`
-	Metadata      map[string]interface{} `json:"metadata"`
+	SchemaEnvelope `bson:",inline"`
+	WatchHistory   []WatchHistoryEntry  `json:"watchHistory,omitempty"`
+	SearchHistory  []SearchHistoryEntry `json:"searchHistory,omitempty"`
+	Subscriptions  []SubscriptionEntry  `json:"subscriptions,omitempty"`
 }
 
 type WatchHistoryEntry struct {
-	VideoID        string    `json:"videoId"`
-	Title          string    `json:"title,omitempty"`
-	ChannelName    string    `json:"channelName,omitempty"`
-	WatchTime      time.Time `json:"watchTime"`
-	Duration       int       `json:"duration,omitempty"`
-	PercentWatched float64   `json:"percentWatched,omitempty"`
+	VideoID        string    `json:"videoId" bson:"videoId"`
+	Title          string    `json:"title,omitempty" bson:"title,omitempty"`
+	ChannelName    string    `json:"channelName,omitempty" bson:"channelName,omitempty"`
+	ChannelID      string    `json:"channelId,omitempty" bson:"channelId,omitempty"`
+	Kind           string    `json:"kind,omitempty" bson:"kind,omitempty"`
+	Product        string    `json:"product,omitempty" bson:"product,omitempty"`
+	WatchTime      time.Time `json:"watchTime" bson:"watchTime"`
+	Duration       int       `json:"duration,omitempty" bson:"duration,omitempty"`
+	PercentWatched float64   `json:"percentWatched,omitempty" bson:"percentWatched,omitempty"`
 }
 
 type SearchHistoryEntry struct {
-	Query        string    `json:"query"`
+	Query string `json:"query"`
+	// Set instead of Query by the searchTokens masking rule
+	Tokens       []string  `json:"tokens,omitempty"`
 	Timestamp    time.Time `json:"timestamp"`
 	ResultClicks []string  `json:"resultClicks,omitempty"`
 }
@@ -46,11 +78,14 @@ type SubscriptionEntry struct {
 }
 
 type RefinedData struct {
-	Schema    YouTubeDataSchema `json:"schema"`
-	Hash      [32]byte          `json:"hash"`
-	Encrypted []byte            `json:"encrypted"`
-	IPFSHash  string            `json:"ipfsHash"`
-	AccessKey []byte            `json:"accessKey"`
+	Variant   string        `json:"variant"`
+	Schema    RefinedSchema `json:"schema"`
+	Hash      [32]byte      `json:"hash"`
+	Encrypted []byte        `json:"encrypted"`
+	IPFSHash  string        `json:"ipfsHash"`
+	AccessKey []byte        `json:"accessKey"`
+	// Further masked variants refined from the same normalized schema
+	Variants []*RefinedData `json:"variants,omitempty"`
 }
 
 // Normalize raw YouTube data to predefined schema
@@ -60,13 +95,7 @@ func normalizeYouTubeData(rawData interface{}, contributor string) (*YouTubeData
 		return nil, fmt.Errorf("invalid data format")
 	}
 
-	schema := &YouTubeDataSchema{
-		Version:     "1.0",
-		Contributor: contributor,
-		Timestamp:   time.Now().Unix(),
-		DataType:    "youtube_takeout",
-		Metadata:    make(map[string]interface{}),
-	}
+	schema := &YouTubeDataSchema{SchemaEnvelope: newSchemaEnvelope("youtube_takeout", contributor)}
 
 	// Normalize watch history
 	if watchHistory, exists := data["MyActivity"]; exists {
@@ -111,16 +140,32 @@ func normalizeYouTubeData(rawData interface{}, contributor string) (*YouTubeData
 	}
 
 	// Extract metadata
+	countWatchEntryKinds(schema)
 	schema.Metadata["originalFields"] = len(data)
 	schema.Metadata["processedAt"] = time.Now().Unix()
-	schema.Metadata["dataQuality"] = calculateDataQuality(schema)
 
 	return schema, nil
 }
 
-// Apply privacy masking based on user preferences
+// Deep copy, so masking a copy never writes through to the original
+func (s *YouTubeDataSchema) clone() *YouTubeDataSchema {
+	c := &YouTubeDataSchema{
+		SchemaEnvelope: s.SchemaEnvelope.clone(),
+		WatchHistory:   slices.Clone(s.WatchHistory),
+		SearchHistory:  slices.Clone(s.SearchHistory),
+		Subscriptions:  slices.Clone(s.Subscriptions),
+	}
+	for i := range c.SearchHistory {
+		c.SearchHistory[i].Tokens = slices.Clone(c.SearchHistory[i].Tokens)
+		c.SearchHistory[i].ResultClicks = slices.Clone(c.SearchHistory[i].ResultClicks)
+	}
+	return c
+}
+
+// Return a masked copy of schema with the enabled masking rules applied;
+// schema itself is left untouched
 func applyPrivacyMasking(schema *YouTubeDataSchema, maskingRules map[string]bool) *YouTubeDataSchema {
-	masked := *schema
+	masked := schema.clone()
 
 	if maskingRules["titles"] {
 		for i := range masked.WatchHistory {
@@ -141,42 +186,104 @@ func applyPrivacyMasking(schema *YouTubeDataSchema, maskingRules map[string]bool
 		for i := range masked.SearchHistory {
 			masked.SearchHistory[i].Query = "[MASKED]"
 		}
+	} else if maskingRules["searchTokens"] {
+		for i := range masked.SearchHistory {
+			masked.SearchHistory[i].Tokens = tokenizeSearchQuery(masked.SearchHistory[i].Query)
+			masked.SearchHistory[i].Query = ""
+		}
+	}
+
+	if maskingRules["pseudonymizeIds"] {
+		for i := range masked.WatchHistory {
+			masked.WatchHistory[i].VideoID = pseudonymize("video", masked.WatchHistory[i].VideoID)
+			masked.WatchHistory[i].ChannelID = pseudonymize("channel", masked.WatchHistory[i].ChannelID)
+		}
+		for i := range masked.SearchHistory {
+			clicks := make([]string, len(masked.SearchHistory[i].ResultClicks))
+			for j, videoID := range masked.SearchHistory[i].ResultClicks {
+				clicks[j] = pseudonymize("video", videoID)
+			}
+			masked.SearchHistory[i].ResultClicks = clicks
+		}
+		for i := range masked.Subscriptions {
+			masked.Subscriptions[i].ChannelID = pseudonymize("channel", masked.Subscriptions[i].ChannelID)
+		}
 	}
 
 	if maskingRules["timestamps"] {
-		// Keep only month/year for privacy
 		for i := range masked.WatchHistory {
-			original := masked.WatchHistory[i].WatchTime
-			masked.WatchHistory[i].WatchTime = time.Date(original.Year(), original.Month(), 1, 0, 0, 0, 0, time.UTC)
+			masked.WatchHistory[i].WatchTime = maskTime(masked.WatchHistory[i].WatchTime)
+		}
+		for i := range masked.SearchHistory {
+			masked.SearchHistory[i].Timestamp = maskTime(masked.SearchHistory[i].Timestamp)
+		}
+		for i := range masked.Subscriptions {
+			masked.Subscriptions[i].SubscribedAt = maskTime(masked.Subscriptions[i].SubscribedAt)
 		}
 	}
 
-	return &masked
+	return masked
 }
 
-// Encrypt refined data for access control
-func encryptRefinedData(data *YouTubeDataSchema) (*RefinedData, error) {
+func maskYouTubeSchema(schema RefinedSchema, maskingRules map[string]bool) RefinedSchema {
+	return applyPrivacyMasking(schema.(*YouTubeDataSchema), maskingRules)
+}
+
+func (s *YouTubeDataSchema) channelKeys() []string {
+	var keys []string
+	for _, entry := range s.WatchHistory {
+		keys = append(keys, channelKey(entry.ChannelID, entry.ChannelName))
+	}
+	for _, subscription := range s.Subscriptions {
+		keys = append(keys, channelKey(subscription.ChannelID, subscription.ChannelName))
+	}
+	return keys
+}
+
+// Drop the channel of watch entries and the subscriptions naming a rare
+// channel
+func (s *YouTubeDataSchema) suppressChannels(rare map[string]bool) {
+	for i, entry := range s.WatchHistory {
+		if rare[channelKey(entry.ChannelID, entry.ChannelName)] {
+			s.WatchHistory[i].ChannelID = ""
+			s.WatchHistory[i].ChannelName = ""
+		}
+	}
+
+	subscriptions := make([]SubscriptionEntry, 0, len(s.Subscriptions))
+	for _, subscription := range s.Subscriptions {
+		if !rare[channelKey(subscription.ChannelID, subscription.ChannelName)] {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	s.Subscriptions = subscriptions
+}
+
+func scoreYouTubeSchema(schema RefinedSchema) float64 {
+	return calculateDataQuality(schema.(*YouTubeDataSchema))
+}
+
+// Encrypt refined data for access control with its dataset's data key,
+// which is wrapped and stored by the key management subsystem
+func encryptRefinedData(ctx context.Context, data RefinedSchema) (*RefinedData, error) {
 	// Serialize the schema
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to serialize data: %v", err)
 	}
 
-	// Generate encryption key
-	key := make([]byte, 32) // AES-256
-	if _, err := io.ReadFull(rand.Reader, key); err != nil {
-		return nil, fmt.Errorf("failed to generate encryption key: %v", err)
-	}
+	// Calculate hash
+	hash := sha256.Sum256(jsonData)
 
-	// Encrypt data
-	block, err := aes.NewCipher(key)
+	key, err := dataKeyFor(ctx, hash, data.envelope())
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %v", err)
+		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	// Encrypt data
+	gcm, err := newAESGCM(key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %v", err)
+		return nil, err
 	}
 
 	nonce := make([]byte, gcm.NonceSize())
@@ -186,11 +293,8 @@ func encryptRefinedData(data *YouTubeDataSchema) (*RefinedData, error) {
 
 	encrypted := gcm.Seal(nonce, nonce, jsonData, nil)
 
-	// Calculate hash
-	hash := sha256.Sum256(jsonData)
-
 	return &RefinedData{
-		Schema:    *data,
+		Schema:    data,
 		Hash:      hash,
 		Encrypted: encrypted,
 		AccessKey: key,
@@ -198,30 +302,38 @@ func encryptRefinedData(data *YouTubeDataSchema) (*RefinedData, error) {
 }
 
 // Helper functions for normalization
+// Normalize one watch history record: a Takeout activity, optionally
+// carrying videoId, duration and progress fields from other exports
 func normalizeWatchHistoryEntry(data map[string]interface{}) *WatchHistoryEntry {
-	entry := &WatchHistoryEntry{}
-
-	if title, ok := data["title"].(string); ok {
-		// Extract video ID from YouTube URLs
-		if strings.Contains(title, "youtube.com/watch") {
-			parts := strings.Split(title, "v=")
-			if len(parts) > 1 {
-				entry.VideoID = strings.Split(parts[1], "&")[0]
-			}
-		}
-		entry.Title = title
+	entry, ok := takeoutActivityFromMap(data).watchEntry()
+	if !ok {
+		return nil
 	}
 
-	if timestamp, ok := data["time"].(string); ok {
-		if t, err := time.Parse(time.RFC3339, timestamp); err == nil {
-			entry.WatchTime = t
-		}
+	if videoID := firstString(data, "videoId"); entry.VideoID == "" && youtubeVideoIDPattern.MatchString(videoID) {
+		entry.VideoID = videoID
+		entry.Kind = WATCH_ENTRY_VIDEO
 	}
 
-	if entry.VideoID != "" || entry.Title != "" {
-		return entry
+	if duration := firstNumber(data, "duration", "durationSeconds"); duration > 0 {
+		entry.Duration = int(duration)
 	}
-	return nil
+	if percent := firstNumber(data, "percentWatched"); percent > 0 {
+		entry.PercentWatched = math.Min(percent, 100)
+	} else if watched := firstNumber(data, "watchedSeconds"); watched > 0 && entry.Duration > 0 {
+		entry.PercentWatched = math.Round(math.Min(watched/float64(entry.Duration)*100, 100)*10) / 10
+	}
+
+	return &entry
+}
+
+func firstNumber(data map[string]interface{}, keys ...string) float64 {
+	for _, key := range keys {
+		if value, ok := data[key].(float64); ok && value > 0 {
+			return value
+		}
+	}
+	return 0
 }
 
 func normalizeSearchHistoryEntry(data map[string]interface{}) *SearchHistoryEntry {
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Populated schema with every field a masking rule touches. Each call
+// returns fresh slices, so one copy can serve as the expected original.
+func maskingTestSchema() *YouTubeDataSchema {
+	watched := time.Date(2024, 3, 14, 15, 9, 26, 0, time.UTC)
+	return &YouTubeDataSchema{
+		SchemaEnvelope: SchemaEnvelope{
+			Version:     "1.0",
+			Contributor: "0x1234567890123456789012345678901234567890",
+			Timestamp:   watched.Unix(),
+			DataType:    DEFAULT_DATA_TYPE,
+			Metadata:    map[string]interface{}{"dataQuality": 80.0},
+		},
+		WatchHistory: []WatchHistoryEntry{
+			{VideoID: "dQw4w9WgXcQ", Title: "Never Gonna Give You Up", ChannelName: "Rick Astley",
+				ChannelID: "UCuAXFkgsw1L7xaCfnd5JJOw", Kind: WATCH_ENTRY_VIDEO, WatchTime: watched, Duration: 213},
+			{VideoID: "9bZkp7q19f0", Title: "Gangnam Style", ChannelName: "officialpsy",
+				ChannelID: "UCrDkAvwZum-UTjHmzDI2iIw", Kind: WATCH_ENTRY_VIDEO, WatchTime: watched.Add(time.Hour)},
+		},
+		SearchHistory: []SearchHistoryEntry{
+			{Query: "Jane Doe jane@example.com tutorial", Timestamp: watched,
+				ResultClicks: []string{"dQw4w9WgXcQ", "9bZkp7q19f0"}},
+			{Query: "lofi beats", Tokens: []string{"lofi", "beats"}, Timestamp: watched.Add(time.Minute)},
+		},
+		Subscriptions: []SubscriptionEntry{
+			{ChannelID: "UCuAXFkgsw1L7xaCfnd5JJOw", ChannelName: "Rick Astley", SubscribedAt: watched},
+		},
+	}
+}
+
+func TestApplyPrivacyMaskingLeavesOriginalUntouched(t *testing.T) {
+	cases := []struct {
+		name  string
+		rules map[string]bool
+	}{
+		{"no rules", map[string]bool{}},
+		{"every rule with search tokens", map[string]bool{
+			"titles": true, "channelNames": true, "searchTokens": true, "pseudonymizeIds": true, "timestamps": true,
+		}},
+		{"every rule with masked queries", map[string]bool{
+			"titles": true, "channelNames": true, "searchQueries": true, "searchTokens": true, "pseudonymizeIds": true, "timestamps": true,
+		}},
+		{"search tokens only", map[string]bool{"searchTokens": true}},
+		{"pseudonymized IDs only", map[string]bool{"pseudonymizeIds": true}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			original := maskingTestSchema()
+			masked := applyPrivacyMasking(original, c.rules)
+
+			if !reflect.DeepEqual(original, maskingTestSchema()) {
+				t.Errorf("masking changed the original:\n got %+v\nwant %+v", original, maskingTestSchema())
+			}
+			if len(c.rules) > 0 && reflect.DeepEqual(masked, original) {
+				t.Errorf("masked copy equals the original")
+			}
+			assertNoSharedArrays(t, "original and masked copy", original, masked)
+		})
+	}
+}
+
+// Variants masked from one schema must not write through to each other
+func TestApplyPrivacyMaskingVariantsDoNotShareArrays(t *testing.T) {
+	original := maskingTestSchema()
+	first := applyPrivacyMasking(original, map[string]bool{"titles": true})
+	second := applyPrivacyMasking(original, map[string]bool{"searchTokens": true, "pseudonymizeIds": true})
+
+	assertNoSharedArrays(t, "two variants", first, second)
+
+	first.WatchHistory[0].VideoID = "changed"
+	first.SearchHistory[0].ResultClicks[0] = "changed"
+	first.SearchHistory[1].Tokens[0] = "changed"
+	first.Subscriptions[0].ChannelName = "changed"
+	first.Metadata["dataQuality"] = 0.0
+	if !reflect.DeepEqual(original, maskingTestSchema()) {
+		t.Errorf("changing a variant changed the original")
+	}
+	if second.WatchHistory[0].VideoID == "changed" || second.Metadata["dataQuality"] == 0.0 {
+		t.Errorf("changing a variant changed another variant")
+	}
+}
+
+func assertNoSharedArrays(t *testing.T, what string, a, b *YouTubeDataSchema) {
+	t.Helper()
+
+	if sharesArray(a.WatchHistory, b.WatchHistory) {
+		t.Errorf("%s share WatchHistory", what)
+	}
+	if sharesArray(a.SearchHistory, b.SearchHistory) {
+		t.Errorf("%s share SearchHistory", what)
+	}
+	if sharesArray(a.Subscriptions, b.Subscriptions) {
+		t.Errorf("%s share Subscriptions", what)
+	}
+	for i := range a.SearchHistory {
+		if i >= len(b.SearchHistory) {
+			break
+		}
+		if sharesArray(a.SearchHistory[i].ResultClicks, b.SearchHistory[i].ResultClicks) {
+			t.Errorf("%s share ResultClicks of search %d", what, i)
+		}
+		if sharesArray(a.SearchHistory[i].Tokens, b.SearchHistory[i].Tokens) {
+			t.Errorf("%s share Tokens of search %d", what, i)
+		}
+	}
+}
+
+func sharesArray[T any](a, b []T) bool {
+	return len(a) > 0 && len(b) > 0 && &a[0] == &b[0]
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	EVENT_EPOCHS_COLLECTION = "event_epochs"
+	EVENT_EPOCH_STATE_ID    = "event-epochs"
+	EVENT_EPOCH_DATA_TYPE   = "youtube_events"
+
+	EVENT_EPOCH_LENGTH       = 24 * time.Hour
+	EVENT_EPOCH_INTERVAL     = time.Hour
+	EVENT_EPOCH_GRACE        = 6 * time.Hour
+	EVENT_EPOCH_MAX_BACKFILL = 7
+	EVENT_EPOCH_MAX_EVENTS   = 50000
+	EVENT_EPOCH_MAX_ATTEMPTS = 3
+	EVENT_EPOCH_STALE_AFTER  = time.Hour
+
+	EPOCH_STATUS_PROCESSING = "processing"
+	EPOCH_STATUS_SUBMITTED  = "submitted"
+	EPOCH_STATUS_EMPTY      = "empty"
+	EPOCH_STATUS_SKIPPED    = "skipped"
+	EPOCH_STATUS_FAILED     = "failed"
+)
+
+// Stored event as read by the epoch job
+type epochEvent struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	SessionID    string             `bson:"sessionId"`
+	sessionEvent `bson:",inline"`
+}
+
+type epochJobState struct {
+	ID             string    `bson:"_id"`
+	ProcessedUntil time.Time `bson:"processedUntil"`
+}
+
+// Create the epoch indexes and schedule the event epoch job.
+// EVENT_EPOCH_INTERVAL=0 disables it.
+func initEventEpochs() {
+	ctx, cancel := context.WithTimeout(context.Background(), EVENT_INDEX_INIT_TIMEOUT)
+	defer cancel()
+
+	_, err := db.Collection(EVENT_EPOCHS_COLLECTION).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "address", Value: 1}, {Key: "epochStart", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updatedAt", Value: 1}}},
+	})
+	if err != nil {
+		slog.Error("Failed to create event epoch indexes", "error", err)
+	}
+
+	// At most one contribution per address and epoch, so a reclaimed epoch
+	// cannot be contributed twice
+	_, err = db.Collection("user_contributions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "address", Value: 1}, {Key: "epochStart", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"epochStart": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		slog.Error("Failed to create epoch contribution index", "error", err)
+	}
+
+	schedulePeriodicJob("event-epochs", getEnvDuration("EVENT_EPOCH_INTERVAL", EVENT_EPOCH_INTERVAL), runEventEpochs)
+}
+
+// Contribute every epoch that closed since the last run, then retry
+// epochs that failed. An epoch closes EVENT_EPOCH_GRACE after it ends so
+// events queued offline by the extension still make it in.
+func runEventEpochs(ctx context.Context) error {
+	length := getEnvDuration("EVENT_EPOCH_LENGTH", EVENT_EPOCH_LENGTH)
+	grace := getEnvDuration("EVENT_EPOCH_GRACE", EVENT_EPOCH_GRACE)
+	if length <= 0 {
+		return fmt.Errorf("EVENT_EPOCH_LENGTH must be positive")
+	}
+
+	states := db.Collection(JOB_STATE_COLLECTION)
+	var state epochJobState
+	err := states.FindOne(ctx, bson.M{"_id": EVENT_EPOCH_STATE_ID}).Decode(&state)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to load event epoch state: %v", err)
+	}
+
+	// Epochs are aligned to multiples of length since the zero time, so
+	// daily epochs start at midnight UTC
+	closedUntil := time.Now().UTC().Add(-grace).Truncate(length)
+	start := state.ProcessedUntil
+	if earliest := closedUntil.Add(-EVENT_EPOCH_MAX_BACKFILL * length); start.Before(earliest) {
+		start = earliest
+	}
+
+	for epochStart := start; !epochStart.Add(length).After(closedUntil); epochStart = epochStart.Add(length) {
+		epochEnd := epochStart.Add(length)
+		if err := processEventEpoch(ctx, epochStart, epochEnd); err != nil {
+			return err
+		}
+
+		_, err := states.UpdateOne(ctx,
+			bson.M{"_id": EVENT_EPOCH_STATE_ID},
+			bson.M{"$set": bson.M{"processedUntil": epochEnd}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to save event epoch state: %v", err)
+		}
+	}
+
+	return retryFailedEpochs(ctx)
+}
+
+// Contribute one epoch for every address that recorded video events in it
+func processEventEpoch(ctx context.Context, epochStart, epochEnd time.Time) error {
+	addresses, err := db.Collection(USER_EVENTS_COLLECTION).Distinct(ctx, "address", bson.M{
+		"timestamp": bson.M{"$gte": epochStart, "$lt": epochEnd},
+		"category":  bson.M{"$in": videoEventCategories},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list addresses for epoch %s: %v", epochStart.Format(time.RFC3339), err)
+	}
+
+	for _, value := range addresses {
+		address, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		epoch := EventEpoch{
+			Address:    address,
+			EpochStart: epochStart,
+			EpochEnd:   epochEnd,
+			Status:     EPOCH_STATUS_PROCESSING,
+			Attempts:   1,
+			UpdatedAt:  time.Now(),
+		}
+		result, err := db.Collection(EVENT_EPOCHS_COLLECTION).InsertOne(ctx, epoch)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to claim epoch for %s: %v", address, err)
+		}
+		epoch.ID = result.InsertedID.(primitive.ObjectID)
+
+		contributeEventEpoch(ctx, epoch)
+	}
+
+	slog.Info("Processed event epoch", "epochStart", epochStart, "addresses", len(addresses))
+	return nil
+}
+
+// Reclaim epochs that failed, or whose worker died mid-way, and run them again
+func retryFailedEpochs(ctx context.Context) error {
+	epochs := db.Collection(EVENT_EPOCHS_COLLECTION)
+	filter := bson.M{
+		"attempts": bson.M{"$lt": EVENT_EPOCH_MAX_ATTEMPTS},
+		"$or": bson.A{
+			bson.M{"status": EPOCH_STATUS_FAILED},
+			bson.M{"status": EPOCH_STATUS_PROCESSING, "updatedAt": bson.M{"$lt": time.Now().Add(-EVENT_EPOCH_STALE_AFTER)}},
+		},
+	}
+
+	for {
+		var epoch EventEpoch
+		err := epochs.FindOneAndUpdate(ctx, filter,
+			bson.M{
+				"$set": bson.M{"status": EPOCH_STATUS_PROCESSING, "updatedAt": time.Now()},
+				"$inc": bson.M{"attempts": 1},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&epoch)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to claim failed epoch: %v", err)
+		}
+
+		contributeEventEpoch(ctx, epoch)
+	}
+}
+
+// Refine and submit one address's events for one epoch, recording the
+// outcome on the epoch
+func contributeEventEpoch(ctx context.Context, epoch EventEpoch) {
+	logger := loggerFromContext(ctx).With("address", epoch.Address, "epochStart", epoch.EpochStart)
+
+	status, eventCount, contributionID, err := buildEpochContribution(ctx, epoch)
+
+	set := bson.M{"status": status, "eventCount": eventCount, "updatedAt": time.Now()}
+	update := bson.M{"$set": set}
+	if contributionID != nil {
+		set["contributionId"] = *contributionID
+	}
+	if err != nil {
+		logger.Error("Event epoch contribution failed", "attempt", epoch.Attempts, "error", err)
+		set["error"] = err.Error()
+	} else {
+		logger.Info("Event epoch processed", "status", status, "events", eventCount)
+		update["$unset"] = bson.M{"error": ""}
+	}
+
+	_, updateErr := db.Collection(EVENT_EPOCHS_COLLECTION).UpdateOne(ctx, bson.M{"_id": epoch.ID}, update)
+	if updateErr != nil {
+		logger.Error("Failed to record event epoch outcome", "error", updateErr)
+	}
+}
+
+func buildEpochContribution(ctx context.Context, epoch EventEpoch) (string, int, *primitive.ObjectID, error) {
+	granted, err := eventConsentGranted(ctx, epoch.Address)
+	if err != nil {
+		return EPOCH_STATUS_FAILED, 0, nil, fmt.Errorf("failed to check consent: %v", err)
+	}
+	if !granted {
+		return EPOCH_STATUS_SKIPPED, 0, nil, nil
+	}
+
+	// A worker that died after recording the contribution leaves the epoch
+	// in processing; finish linking it instead of contributing again
+	var existing UserContribution
+	err = db.Collection("user_contributions").FindOne(ctx,
+		bson.M{"address": epoch.Address, "epochStart": epoch.EpochStart},
+		options.FindOne().SetProjection(bson.M{"_id": 1, "sourceEventIds": 1}),
+	).Decode(&existing)
+	if err == nil {
+		linkEpochEvents(ctx, existing.ID, existing.SourceEventIDs)
+		return EPOCH_STATUS_SUBMITTED, len(existing.SourceEventIDs), &existing.ID, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return EPOCH_STATUS_FAILED, 0, nil, fmt.Errorf("failed to check for an existing contribution: %v", err)
+	}
+
+	events, err := loadEpochEvents(ctx, epoch)
+	if err != nil {
+		return EPOCH_STATUS_FAILED, 0, nil, err
+	}
+
+	sessions, err := loadEpochWatchSessions(ctx, epoch, events)
+	if err != nil {
+		return EPOCH_STATUS_FAILED, len(events), nil, err
+	}
+
+	schema := eventEpochSchema(epoch, events, sessions)
+	if len(schema.WatchHistory) == 0 && len(schema.Subscriptions) == 0 {
+		return EPOCH_STATUS_EMPTY, len(events), nil, nil
+	}
+
+	sourceIDs := make([]primitive.ObjectID, len(events))
+	for i, event := range events {
+		sourceIDs[i] = event.ID
+	}
+
+	dataSchema := dataSchemas[EVENT_EPOCH_DATA_TYPE]
+	maskingRules, err := resolveMaskingRules(ctx, dataSchema, epoch.Address, nil)
+	if err != nil {
+		return EPOCH_STATUS_FAILED, len(events), nil, err
+	}
+	refinedData, err := processSchemaForVRC15(ctx, dataSchema, schema, sourceIDs, maskingRules)
+	if err != nil {
+		return EPOCH_STATUS_FAILED, len(events), nil, fmt.Errorf("refinement failed: %v", err)
+	}
+
+	fileSize := 0
+	if data, err := json.Marshal(schema); err == nil {
+		fileSize = len(data)
+	}
+
+	epochStart, epochEnd := epoch.EpochStart, epoch.EpochEnd
+	contribution, err := recordContribution(ctx, UserContribution{
+		Address:          epoch.Address,
+		DataType:         EVENT_EPOCH_DATA_TYPE,
+		FileName:         fmt.Sprintf("events-%s.json", epochStart.Format("2006-01-02T15")),
+		FileSize:         int64(fileSize),
+		EpochStart:       &epochStart,
+		EpochEnd:         &epochEnd,
+		SourceEventCount: len(sourceIDs),
+		SourceEventIDs:   sourceIDs,
+	}, refinedData)
+	if err != nil {
+		return EPOCH_STATUS_FAILED, len(events), nil, err
+	}
+
+	linkEpochEvents(ctx, contribution.ID, sourceIDs)
+	return EPOCH_STATUS_SUBMITTED, len(events), &contribution.ID, nil
+}
+
+// Mark events as contributed so no later epoch run refines them again
+func linkEpochEvents(ctx context.Context, contributionID primitive.ObjectID, eventIDs []primitive.ObjectID) {
+	_, err := db.Collection(USER_EVENTS_COLLECTION).UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": eventIDs}},
+		bson.M{"$set": bson.M{"contributionId": contributionID}})
+	if err != nil {
+		loggerFromContext(ctx).Warn("Failed to link events to contribution", "contributionId", contributionID.Hex(), "error", err)
+	}
+}
+
+// Read the not yet contributed video events of an epoch in time order
+func loadEpochEvents(ctx context.Context, epoch EventEpoch) ([]epochEvent, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(EVENT_EPOCH_MAX_EVENTS).
+		SetProjection(bson.M{"sessionId": 1, "eventType": 1, "timestamp": 1, "eventData": 1})
+	cursor, err := db.Collection(USER_EVENTS_COLLECTION).Find(ctx, bson.M{
+		"address":           epoch.Address,
+		"timestamp":         bson.M{"$gte": epoch.EpochStart, "$lt": epoch.EpochEnd},
+		"category":          bson.M{"$in": videoEventCategories},
+		"eventData.videoId": bson.M{"$type": "string"},
+		"contributionId":    bson.M{"$exists": false},
+	}, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load epoch events: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var events []epochEvent
+	for cursor.Next(ctx) {
+		var event epochEvent
+		if err := cursor.Decode(&event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read epoch events: %v", err)
+	}
+	if len(events) == EVENT_EPOCH_MAX_EVENTS {
+		loggerFromContext(ctx).Warn("Epoch event limit reached", "address", epoch.Address, "limit", EVENT_EPOCH_MAX_EVENTS)
+	}
+	return events, nil
+}
+
+// Refresh the watch sessions of every extension session with events in
+// the epoch, then read the ones that started in it. Going through
+// watch_sessions keeps epoch contributions identical to what the
+// sessionizer stores, and a session spanning two epochs lands in one.
+func loadEpochWatchSessions(ctx context.Context, epoch EventEpoch, events []epochEvent) ([]WatchSession, error) {
+	sessionIDs := bson.A{}
+	seen := make(map[string]bool)
+	for _, event := range events {
+		if seen[event.SessionID] {
+			continue
+		}
+		seen[event.SessionID] = true
+		sessionIDs = append(sessionIDs, event.SessionID)
+
+		if _, err := rebuildWatchSessions(ctx, epoch.Address, event.SessionID); err != nil {
+			return nil, err
+		}
+	}
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := db.Collection(WATCH_SESSIONS_COLLECTION).Find(ctx, bson.M{
+		"address":   epoch.Address,
+		"sessionId": bson.M{"$in": sessionIDs},
+		"watchTime": bson.M{"$gte": epoch.EpochStart, "$lt": epoch.EpochEnd},
+	}, options.Find().SetSort(bson.D{{Key: "watchTime", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load epoch watch sessions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []WatchSession
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to read epoch watch sessions: %v", err)
+	}
+	return sessions, nil
+}
+
+// Build the schema of an epoch: watch history from its watch sessions,
+// subscriptions from subscribe clicks
+func eventEpochSchema(epoch EventEpoch, events []epochEvent, sessions []WatchSession) *YouTubeDataSchema {
+	schema := &YouTubeDataSchema{SchemaEnvelope: newSchemaEnvelope(EVENT_EPOCH_DATA_TYPE, epoch.Address)}
+
+	subscribed := make(map[string]bool)
+	for _, event := range events {
+		if event.EventType == "subscribe_click" && event.Data.ChannelID != "" && !subscribed[event.Data.ChannelID] {
+			subscribed[event.Data.ChannelID] = true
+			schema.Subscriptions = append(schema.Subscriptions, SubscriptionEntry{
+				ChannelID:    event.Data.ChannelID,
+				ChannelName:  event.Data.ChannelName,
+				SubscribedAt: event.Timestamp,
+			})
+		}
+	}
+
+	for _, session := range sessions {
+		schema.WatchHistory = append(schema.WatchHistory, session.WatchHistoryEntry)
+	}
+
+	schema.Metadata["epochStart"] = epoch.EpochStart.Unix()
+	schema.Metadata["epochEnd"] = epoch.EpochEnd.Unix()
+	schema.Metadata["sourceEvents"] = len(events)
+	schema.Metadata["processedAt"] = time.Now().Unix()
+	return schema
+}
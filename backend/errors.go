@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	PROBLEM_CONTENT_TYPE = "application/problem+json"
+	PROBLEM_TYPE_PREFIX  = "urn:tubedao:error:"
+)
+
+// Machine-readable error code returned in every error response
+type ErrorCode string
+
+const (
+	ERR_INVALID_REQUEST              ErrorCode = "invalid_request"
+	ERR_INVALID_DATA_FORMAT          ErrorCode = "invalid_data_format"
+	ERR_UNSUPPORTED_DATA_TYPE        ErrorCode = "unsupported_data_type"
+	ERR_UPLOAD_TOO_LARGE             ErrorCode = "upload_too_large"
+	ERR_UPLOAD_NOT_FOUND             ErrorCode = "upload_not_found"
+	ERR_UPLOAD_OFFSET_MISMATCH       ErrorCode = "upload_offset_mismatch"
+	ERR_UPLOAD_INCOMPLETE            ErrorCode = "upload_incomplete"
+	ERR_UPLOAD_CLOSED                ErrorCode = "upload_closed"
+	ERR_UPLOAD_INTERRUPTED           ErrorCode = "upload_interrupted"
+	ERR_CHECKSUM_MISMATCH            ErrorCode = "checksum_mismatch"
+	ERR_EMPTY_BATCH                  ErrorCode = "empty_batch"
+	ERR_BATCH_TOO_LARGE              ErrorCode = "batch_too_large"
+	ERR_INVALID_EVENTS               ErrorCode = "invalid_events"
+	ERR_UNSUPPORTED_ENCODING         ErrorCode = "unsupported_encoding"
+	ERR_STREAM_ABORTED               ErrorCode = "stream_aborted"
+	ERR_INGESTION_BUSY               ErrorCode = "ingestion_busy"
+	ERR_CONSENT_REVOKED              ErrorCode = "consent_revoked"
+	ERR_INVALID_SIWE_MESSAGE         ErrorCode = "invalid_siwe_message"
+	ERR_INVALID_NONCE                ErrorCode = "invalid_nonce"
+	ERR_INVALID_SIGNATURE            ErrorCode = "invalid_signature"
+	ERR_AUTH_REQUIRED                ErrorCode = "auth_required"
+	ERR_INVALID_TOKEN                ErrorCode = "invalid_token"
+	ERR_SESSION_EXPIRED              ErrorCode = "session_expired"
+	ERR_ADDRESS_MISMATCH             ErrorCode = "address_mismatch"
+	ERR_REGISTRY_MEMBERSHIP_REQUIRED ErrorCode = "registry_membership_required"
+	ERR_REGISTRATION_NOT_FOUND       ErrorCode = "registration_not_found"
+	ERR_WALLET_NOT_REGISTERED        ErrorCode = "wallet_not_registered"
+	ERR_ROUTE_NOT_FOUND              ErrorCode = "route_not_found"
+	ERR_REFINEMENT_FAILED            ErrorCode = "refinement_failed"
+	ERR_DATASET_ACCESS_DENIED        ErrorCode = "dataset_access_denied"
+	ERR_PRIVACY_BUDGET_EXHAUSTED     ErrorCode = "privacy_budget_exhausted"
+	ERR_DATASET_KEY_NOT_FOUND        ErrorCode = "dataset_key_not_found"
+	ERR_DATASET_KEY_REVOKED          ErrorCode = "dataset_key_revoked"
+	ERR_DATASET_KEY_NOT_HELD         ErrorCode = "dataset_key_not_held"
+	ERR_CONTRIBUTOR_KEY_NOT_FOUND    ErrorCode = "contributor_key_not_found"
+	ERR_CHAIN_UNAVAILABLE            ErrorCode = "chain_unavailable"
+	ERR_INTERNAL                     ErrorCode = "internal_error"
+)
+
+type errorCodeInfo struct {
+	Status int
+	Title  string
+}
+
+// Catalogue of error codes, their HTTP status and a human-readable title.
+// It is published in the OpenAPI document so clients can branch on codes
+// instead of matching message strings.
+var errorCatalogue = map[ErrorCode]errorCodeInfo{
+	ERR_INVALID_REQUEST:              {http.StatusBadRequest, "Request body or parameters are invalid"},
+	ERR_INVALID_DATA_FORMAT:          {http.StatusBadRequest, "Uploaded data does not match the expected format"},
+	ERR_UNSUPPORTED_DATA_TYPE:        {http.StatusBadRequest, "No refinement schema is registered for the data type"},
+	ERR_UPLOAD_TOO_LARGE:             {http.StatusRequestEntityTooLarge, "Upload exceeds the maximum size"},
+	ERR_UPLOAD_NOT_FOUND:             {http.StatusNotFound, "Upload does not exist or has expired"},
+	ERR_UPLOAD_OFFSET_MISMATCH:       {http.StatusConflict, "Upload-Offset does not match the upload; resume from the returned Upload-Offset"},
+	ERR_UPLOAD_INCOMPLETE:            {http.StatusConflict, "Upload has not received every byte yet"},
+	ERR_UPLOAD_CLOSED:                {http.StatusConflict, "Upload no longer accepts changes"},
+	ERR_UPLOAD_INTERRUPTED:           {http.StatusBadRequest, "Chunk was cut short; bytes before the failure were stored"},
+	ERR_CHECKSUM_MISMATCH:            {460, "Data does not match its checksum"}, // tus checksum mismatch status
+	ERR_EMPTY_BATCH:                  {http.StatusBadRequest, "Event batch is empty"},
+	ERR_BATCH_TOO_LARGE:              {http.StatusBadRequest, "Event batch exceeds the maximum size"},
+	ERR_INVALID_EVENTS:               {http.StatusUnprocessableEntity, "Every event in the batch failed validation"},
+	ERR_UNSUPPORTED_ENCODING:         {http.StatusUnsupportedMediaType, "Content-Encoding is not supported or the body is not valid for it"},
+	ERR_STREAM_ABORTED:               {http.StatusBadRequest, "Stream was cut short; lines before the failure were stored"},
+	ERR_INGESTION_BUSY:               {http.StatusServiceUnavailable, "Too many concurrent uploads, retry later"},
+	ERR_CONSENT_REVOKED:              {http.StatusForbidden, "User revoked consent to event collection"},
+	ERR_INVALID_SIWE_MESSAGE:         {http.StatusBadRequest, "SIWE message could not be parsed"},
+	ERR_INVALID_NONCE:                {http.StatusBadRequest, "Nonce is unknown, used or expired"},
+	ERR_INVALID_SIGNATURE:            {http.StatusUnauthorized, "Signature verification failed"},
+	ERR_AUTH_REQUIRED:                {http.StatusUnauthorized, "Bearer token is missing"},
+	ERR_INVALID_TOKEN:                {http.StatusUnauthorized, "Token is malformed, expired or has an invalid signature"},
+	ERR_SESSION_EXPIRED:              {http.StatusUnauthorized, "Session is expired or was logged out"},
+	ERR_ADDRESS_MISMATCH:             {http.StatusForbidden, "Address does not match the authenticated wallet"},
+	ERR_REGISTRY_MEMBERSHIP_REQUIRED: {http.StatusForbidden, "Wallet is not a member of the Registry contract"},
+	ERR_REGISTRATION_NOT_FOUND:       {http.StatusNotFound, "Registration does not exist"},
+	ERR_WALLET_NOT_REGISTERED:        {http.StatusNotFound, "Wallet has not been registered"},
+	ERR_ROUTE_NOT_FOUND:              {http.StatusNotFound, "No route matches the request"},
+	ERR_REFINEMENT_FAILED:            {http.StatusInternalServerError, "Data refinement failed"},
+	ERR_DATASET_ACCESS_DENIED:        {http.StatusForbidden, "Buyer has no QueryEngine access to the dataset"},
+	ERR_PRIVACY_BUDGET_EXHAUSTED:     {http.StatusForbidden, "Dataset privacy budget is exhausted"},
+	ERR_DATASET_KEY_NOT_FOUND:        {http.StatusNotFound, "No encryption key is stored for the dataset"},
+	ERR_DATASET_KEY_REVOKED:          {http.StatusGone, "Contributor revoked the dataset key"},
+	ERR_DATASET_KEY_NOT_HELD:         {http.StatusConflict, "Contributor holds no copy of the dataset key"},
+	ERR_CONTRIBUTOR_KEY_NOT_FOUND:    {http.StatusNotFound, "No contributor encryption key is registered"},
+	ERR_CHAIN_UNAVAILABLE:            {http.StatusBadGateway, "Blockchain request failed"},
+	ERR_INTERNAL:                     {http.StatusInternalServerError, "Internal server error"},
+}
+
+// RFC 7807 problem details body with TubeDAO extension members
+type ProblemDetails struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Detail    string      `json:"detail,omitempty"`
+	Instance  string      `json:"instance,omitempty"`
+	Code      ErrorCode   `json:"code"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
+}
+
+// Validation failure for a single request field
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Write a problem+json error response for code and abort the handler chain.
+// details, if given, is attached as the "details" extension member.
+func respondError(c *gin.Context, code ErrorCode, detail string, details ...interface{}) {
+	info, ok := errorCatalogue[code]
+	if !ok {
+		code = ERR_INTERNAL
+		info = errorCatalogue[ERR_INTERNAL]
+	}
+
+	problem := ProblemDetails{
+		Type:      PROBLEM_TYPE_PREFIX + string(code),
+		Title:     info.Title,
+		Status:    info.Status,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		Code:      code,
+		RequestID: c.GetString("requestId"),
+	}
+	if len(details) == 1 {
+		problem.Details = details[0]
+	} else if len(details) > 1 {
+		problem.Details = details
+	}
+
+	c.Header("Content-Type", PROBLEM_CONTENT_TYPE)
+	c.AbortWithStatusJSON(info.Status, problem)
+}
+
+// Report validation failures using JSON field names rather than Go names
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				return field.Name
+			}
+			return name
+		})
+	}
+}
+
+// Respond to a failed ShouldBind* call, listing the offending fields when
+// the failure came from validation rather than malformed JSON
+func respondBindError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(c, ERR_UPLOAD_TOO_LARGE, fmt.Sprintf("Request body exceeds %d bytes; use /api/uploads for large files", tooLarge.Limit))
+		return
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		respondError(c, ERR_INVALID_REQUEST, err.Error())
+		return
+	}
+
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		reason := fieldErr.Tag()
+		if fieldErr.Param() != "" {
+			reason = fmt.Sprintf("%s=%s", fieldErr.Tag(), fieldErr.Param())
+		}
+		fields = append(fields, FieldError{Field: fieldErr.Field(), Reason: reason})
+	}
+
+	respondError(c, ERR_INVALID_REQUEST, "Request validation failed", fields)
+}
+
+// Handlers for unmatched routes and recovered panics
+func notFoundHandler(c *gin.Context) {
+	respondError(c, ERR_ROUTE_NOT_FOUND, fmt.Sprintf("%s %s", c.Request.Method, c.Request.URL.Path))
+}
+
+func recoveryHandler(c *gin.Context, recovered interface{}) {
+	respondError(c, ERR_INTERNAL, "")
+}
+
+// Error codes in a stable order for documentation
+func sortedErrorCodes() []ErrorCode {
+	codes := make([]ErrorCode, 0, len(errorCatalogue))
+	for code := range errorCatalogue {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
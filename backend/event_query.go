@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Timezone names for analytics must resolve on hosts without zoneinfo
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	EVENT_PAGE_DEFAULT_LIMIT    = 50
+	EVENT_PAGE_MAX_LIMIT        = 200
+	TOP_CHANNELS_DEFAULT_LIMIT  = 10
+	TOP_CHANNELS_MAX_LIMIT      = 100
+	PROGRESS_CHECKPOINT_SECONDS = 10
+	ANALYTICS_DEFAULT_WINDOW    = 30 * 24 * time.Hour
+	ANALYTICS_MAX_WINDOW        = 366 * 24 * time.Hour
+	EVENT_QUERY_TIMEOUT         = 15 * time.Second
+)
+
+// Position in the newest-first event listing: the last event of a page
+type eventCursor struct {
+	Timestamp time.Time          `json:"t"`
+	ID        primitive.ObjectID `json:"id"`
+}
+
+// Time range and timezone of an analytics request
+type analyticsWindow struct {
+	From     time.Time
+	To       time.Time
+	Location *time.Location
+}
+
+func (w analyticsWindow) timestampFilter() bson.M {
+	return bson.M{"$gte": w.From, "$lt": w.To}
+}
+
+// Read decoded eventData as plain objects so it serializes as JSON objects
+// rather than key/value arrays
+func userEventsForRead() *mongo.Collection {
+	return db.Collection(USER_EVENTS_COLLECTION,
+		options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true}))
+}
+
+// List a user's events, newest first, filtered by time range, category,
+// event type and page domain
+func getUserEvents(c *gin.Context) {
+	address, ok := requireOwnAddress(c)
+	if !ok {
+		return
+	}
+
+	var errs []FieldError
+	filter := bson.M{"address": address}
+
+	from, to, rangeErrs := parseTimeRange(c)
+	errs = append(errs, rangeErrs...)
+	timestamp := bson.M{}
+	if from != nil {
+		timestamp["$gte"] = *from
+	}
+	if to != nil {
+		timestamp["$lt"] = *to
+	}
+
+	if category := strings.ToLower(c.Query("category")); category != "" {
+		if _, known := eventVocabulary[category]; !known {
+			errs = append(errs, FieldError{Field: "category", Reason: "unknown category"})
+		}
+		filter["category"] = category
+	}
+	if eventType := strings.ToLower(c.Query("eventType")); eventType != "" {
+		filter["eventType"] = eventType
+	}
+	if domain := strings.TrimPrefix(strings.ToLower(c.Query("domain")), "www."); domain != "" {
+		filter["pageDomain"] = domain
+	}
+
+	limit, err := queryLimit(c, EVENT_PAGE_DEFAULT_LIMIT, EVENT_PAGE_MAX_LIMIT)
+	if err != nil {
+		errs = append(errs, FieldError{Field: "limit", Reason: err.Error()})
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeEventCursor(value)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "cursor", Reason: "invalid cursor"})
+		} else {
+			filter["$or"] = bson.A{
+				bson.M{"timestamp": bson.M{"$lt": cursor.Timestamp}},
+				bson.M{"timestamp": cursor.Timestamp, "_id": bson.M{"$lt": cursor.ID}},
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		respondError(c, ERR_INVALID_REQUEST, "Invalid query parameters", errs)
+		return
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), EVENT_QUERY_TIMEOUT)
+	defer cancel()
+
+	// One extra event tells whether another page follows
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+	cursor, err := userEventsForRead().Find(ctx, filter, findOptions)
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to fetch events")
+		return
+	}
+
+	events := []UserEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to decode events")
+		return
+	}
+
+	page := EventPage{Events: events}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		page.NextCursor = encodeEventCursor(page.Events[limit-1])
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// Watch time per day, in the timezone given by ?tz= (default UTC)
+func getWatchTimeAnalytics(c *gin.Context) {
+	address, window, ok := analyticsScope(c)
+	if !ok {
+		return
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"address":   address,
+			"category":  "playback",
+			"eventType": "progress_checkpoint",
+			"timestamp": window.timestampFilter(),
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         dayExpression(window),
+			"checkpoints": bson.M{"$sum": 1},
+			"videos":      bson.M{"$addToSet": "$eventData.videoId"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"watchSeconds": bson.M{"$multiply": bson.A{"$checkpoints", PROGRESS_CHECKPOINT_SECONDS}},
+			"videoCount":   bson.M{"$size": "$videos"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+
+	days := []WatchTimeDay{}
+	if err := aggregateEvents(c.Request.Context(), pipeline, &days); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to compute watch time")
+		return
+	}
+
+	response := WatchTimeResponse{From: window.From, To: window.To, Timezone: window.Location.String(), Days: days}
+	for _, day := range days {
+		response.TotalSeconds += day.WatchSeconds
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Channels ranked by watch time; ?limit= caps the number returned
+func getTopChannelsAnalytics(c *gin.Context) {
+	address, window, ok := analyticsScope(c)
+	if !ok {
+		return
+	}
+
+	limit, err := queryLimit(c, TOP_CHANNELS_DEFAULT_LIMIT, TOP_CHANNELS_MAX_LIMIT)
+	if err != nil {
+		respondError(c, ERR_INVALID_REQUEST, "Invalid query parameters", []FieldError{{Field: "limit", Reason: err.Error()}})
+		return
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"address":             address,
+			"category":            "playback",
+			"eventType":           "progress_checkpoint",
+			"timestamp":           window.timestampFilter(),
+			"eventData.channelId": bson.M{"$exists": true},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         "$eventData.channelId",
+			"channelName": bson.M{"$max": "$eventData.channelName"},
+			"checkpoints": bson.M{"$sum": 1},
+			"videos":      bson.M{"$addToSet": "$eventData.videoId"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"channelName":  1,
+			"watchSeconds": bson.M{"$multiply": bson.A{"$checkpoints", PROGRESS_CHECKPOINT_SECONDS}},
+			"videoCount":   bson.M{"$size": "$videos"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "watchSeconds", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	channels := []ChannelWatchTime{}
+	if err := aggregateEvents(c.Request.Context(), pipeline, &channels); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to compute top channels")
+		return
+	}
+
+	c.JSON(http.StatusOK, TopChannelsResponse{From: window.From, To: window.To, Channels: channels})
+}
+
+// Ad impressions and skips, per day and by ad position
+func getAdImpressionsAnalytics(c *gin.Context) {
+	address, window, ok := analyticsScope(c)
+	if !ok {
+		return
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"address":   address,
+			"category":  "ad",
+			"timestamp": window.timestampFilter(),
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"day":       dayExpression(window),
+				"eventType": "$eventType",
+				"position":  "$eventData.adPosition",
+			},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+
+	var groups []struct {
+		Key struct {
+			Day       string `bson:"day"`
+			EventType string `bson:"eventType"`
+			Position  string `bson:"position"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := aggregateEvents(c.Request.Context(), pipeline, &groups); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to compute ad impressions")
+		return
+	}
+
+	response := AdImpressionsResponse{
+		From:       window.From,
+		To:         window.To,
+		Timezone:   window.Location.String(),
+		ByPosition: make(map[string]int),
+		Days:       []AdImpressionDay{},
+	}
+	days := make(map[string]*AdImpressionDay)
+	for _, group := range groups {
+		day := days[group.Key.Day]
+		if day == nil {
+			day = &AdImpressionDay{Day: group.Key.Day}
+			days[group.Key.Day] = day
+		}
+		switch group.Key.EventType {
+		case "ad_start":
+			day.Impressions += group.Count
+			response.Impressions += group.Count
+			response.ByPosition[group.Key.Position] += group.Count
+		case "ad_skip":
+			day.Skips += group.Count
+			response.Skips += group.Count
+		}
+	}
+	for _, day := range days {
+		response.Days = append(response.Days, *day)
+	}
+	sort.Slice(response.Days, func(i, j int) bool { return response.Days[i].Day < response.Days[j].Day })
+	if response.Impressions > 0 {
+		response.SkipRate = float64(response.Skips) / float64(response.Impressions)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Engagement events counted by type
+func getEngagementAnalytics(c *gin.Context) {
+	address, window, ok := analyticsScope(c)
+	if !ok {
+		return
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"address":   address,
+			"category":  "engagement",
+			"timestamp": window.timestampFilter(),
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$eventType", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	counts := []EventTypeCount{}
+	if err := aggregateEvents(c.Request.Context(), pipeline, &counts); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to compute engagement counts")
+		return
+	}
+
+	response := EngagementResponse{From: window.From, To: window.To, Counts: counts}
+	for _, count := range counts {
+		response.Total += count.Count
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Abort with an error unless the address path parameter is the caller's
+func requireOwnAddress(c *gin.Context) (string, bool) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return "", false
+	}
+	return address, true
+}
+
+// Resolve the address and time window of an analytics request. The window
+// defaults to the last ANALYTICS_DEFAULT_WINDOW and may not exceed
+// ANALYTICS_MAX_WINDOW.
+func analyticsScope(c *gin.Context) (string, analyticsWindow, bool) {
+	address, ok := requireOwnAddress(c)
+	if !ok {
+		return "", analyticsWindow{}, false
+	}
+
+	from, to, errs := parseTimeRange(c)
+
+	window := analyticsWindow{To: time.Now().UTC(), Location: time.UTC}
+	if to != nil {
+		window.To = *to
+	}
+	window.From = window.To.Add(-ANALYTICS_DEFAULT_WINDOW)
+	if from != nil {
+		window.From = *from
+	}
+	if len(errs) == 0 && window.To.Sub(window.From) > ANALYTICS_MAX_WINDOW {
+		errs = append(errs, FieldError{Field: "from", Reason: fmt.Sprintf("range may not exceed %d days", int(ANALYTICS_MAX_WINDOW.Hours()/24))})
+	}
+
+	// "Local" is the server's zone to Go but unknown to MongoDB, which
+	// is handed the name
+	if tz := c.Query("tz"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			errs = append(errs, FieldError{Field: "tz", Reason: "unknown IANA timezone"})
+		} else {
+			window.Location = location
+		}
+	}
+
+	if len(errs) > 0 {
+		respondError(c, ERR_INVALID_REQUEST, "Invalid query parameters", errs)
+		return "", analyticsWindow{}, false
+	}
+	return address, window, true
+}
+
+// Parse the optional RFC 3339 ?from= (inclusive) and ?to= (exclusive) bounds
+func parseTimeRange(c *gin.Context) (from, to *time.Time, errs []FieldError) {
+	for _, bound := range []struct {
+		name   string
+		target **time.Time
+	}{{"from", &from}, {"to", &to}} {
+		value := c.Query(bound.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			errs = append(errs, FieldError{Field: bound.name, Reason: "must be an RFC 3339 timestamp"})
+			continue
+		}
+		parsed = parsed.UTC()
+		*bound.target = &parsed
+	}
+
+	if from != nil && to != nil && !from.Before(*to) {
+		errs = append(errs, FieldError{Field: "to", Reason: "must be after from"})
+	}
+	return from, to, errs
+}
+
+// Parse ?limit=, falling back to defaultLimit
+func queryLimit(c *gin.Context, defaultLimit, maxLimit int) (int, error) {
+	value := c.Query("limit")
+	if value == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, fmt.Errorf("range=1..%d", maxLimit)
+	}
+	return limit, nil
+}
+
+// Calendar day of an event's timestamp in the window's timezone
+func dayExpression(window analyticsWindow) bson.M {
+	return bson.M{"$dateToString": bson.M{
+		"format":   "%Y-%m-%d",
+		"date":     "$timestamp",
+		"timezone": window.Location.String(),
+	}}
+}
+
+func aggregateEvents(ctx context.Context, pipeline mongo.Pipeline, results interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, EVENT_QUERY_TIMEOUT)
+	defer cancel()
+
+	cursor, err := db.Collection(USER_EVENTS_COLLECTION).Aggregate(ctx, pipeline)
+	if err != nil {
+		loggerFromContext(ctx).Error("Event aggregation failed", "error", err)
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+func encodeEventCursor(event UserEvent) string {
+	data, _ := json.Marshal(eventCursor{Timestamp: event.Timestamp, ID: event.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeEventCursor(value string) (eventCursor, error) {
+	var cursor eventCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, err
+	}
+	if cursor.ID.IsZero() {
+		return cursor, fmt.Errorf("cursor is missing an event ID")
+	}
+	return cursor, nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	USER_EVENTS_COLLECTION   = "user_events"
+	MAX_EVENTS_PER_BATCH     = 50
+	EVENT_RETENTION_DAYS     = 365
+	EVENT_TTL_INDEX_NAME     = "timestamp_ttl"
+	EVENT_ID_INDEX_NAME      = "address_eventId_unique"
+	MIGRATION_INSERT_BATCH   = 1000
+	EVENT_INDEX_INIT_TIMEOUT = 30 * time.Second
+)
+
+// Age after which stored events expire, zero if they are kept forever.
+// Ingestion rejects older events since the TTL index would delete them.
+var eventRetention time.Duration
+
+// Create the indexes used by event queries and the retention TTL index.
+// EVENT_RETENTION_DAYS=0 disables expiry.
+func initEventStore() error {
+	ctx, cancel := context.WithTimeout(context.Background(), EVENT_INDEX_INIT_TIMEOUT)
+	defer cancel()
+
+	collection := db.Collection(USER_EVENTS_COLLECTION)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "address", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "address", Value: 1}, {Key: "category", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "address", Value: 1}, {Key: "sessionId", Value: 1}}},
+		{Keys: bson.D{{Key: "address", Value: 1}, {Key: "pageDomain", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{
+			Keys: bson.D{{Key: "address", Value: 1}, {Key: "eventId", Value: 1}},
+			Options: options.Index().
+				SetName(EVENT_ID_INDEX_NAME).
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"eventId": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create event indexes: %v", err)
+	}
+
+	retentionDays := getEnvInt("EVENT_RETENTION_DAYS", EVENT_RETENTION_DAYS)
+	if err := ensureEventTTLIndex(ctx, collection, retentionDays); err != nil {
+		return err
+	}
+
+	eventRetention = 0
+	if retentionDays > 0 {
+		eventRetention = time.Duration(retentionDays) * 24 * time.Hour
+	}
+
+	slog.Info("Event store initialized", "retentionDays", retentionDays)
+	return nil
+}
+
+// Create, update or drop the TTL index on event timestamps so it matches
+// the configured retention
+func ensureEventTTLIndex(ctx context.Context, collection *mongo.Collection, retentionDays int) error {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list event indexes: %v", err)
+	}
+
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return fmt.Errorf("failed to decode event indexes: %v", err)
+	}
+
+	var current *int64
+	for _, index := range indexes {
+		if index["name"] == EVENT_TTL_INDEX_NAME {
+			seconds := toInt64(index["expireAfterSeconds"])
+			current = &seconds
+		}
+	}
+
+	if retentionDays <= 0 {
+		if current != nil {
+			if _, err := collection.Indexes().DropOne(ctx, EVENT_TTL_INDEX_NAME); err != nil {
+				return fmt.Errorf("failed to drop event TTL index: %v", err)
+			}
+		}
+		return nil
+	}
+
+	expireAfter := int64(retentionDays) * 24 * 60 * 60
+	if current == nil {
+		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "timestamp", Value: 1}},
+			Options: options.Index().
+				SetName(EVENT_TTL_INDEX_NAME).
+				SetExpireAfterSeconds(int32(expireAfter)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create event TTL index: %v", err)
+		}
+		return nil
+	}
+
+	if *current != expireAfter {
+		err := db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: USER_EVENTS_COLLECTION},
+			{Key: "index", Value: bson.D{
+				{Key: "name", Value: EVENT_TTL_INDEX_NAME},
+				{Key: "expireAfterSeconds", Value: expireAfter},
+			}},
+		}).Err()
+		if err != nil {
+			return fmt.Errorf("failed to update event TTL index: %v", err)
+		}
+	}
+	return nil
+}
+
+// Store validated events, one document per event. Events whose
+// (address, eventId) already exists are skipped and reported by their index
+// in events, so client retries never store an event twice.
+func insertUserEvents(ctx context.Context, events []interface{}) (duplicates map[int]bool, err error) {
+	duplicates = make(map[int]bool)
+	if len(events) == 0 {
+		return duplicates, nil
+	}
+
+	_, err = db.Collection(USER_EVENTS_COLLECTION).InsertMany(ctx, events, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return duplicates, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return duplicates, err
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr) {
+			return duplicates, err
+		}
+		duplicates[writeErr.Index] = true
+	}
+	return duplicates, nil
+}
+
+// Outcome of validating and storing one batch of events
+type eventBatchResult struct {
+	AcceptedCount  int
+	DuplicateCount int
+	Rejected       []EventRejection
+}
+
+// Validate, normalize and store a batch of raw events for address
+func ingestEventBatch(ctx context.Context, address, batchID string, raws []json.RawMessage) (eventBatchResult, error) {
+	var result eventBatchResult
+
+	now := time.Now()
+	var events []interface{}
+	for i, raw := range raws {
+		event, errs := normalizeEvent(raw, address, batchID, now)
+		if len(errs) > 0 {
+			var header struct {
+				EventType string `json:"eventType"`
+			}
+			json.Unmarshal(raw, &header)
+			result.Rejected = append(result.Rejected, EventRejection{Index: i, EventType: header.EventType, Errors: errs})
+			continue
+		}
+		events = append(events, event)
+	}
+
+	duplicates, err := insertUserEvents(ctx, events)
+	if err != nil {
+		loggerFromContext(ctx).Error("Failed to insert events", "address", address, "batchId", batchID, "error", err)
+		return result, err
+	}
+
+	var accepted []interface{}
+	for i, event := range events {
+		if !duplicates[i] {
+			accepted = append(accepted, event)
+		}
+	}
+	result.AcceptedCount = len(accepted)
+	result.DuplicateCount = len(duplicates)
+
+	recordIngestedEvents(accepted, len(duplicates), len(result.Rejected))
+	loggerFromContext(ctx).Info("Stored uploaded events",
+		"address", address,
+		"batchId", batchID,
+		"accepted", result.AcceptedCount,
+		"duplicates", result.DuplicateCount,
+		"rejected", len(result.Rejected),
+	)
+
+	return result, nil
+}
+
+// Split legacy per-address documents holding an "events" array into one
+// document per event. Safe to re-run: events already copied from a legacy
+// document are replaced before that document is removed.
+func migrateLegacyEventDocuments(ctx context.Context) (documents int, events int, err error) {
+	collection := db.Collection(USER_EVENTS_COLLECTION)
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "migratedFrom", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create migration index: %v", err)
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"events": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query legacy event documents: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var legacy UserEventDocument
+		if err := cursor.Decode(&legacy); err != nil {
+			return documents, events, fmt.Errorf("failed to decode legacy document: %v", err)
+		}
+
+		if _, err := collection.DeleteMany(ctx, bson.M{"migratedFrom": legacy.ID}); err != nil {
+			return documents, events, fmt.Errorf("failed to clear partial migration of %s: %v", legacy.ID.Hex(), err)
+		}
+
+		batch := make([]interface{}, 0, MIGRATION_INSERT_BATCH)
+		for _, raw := range legacy.Events {
+			event := legacyEventToUserEvent(legacy, raw)
+			batch = append(batch, event)
+
+			if len(batch) == MIGRATION_INSERT_BATCH {
+				if _, err := insertUserEvents(ctx, batch); err != nil {
+					return documents, events, fmt.Errorf("failed to insert migrated events: %v", err)
+				}
+				events += len(batch)
+				batch = batch[:0]
+			}
+		}
+		if _, err := insertUserEvents(ctx, batch); err != nil {
+			return documents, events, fmt.Errorf("failed to insert migrated events: %v", err)
+		}
+		events += len(batch)
+
+		if _, err := collection.DeleteOne(ctx, bson.M{"_id": legacy.ID}); err != nil {
+			return documents, events, fmt.Errorf("failed to remove legacy document %s: %v", legacy.ID.Hex(), err)
+		}
+		documents++
+
+		slog.Info("Migrated legacy event document", "address", legacy.Address, "events", len(legacy.Events))
+	}
+
+	return documents, events, cursor.Err()
+}
+
+// Convert one element of a legacy events array. Elements are either
+// UserEvent documents or raw extension events with snake_case fields;
+// anything not part of the envelope is kept as eventData.
+func legacyEventToUserEvent(legacy UserEventDocument, raw interface{}) UserEvent {
+	fields := toBsonM(raw)
+	source := legacy.ID
+
+	event := UserEvent{
+		Address:      legacy.Address,
+		CreatedAt:    legacy.CreatedAt,
+		MigratedFrom: &source,
+	}
+
+	if _, typed := fields["eventType"]; typed {
+		data, _ := bson.Marshal(fields)
+		bson.Unmarshal(data, &event)
+		event.ID = primitive.NilObjectID
+		event.Address = legacy.Address
+		event.MigratedFrom = &source
+		return event
+	}
+
+	envelope := map[string]*string{
+		"event_type": &event.EventType,
+		"category":   &event.Category,
+		"session_id": &event.SessionID,
+		"page_url":   &event.PageURL,
+		"adapter":    &event.Adapter,
+		"user_agent": &event.UserAgent,
+	}
+	for key, target := range envelope {
+		if value, ok := fields[key].(string); ok {
+			*target = value
+		}
+		delete(fields, key)
+	}
+
+	event.Timestamp = legacy.CreatedAt
+	if value, ok := fields["timestamp"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			event.Timestamp = parsed.UTC()
+		}
+	}
+	delete(fields, "timestamp")
+
+	if viewport := toBsonM(fields["viewport"]); viewport != nil {
+		event.Viewport = ViewportData{Width: int(toInt64(viewport["width"])), Height: int(toInt64(viewport["height"]))}
+	}
+	delete(fields, "viewport")
+
+	if site, ok := fields["site"].(string); ok {
+		event.PageDomain = strings.TrimPrefix(strings.ToLower(site), "www.")
+	}
+	delete(fields, "site")
+
+	event.EventData = fields
+	return event
+}
+
+func toBsonM(value interface{}) bson.M {
+	switch v := value.(type) {
+	case bson.M:
+		return v
+	case map[string]interface{}:
+		return v
+	case bson.D:
+		m := make(bson.M, len(v))
+		for _, element := range v {
+			m[element.Key] = element.Value
+		}
+		return m
+	}
+	return nil
+}
+
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	case int:
+		return int64(v)
+	}
+	return 0
+}
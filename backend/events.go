@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_EVENT_CLOCK_SKEW    = 5 * time.Minute
+	MAX_SESSION_ID_LENGTH   = 128
+	MAX_PAGE_URL_LENGTH     = 2048
+	MAX_USER_AGENT_LENGTH   = 512
+	MAX_EVENT_DATA_BYTES    = 16 * 1024
+	MAX_VIEWPORT_DIMENSION  = 16384
+	MAX_EVENT_STRING_LENGTH = 256
+	MAX_CLIENT_ID_LENGTH    = 64
+)
+
+var (
+	youtubeVideoIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	clientIDPattern       = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+	// Legacy UC... channel IDs or @handles
+	youtubeChannelPattern = regexp.MustCompile(`^(UC[A-Za-z0-9_-]{22}|@[^\s/?#]{1,100})$`)
+)
+
+// Event as sent by the extension before validation. Pointer fields
+// distinguish missing values from zero values.
+type IncomingEvent struct {
+	EventID   string          `json:"eventId"`
+	EventType string          `json:"eventType"`
+	Category  string          `json:"category"`
+	Timestamp *time.Time      `json:"timestamp"`
+	SessionID string          `json:"sessionId"`
+	PageURL   string          `json:"pageUrl"`
+	Adapter   string          `json:"adapter"`
+	UserAgent string          `json:"userAgent"`
+	Viewport  *ViewportData   `json:"viewport"`
+	EventData json.RawMessage `json:"eventData"`
+}
+
+// Typed payload of an event; validate reports every invalid field
+type eventPayload interface {
+	validate() []FieldError
+}
+
+// Payload of playback events emitted by the YouTube adapter
+type PlaybackEventData struct {
+	VideoID                 string   `json:"video_id" bson:"videoId"`
+	CurrentTimeSeconds      *float64 `json:"current_time_seconds,omitempty" bson:"currentTimeSeconds,omitempty"`
+	DurationSeconds         *float64 `json:"duration_seconds,omitempty" bson:"durationSeconds,omitempty"`
+	WatchProgressPercentage *float64 `json:"watch_progress_percentage,omitempty" bson:"watchProgressPercentage,omitempty"`
+	Volume                  *float64 `json:"volume,omitempty" bson:"volume,omitempty"`
+	PlaybackRate            *float64 `json:"playback_rate,omitempty" bson:"playbackRate,omitempty"`
+	VideoQuality            string   `json:"video_quality,omitempty" bson:"videoQuality,omitempty"`
+	IsPaused                *bool    `json:"is_paused,omitempty" bson:"isPaused,omitempty"`
+	IsMuted                 *bool    `json:"is_muted,omitempty" bson:"isMuted,omitempty"`
+	ProgressSeconds         *float64 `json:"progress_seconds,omitempty" bson:"progressSeconds,omitempty"`
+	ProgressPercentage      *float64 `json:"progress_percentage,omitempty" bson:"progressPercentage,omitempty"`
+	Quality                 string   `json:"quality,omitempty" bson:"quality,omitempty"`
+
+	VideoChannel `bson:",inline"`
+}
+
+func (p *PlaybackEventData) validate() []FieldError {
+	var errs []FieldError
+	errs = appendVideoIDError(errs, p.VideoID)
+	errs = appendChannelErrors(errs, &p.VideoChannel)
+	errs = appendRangeError(errs, "current_time_seconds", p.CurrentTimeSeconds, 0, math.MaxFloat64)
+	errs = appendRangeError(errs, "duration_seconds", p.DurationSeconds, 0, math.MaxFloat64)
+	errs = appendRangeError(errs, "watch_progress_percentage", p.WatchProgressPercentage, 0, 100)
+	errs = appendRangeError(errs, "volume", p.Volume, 0, 100)
+	errs = appendRangeError(errs, "playback_rate", p.PlaybackRate, 0.0625, 16)
+	errs = appendRangeError(errs, "progress_seconds", p.ProgressSeconds, 0, math.MaxFloat64)
+	errs = appendRangeError(errs, "progress_percentage", p.ProgressPercentage, 0, 100)
+	return errs
+}
+
+// Payload of ad events emitted by the YouTube adapter. Ads can play off
+// watch pages, e.g. on the home feed, so the video ID is optional.
+type AdEventData struct {
+	VideoID    string   `json:"video_id,omitempty" bson:"videoId,omitempty"`
+	AdPosition string   `json:"ad_position" bson:"adPosition"`
+	AdDuration *float64 `json:"ad_duration,omitempty" bson:"adDuration,omitempty"`
+
+	VideoChannel `bson:",inline"`
+}
+
+var adPositions = map[string]bool{"pre-roll": true, "mid-roll": true, "post-roll": true, "unknown": true}
+
+func (p *AdEventData) validate() []FieldError {
+	var errs []FieldError
+	errs = appendOptionalVideoIDError(errs, p.VideoID)
+	errs = appendChannelErrors(errs, &p.VideoChannel)
+	if !adPositions[p.AdPosition] {
+		errs = append(errs, FieldError{Field: "eventData.ad_position", Reason: "oneof=pre-roll mid-roll post-roll unknown"})
+	}
+	errs = appendRangeError(errs, "ad_duration", p.AdDuration, 0, math.MaxFloat64)
+	return errs
+}
+
+// Payload of engagement and navigation events, usually tied to a video.
+// Like, subscribe and share buttons also appear on pages without one, so
+// the video ID is optional.
+type VideoInteractionEventData struct {
+	VideoID            string   `json:"video_id,omitempty" bson:"videoId,omitempty"`
+	ElementType        string   `json:"element_type,omitempty" bson:"elementType,omitempty"`
+	PageType           string   `json:"page_type,omitempty" bson:"pageType,omitempty"`
+	CurrentTimeSeconds *float64 `json:"current_time_seconds,omitempty" bson:"currentTimeSeconds,omitempty"`
+
+	VideoChannel `bson:",inline"`
+}
+
+func (p *VideoInteractionEventData) validate() []FieldError {
+	var errs []FieldError
+	errs = appendOptionalVideoIDError(errs, p.VideoID)
+	errs = appendChannelErrors(errs, &p.VideoChannel)
+	errs = appendRangeError(errs, "current_time_seconds", p.CurrentTimeSeconds, 0, math.MaxFloat64)
+	return errs
+}
+
+// Channel that published the video, when the adapter could read it from
+// the watch page
+type VideoChannel struct {
+	ChannelID   string `json:"channel_id,omitempty" bson:"channelId,omitempty"`
+	ChannelName string `json:"channel_name,omitempty" bson:"channelName,omitempty"`
+}
+
+// Payload of scroll depth checkpoints
+type ScrollEventData struct {
+	ScrollDepthPercentage *float64 `json:"scroll_depth_percentage" bson:"scrollDepthPercentage"`
+	ScrollPosition        *float64 `json:"scroll_position,omitempty" bson:"scrollPosition,omitempty"`
+}
+
+func (p *ScrollEventData) validate() []FieldError {
+	var errs []FieldError
+	if p.ScrollDepthPercentage == nil {
+		errs = append(errs, FieldError{Field: "eventData.scroll_depth_percentage", Reason: "required"})
+	}
+	errs = appendRangeError(errs, "scroll_depth_percentage", p.ScrollDepthPercentage, 0, 100)
+	errs = appendRangeError(errs, "scroll_position", p.ScrollPosition, 0, math.MaxFloat64)
+	return errs
+}
+
+// Free-form payload for events without a dedicated schema
+type GenericEventData map[string]interface{}
+
+func (p *GenericEventData) validate() []FieldError {
+	return nil
+}
+
+// Event types accepted for each category, mapped to the payload they carry.
+// Mirrors what the adapters in chrome-extension/adapters emit.
+var eventVocabulary = map[string]map[string]func() eventPayload{
+	"playback": {
+		"play":                newPlaybackPayload,
+		"pause":               newPlaybackPayload,
+		"ended":               newPlaybackPayload,
+		"seek_start":          newPlaybackPayload,
+		"seek_end":            newPlaybackPayload,
+		"speed_change":        newPlaybackPayload,
+		"quality_change":      newPlaybackPayload,
+		"progress_checkpoint": newPlaybackPayload,
+		"fullscreen_toggle":   newVideoInteractionPayload,
+		"theater_mode":        newVideoInteractionPayload,
+	},
+	"ad": {
+		"ad_start": newAdPayload,
+		"ad_skip":  newAdPayload,
+	},
+	"engagement": {
+		"like_click":        newVideoInteractionPayload,
+		"subscribe_click":   newVideoInteractionPayload,
+		"share_click":       newVideoInteractionPayload,
+		"scroll_checkpoint": newScrollPayload,
+		"page_exit":         newGenericPayload,
+		"article_view":      newGenericPayload,
+		"article_exit":      newGenericPayload,
+		"reading_progress":  newGenericPayload,
+		"post_view":         newGenericPayload,
+		"tweet_view":        newGenericPayload,
+		"post_upvote":       newGenericPayload,
+		"post_downvote":     newGenericPayload,
+		"post_save":         newGenericPayload,
+		"post_share":        newGenericPayload,
+		"post_award":        newGenericPayload,
+		"subreddit_join":    newGenericPayload,
+		"tweet_like":        newGenericPayload,
+		"tweet_retweet":     newGenericPayload,
+		"tweet_reply":       newGenericPayload,
+		"tweet_share":       newGenericPayload,
+		"tweet_bookmark":    newGenericPayload,
+		"user_follow":       newGenericPayload,
+		"article_clap":      newGenericPayload,
+		"author_follow":     newGenericPayload,
+		"article_bookmark":  newGenericPayload,
+		"article_share":     newGenericPayload,
+		"text_highlight":    newGenericPayload,
+		"article_comment":   newGenericPayload,
+	},
+	"navigation": {
+		"video_load":         newVideoInteractionPayload,
+		"page_view":          newGenericPayload,
+		"navigation":         newGenericPayload,
+		"spa_navigation":     newGenericPayload,
+		"reddit_navigation":  newGenericPayload,
+		"twitter_navigation": newGenericPayload,
+	},
+	"interaction": {
+		"click":              newGenericPayload,
+		"input_interaction":  newGenericPayload,
+		"link_click":         newGenericPayload,
+		"post_click":         newGenericPayload,
+		"tweet_click":        newGenericPayload,
+		"author_click":       newGenericPayload,
+		"article_link_click": newGenericPayload,
+	},
+	"media": {
+		"video_play":     newGenericPayload,
+		"video_pause":    newGenericPayload,
+		"video_ended":    newGenericPayload,
+		"video_progress": newGenericPayload,
+		"audio_play":     newGenericPayload,
+		"audio_pause":    newGenericPayload,
+	},
+	"session": {
+		"session_start": newGenericPayload,
+		"session_end":   newGenericPayload,
+	},
+}
+
+func newPlaybackPayload() eventPayload         { return &PlaybackEventData{} }
+func newAdPayload() eventPayload               { return &AdEventData{} }
+func newVideoInteractionPayload() eventPayload { return &VideoInteractionEventData{} }
+func newScrollPayload() eventPayload           { return &ScrollEventData{} }
+func newGenericPayload() eventPayload          { return &GenericEventData{} }
+
+// Validate a raw event and normalize it into a UserEvent for address
+func normalizeEvent(raw json.RawMessage, address, batchID string, now time.Time) (UserEvent, []FieldError) {
+	var in IncomingEvent
+	if err := json.Unmarshal(raw, &in); err != nil {
+		return UserEvent{}, []FieldError{{Field: "event", Reason: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+
+	var errs []FieldError
+
+	if in.EventID == "" {
+		errs = append(errs, FieldError{Field: "eventId", Reason: "required"})
+	} else if len(in.EventID) > MAX_CLIENT_ID_LENGTH || !clientIDPattern.MatchString(in.EventID) {
+		errs = append(errs, FieldError{Field: "eventId", Reason: fmt.Sprintf("max=%d, characters A-Za-z0-9._-", MAX_CLIENT_ID_LENGTH)})
+	}
+
+	category := strings.ToLower(strings.TrimSpace(in.Category))
+	eventType := strings.ToLower(strings.TrimSpace(in.EventType))
+	types, knownCategory := eventVocabulary[category]
+	newPayload, knownType := types[eventType]
+	switch {
+	case category == "":
+		errs = append(errs, FieldError{Field: "category", Reason: "required"})
+	case !knownCategory:
+		errs = append(errs, FieldError{Field: "category", Reason: "unknown category"})
+	}
+	switch {
+	case eventType == "":
+		errs = append(errs, FieldError{Field: "eventType", Reason: "required"})
+	case knownCategory && !knownType:
+		errs = append(errs, FieldError{Field: "eventType", Reason: fmt.Sprintf("unknown event type for category %s", category)})
+	}
+
+	if in.Timestamp == nil {
+		errs = append(errs, FieldError{Field: "timestamp", Reason: "required"})
+	} else if in.Timestamp.After(now.Add(MAX_EVENT_CLOCK_SKEW)) {
+		errs = append(errs, FieldError{Field: "timestamp", Reason: "in the future"})
+	} else if eventRetention > 0 && in.Timestamp.Before(now.Add(-eventRetention)) {
+		errs = append(errs, FieldError{Field: "timestamp", Reason: fmt.Sprintf("older than the %d-day retention period", int(eventRetention.Hours()/24))})
+	}
+
+	sessionID := strings.TrimSpace(in.SessionID)
+	if sessionID == "" {
+		errs = append(errs, FieldError{Field: "sessionId", Reason: "required"})
+	} else if len(sessionID) > MAX_SESSION_ID_LENGTH {
+		errs = append(errs, FieldError{Field: "sessionId", Reason: fmt.Sprintf("max=%d", MAX_SESSION_ID_LENGTH)})
+	}
+
+	var pageURL *url.URL
+	if in.PageURL == "" {
+		errs = append(errs, FieldError{Field: "pageUrl", Reason: "required"})
+	} else if len(in.PageURL) > MAX_PAGE_URL_LENGTH {
+		errs = append(errs, FieldError{Field: "pageUrl", Reason: fmt.Sprintf("max=%d", MAX_PAGE_URL_LENGTH)})
+	} else if parsed, err := url.Parse(in.PageURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		errs = append(errs, FieldError{Field: "pageUrl", Reason: "must be an absolute http(s) URL"})
+	} else {
+		pageURL = parsed
+	}
+
+	if in.Viewport == nil {
+		errs = append(errs, FieldError{Field: "viewport", Reason: "required"})
+	} else if in.Viewport.Width <= 0 || in.Viewport.Height <= 0 ||
+		in.Viewport.Width > MAX_VIEWPORT_DIMENSION || in.Viewport.Height > MAX_VIEWPORT_DIMENSION {
+		errs = append(errs, FieldError{Field: "viewport", Reason: "width and height must be positive"})
+	}
+
+	var payload eventPayload
+	if len(in.EventData) > MAX_EVENT_DATA_BYTES {
+		errs = append(errs, FieldError{Field: "eventData", Reason: fmt.Sprintf("max=%d bytes", MAX_EVENT_DATA_BYTES)})
+	} else if newPayload != nil {
+		payload = newPayload()
+		if len(in.EventData) > 0 && string(in.EventData) != "null" {
+			if err := json.Unmarshal(in.EventData, payload); err != nil {
+				errs = append(errs, FieldError{Field: "eventData", Reason: fmt.Sprintf("invalid payload: %v", err)})
+			}
+		}
+		errs = append(errs, payload.validate()...)
+	}
+
+	if len(errs) > 0 {
+		return UserEvent{}, errs
+	}
+
+	return UserEvent{
+		EventID:    in.EventID,
+		BatchID:    batchID,
+		Address:    address,
+		EventType:  eventType,
+		Category:   category,
+		Timestamp:  in.Timestamp.UTC(),
+		SessionID:  sessionID,
+		PageURL:    pageURL.String(),
+		PageDomain: strings.TrimPrefix(strings.ToLower(pageURL.Hostname()), "www."),
+		Adapter:    truncateString(in.Adapter, MAX_EVENT_STRING_LENGTH),
+		UserAgent:  truncateString(in.UserAgent, MAX_USER_AGENT_LENGTH),
+		Viewport:   *in.Viewport,
+		EventData:  payload,
+		CreatedAt:  now,
+	}, nil
+}
+
+func appendVideoIDError(errs []FieldError, videoID string) []FieldError {
+	if videoID == "" {
+		return append(errs, FieldError{Field: "eventData.video_id", Reason: "required"})
+	}
+	if !youtubeVideoIDPattern.MatchString(videoID) {
+		return append(errs, FieldError{Field: "eventData.video_id", Reason: "invalid YouTube video ID"})
+	}
+	return errs
+}
+
+func appendOptionalVideoIDError(errs []FieldError, videoID string) []FieldError {
+	if videoID == "" {
+		return errs
+	}
+	return appendVideoIDError(errs, videoID)
+}
+
+func appendChannelErrors(errs []FieldError, channel *VideoChannel) []FieldError {
+	if channel.ChannelID != "" && !youtubeChannelPattern.MatchString(channel.ChannelID) {
+		errs = append(errs, FieldError{Field: "eventData.channel_id", Reason: "invalid YouTube channel ID or handle"})
+	}
+	channel.ChannelName = truncateString(strings.TrimSpace(channel.ChannelName), MAX_EVENT_STRING_LENGTH)
+	return errs
+}
+
+func appendRangeError(errs []FieldError, field string, value *float64, min, max float64) []FieldError {
+	if value == nil {
+		return errs
+	}
+	if math.IsNaN(*value) || *value < min || *value > max {
+		if max == math.MaxFloat64 {
+			return append(errs, FieldError{Field: "eventData." + field, Reason: fmt.Sprintf("min=%g", min)})
+		}
+		return append(errs, FieldError{Field: "eventData." + field, Reason: fmt.Sprintf("range=%g..%g", min, max)})
+	}
+	return errs
+}
+
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
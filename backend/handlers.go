@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/big"
 	"net/http"
 	"time"
@@ -12,100 +12,162 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const REGISTERED_WALLETS_COLLECTION = "registered_wallets"
+
+// Enforce one registered wallet per address. Fails while duplicates
+// registered before the index existed remain.
+func initRegisteredWallets() {
+	ctx, cancel := context.WithTimeout(context.Background(), EVENT_INDEX_INIT_TIMEOUT)
+	defer cancel()
+
+	_, err := db.Collection(REGISTERED_WALLETS_COLLECTION).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "address", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		slog.Error("Failed to create unique wallet address index; remove duplicate registered_wallets", "error", err)
+	}
+}
+
 // Upload user contribution data with VRC-15 compliant data refinement
 func uploadData(c *gin.Context) {
+	maxBytes := int64(getEnvInt("UPLOAD_DATA_MAX_BODY_MB", UPLOAD_DATA_MAX_BODY_MB)) << 20
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+
 	var req UploadDataRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
 	authAddress, _ := c.Get("address")
 	if authAddress != req.Address {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Address mismatch"})
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	dataSchema, err := lookupDataSchema(req.DataType)
+	if err != nil {
+		respondError(c, ERR_UNSUPPORTED_DATA_TYPE, err.Error())
+		return
+	}
+
+	if err := dataSchema.Validate(req.DataContent); err != nil {
+		respondError(c, ERR_INVALID_DATA_FORMAT, fmt.Sprintf("Invalid %s data: %v", dataSchema.DataType, err))
+		return
+	}
+
+	if err := validateMaskingOverrides(dataSchema, req.MaskingRules); err != nil {
+		respondError(c, ERR_INVALID_REQUEST, err.Error())
 		return
 	}
 
-	if err := validateYouTubeData(req.DataContent); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid YouTube data format"})
+	ctx := c.Request.Context()
+	logger := loggerFromContext(ctx)
+
+	maskingRules, err := resolveMaskingRules(ctx, dataSchema, req.Address, req.MaskingRules)
+	if err != nil {
+		logger.Error("Masking preferences unavailable", "error", err)
+		respondError(c, ERR_INTERNAL, "Failed to load masking preferences")
 		return
 	}
 
-	maskingRules := map[string]bool{
-		"titles":        false,
-		"channelNames":  false,
-		"searchQueries": false,
-		"timestamps":    false,
+	refinedData, err := processDataForVRC15(ctx, dataSchema, req.Address, req.DataContent, maskingRules)
+	if err != nil {
+		logger.Error("Data refinement failed", "error", err)
+		respondError(c, ERR_REFINEMENT_FAILED, "Data refinement failed")
+		return
 	}
 
-	refinedData, err := processDataForVRC15(req.Address, req.DataContent, maskingRules)
+	contribution, err := recordContribution(ctx, UserContribution{
+		Address:  req.Address,
+		DataType: dataSchema.DataType,
+		FileName: req.FileName,
+		FileSize: req.FileSize,
+	}, refinedData)
 	if err != nil {
-		log.Printf("Data refinement failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Data refinement failed"})
+		respondError(c, ERR_INTERNAL, "Failed to upload data")
 		return
 	}
 
-	qualityScore := uint8(refinedData.Schema.Metadata["dataQuality"].(float64))
+	c.JSON(http.StatusCreated, UploadDataResponse{Message: "Data uploaded successfully", Data: contribution, TxHash: contribution.TxHash})
+}
+
+// Submit refined data to the DataLiquidityPool, store the contribution and
+// schedule its validation. The caller fills in the address, data type and
+// file details; chain failures are logged and leave TxHash empty.
+func recordContribution(ctx context.Context, contribution UserContribution, refinedData *RefinedData) (UserContribution, error) {
+	logger := loggerFromContext(ctx)
+
+	qualityScore := uint8(refinedData.Schema.envelope().Metadata["dataQuality"].(float64))
 	dataHash := refinedData.Hash
 
 	var txHash string
 	if ethClient != nil && dataPoolAddress != (common.Address{}) {
-		contributorAddr := common.HexToAddress(req.Address)
+		contributorAddr := common.HexToAddress(contribution.Address)
 		hash, err := submitContributionToChain(
+			ctx,
 			contributorAddr,
-			req.DataType,
+			contribution.DataType,
 			dataHash,
 			refinedData.IPFSHash,
 		)
 		if err != nil {
-			log.Printf("Blockchain submission failed: %v", err)
+			logger.Error("Blockchain submission failed", "error", err)
 		} else {
 			txHash = hash.Hex()
 
-			jobId, err := createTEEValidationJob(dataHash, req.DataType)
+			jobId, err := createTEEValidationJob(ctx, dataHash, contribution.DataType)
 			if err != nil {
-				log.Printf("TEE job creation failed: %v", err)
+				logger.Error("TEE job creation failed", "error", err)
 			} else {
-				log.Printf("TEE validation job created: %x", jobId)
+				logger.Info("TEE validation job created", "jobId", common.Hash(jobId).Hex())
 			}
 		}
 	}
 
-	collection := db.Collection("user_contributions")
+	contribution.DataContent = refinedData.Schema
+	contribution.RewardAmount = float64(qualityScore) * 100
+	contribution.Timestamp = time.Now()
+	contribution.Status = "refined_and_encrypted"
+	contribution.TxHash = txHash
+	contribution.QualityScore = int(qualityScore)
+	contribution.IPFSHash = refinedData.IPFSHash
+	contribution.RequestID = requestIDFromContext(ctx)
+	for _, variant := range append([]*RefinedData{refinedData}, refinedData.Variants...) {
+		contribution.Variants = append(contribution.Variants, ContributionVariant{
+			Variant:  variant.Variant,
+			DataHash: common.Hash(variant.Hash).Hex(),
+			IPFSHash: variant.IPFSHash,
+		})
+	}
 
-	contribution := UserContribution{
-		Address:      req.Address,
-		DataType:     req.DataType,
-		FileName:     req.FileName,
-		FileSize:     req.FileSize,
-		DataContent:  refinedData.Schema,
-		RewardAmount: float64(qualityScore) * 100,
-		Timestamp:    time.Now(),
-		Status:       "refined_and_encrypted",
-		TxHash:       txHash,
-		QualityScore: int(qualityScore),
-		IPFSHash:     refinedData.IPFSHash,
-	}
-
-	result, err := collection.InsertOne(context.Background(), contribution)
+	result, err := db.Collection("user_contributions").InsertOne(ctx, contribution)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload data"})
-		return
+		return contribution, fmt.Errorf("failed to store contribution: %v", err)
 	}
 
 	contribution.ID = result.InsertedID.(primitive.ObjectID)
 
-	go func() {
-		time.Sleep(5 * time.Second)
-		if err := validateContribution(dataHash, qualityScore); err != nil {
-			log.Printf("Validation failed: %v", err)
+	enqueueJob(ctx, "validate-contribution", func(ctx context.Context) error {
+		if err := sleepContext(ctx, 5*time.Second); err != nil {
+			return err
 		}
-	}()
-
-	c.JSON(http.StatusCreated, gin.H{"message": "Data uploaded successfully", "data": contribution, "txHash": txHash})
+		// The reward is only credited once the validation is mined
+		return validateContribution(ctx, dataHash, qualityScore, func(ctx context.Context) {
+			notices.Publish(contribution.Address, Notice{Type: NOTICE_REWARD_CREDITED, Data: RewardCreditedNotice{
+				ContributionID: contribution.ID.Hex(),
+				RewardAmount:   contribution.RewardAmount,
+				QualityScore:   contribution.QualityScore,
+			}})
+		})
+	})
+
+	return contribution, nil
 }
 
 // Get all contributions for a user
@@ -114,25 +176,28 @@ func getUserContributions(c *gin.Context) {
 
 	authAddress, _ := c.Get("address")
 	if authAddress != address {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Address mismatch"})
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
 		return
 	}
 
+	// Event epochs can reference tens of thousands of events; list them
+	// by count only
 	collection := db.Collection("user_contributions")
-	cursor, err := collection.Find(context.Background(), bson.M{"address": address})
+	cursor, err := collection.Find(c.Request.Context(), bson.M{"address": address},
+		options.Find().SetProjection(bson.M{"sourceEventIds": 0}))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch contributions"})
+		respondError(c, ERR_INTERNAL, "Failed to fetch contributions")
 		return
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(c.Request.Context())
 
 	var contributions []UserContribution
-	if err = cursor.All(context.Background(), &contributions); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode contributions"})
+	if err = cursor.All(c.Request.Context(), &contributions); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to decode contributions")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": contributions})
+	c.JSON(http.StatusOK, ContributionsResponse{Data: contributions})
 }
 
 // Get total rewards for a user
@@ -141,7 +206,7 @@ func getUserRewards(c *gin.Context) {
 
 	authAddress, _ := c.Get("address")
 	if authAddress != address {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Address mismatch"})
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
 		return
 	}
 
@@ -155,21 +220,21 @@ func getUserRewards(c *gin.Context) {
 		}},
 	}
 
-	cursor, err := collection.Aggregate(context.Background(), pipeline)
+	cursor, err := collection.Aggregate(c.Request.Context(), pipeline)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate rewards"})
+		respondError(c, ERR_INTERNAL, "Failed to calculate rewards")
 		return
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(c.Request.Context())
 
 	var results []bson.M
-	if err = cursor.All(context.Background(), &results); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode rewards"})
+	if err = cursor.All(c.Request.Context(), &results); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to decode rewards")
 		return
 	}
 
 	if len(results) == 0 {
-		c.JSON(http.StatusOK, gin.H{"data": UserRewards{
+		c.JSON(http.StatusOK, RewardsResponse{Data: UserRewards{
 			Address:       address,
 			TotalRewards:  0,
 			TotalDatasets: 0,
@@ -184,7 +249,72 @@ func getUserRewards(c *gin.Context) {
 		TotalDatasets: int(result["totalDatasets"].(int32)),
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": rewards})
+	c.JSON(http.StatusOK, RewardsResponse{Data: rewards})
+}
+
+// Register the authenticated wallet address
+func registerWallet(c *gin.Context) {
+	var req RegisterWalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	authAddress, _ := c.Get("address")
+	if authAddress != req.Address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	ctx := c.Request.Context()
+	collection := db.Collection(REGISTERED_WALLETS_COLLECTION)
+
+	// Upsert so concurrent registrations of one address create one wallet;
+	// a racing upsert that loses on the unique index finds the winner below
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"address": req.Address},
+		bson.M{"$setOnInsert": bson.M{"timestamp": time.Now()}},
+		options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		respondError(c, ERR_INTERNAL, "Failed to register wallet")
+		return
+	}
+
+	var wallet RegisteredWallet
+	if err := collection.FindOne(ctx, bson.M{"address": req.Address}).Decode(&wallet); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to look up wallet")
+		return
+	}
+
+	status := http.StatusOK
+	if result != nil && result.UpsertedCount > 0 {
+		status = http.StatusCreated
+	}
+	c.JSON(status, WalletResponse{Data: wallet})
+}
+
+// Get the registered wallet for a user
+func getUser(c *gin.Context) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	var wallet RegisteredWallet
+	err := db.Collection(REGISTERED_WALLETS_COLLECTION).FindOne(c.Request.Context(), bson.M{"address": address}).Decode(&wallet)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, ERR_WALLET_NOT_REGISTERED, "Wallet not registered")
+		} else {
+			respondError(c, ERR_INTERNAL, "Failed to fetch user")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, WalletResponse{Data: wallet})
 }
 
 // Grant data access to a user
@@ -196,7 +326,7 @@ func grantDataAccessHandler(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
@@ -204,76 +334,68 @@ func grantDataAccessHandler(c *gin.Context) {
 	userAddr := common.HexToAddress(req.UserAddress)
 	duration := big.NewInt(req.Duration)
 
-	err := grantDataAccess([32]byte(datasetId), userAddr, duration)
+	err := grantDataAccess(c.Request.Context(), [32]byte(datasetId), userAddr, duration)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to grant access: %v", err)})
+		respondError(c, ERR_CHAIN_UNAVAILABLE, fmt.Sprintf("Failed to grant access: %v", err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Access granted successfully"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Access granted successfully"})
 }
 
 // Upload batched events from Chrome extension
 func uploadBatchedEvents(c *gin.Context) {
 	var req BatchEventUploadRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
 	authAddress, _ := c.Get("address")
 	if authAddress != req.Address {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Address mismatch"})
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
 		return
 	}
 
 	if len(req.Events) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No events provided"})
+		respondError(c, ERR_EMPTY_BATCH, "No events provided")
 		return
 	}
 
-	if len(req.Events) > 50 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many events in batch (max 50)"})
+	if len(req.Events) > MAX_EVENTS_PER_BATCH {
+		respondError(c, ERR_BATCH_TOO_LARGE, fmt.Sprintf("Too many events in batch (max %d)", MAX_EVENTS_PER_BATCH))
 		return
 	}
 
-	collection := db.Collection("user_events")
-
-	// Find existing user document or create new one
-	filter := bson.M{"address": authAddress}
-	update := bson.M{
-		"$push": bson.M{
-			"events": bson.M{
-				"$each": req.Events, // Append all events to the array
-			},
-		},
-		"$set": bson.M{
-			"updatedAt": time.Now(),
-		},
-		"$setOnInsert": bson.M{
-			"address":   authAddress,
-			"createdAt": time.Now(),
-		},
+	if !requireEventConsent(c, req.Address) {
+		return
 	}
 
-	// Use upsert to create document if it doesn't exist
-	opts := options.UpdateOptions{}
-	opts.SetUpsert(true)
-
-	_, err := collection.UpdateOne(context.Background(), filter, update, &opts)
+	result, err := ingestEventBatch(c.Request.Context(), req.Address, req.BatchID, req.Events)
 	if err != nil {
-		log.Printf("Failed to insert events for user %s: %v", authAddress, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload events"})
+		respondError(c, ERR_INTERNAL, "Failed to upload events")
 		return
 	}
 
-	log.Printf("Successfully updated user %s with %d new events", authAddress, len(req.Events))
+	if result.AcceptedCount == 0 && result.DuplicateCount == 0 {
+		respondError(c, ERR_INVALID_EVENTS, "No event in the batch passed validation", result.Rejected)
+		return
+	}
 
 	response := BatchEventUploadResponse{
-		Message:     "Events uploaded successfully",
-		EventsCount: len(req.Events),
-		UpdatedUser: authAddress,
+		Message:        "Events uploaded successfully",
+		BatchID:        req.BatchID,
+		AcceptedCount:  result.AcceptedCount,
+		DuplicateCount: result.DuplicateCount,
+		RejectedCount:  len(result.Rejected),
+		Rejected:       result.Rejected,
+		UpdatedUser:    authAddress,
 	}
 
-	c.JSON(http.StatusCreated, response)
+	// A fully replayed batch created nothing new
+	status := http.StatusCreated
+	if result.AcceptedCount == 0 {
+		status = http.StatusOK
+	}
+	c.JSON(status, response)
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	READINESS_CHECK_TIMEOUT = 3 * time.Second
+	MAX_CHAIN_HEAD_LAG      = 2 * time.Minute
+)
+
+// Set once shutdown starts so load balancers stop routing new traffic
+var shuttingDown atomic.Bool
+
+// Liveness probe: the process is up and serving HTTP
+func livez(c *gin.Context) {
+	c.JSON(http.StatusOK, ProbeResponse{Status: "healthy"})
+}
+
+// Readiness probe: dependencies required to serve traffic are reachable
+func readyz(c *gin.Context) {
+	if shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, ReadinessResponse{Status: "shutting_down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), READINESS_CHECK_TIMEOUT)
+	defer cancel()
+
+	rpcErr, lagErr := checkChainReady(ctx)
+	checks := map[string]ReadinessCheck{
+		"mongodb":   runReadinessCheck(checkMongoReady(ctx)),
+		"rpc":       runReadinessCheck(rpcErr),
+		"chainHead": runReadinessCheck(lagErr),
+		"signer":    runReadinessCheck(checkSignerReady()),
+	}
+
+	status := http.StatusOK
+	overall := "ready"
+	for _, check := range checks {
+		if check.Status != "ok" {
+			status = http.StatusServiceUnavailable
+			overall = "not_ready"
+			break
+		}
+	}
+
+	c.JSON(status, ReadinessResponse{Status: overall, Checks: checks})
+}
+
+func runReadinessCheck(err error) ReadinessCheck {
+	if err != nil {
+		return ReadinessCheck{Status: "failing", Error: err.Error()}
+	}
+	return ReadinessCheck{Status: "ok"}
+}
+
+func checkMongoReady(ctx context.Context) error {
+	if db == nil {
+		return fmt.Errorf("not connected")
+	}
+	return db.Client().Ping(ctx, nil)
+}
+
+// Check RPC reachability and how far the latest block lags behind wall clock
+func checkChainReady(ctx context.Context) (rpcErr error, lagErr error) {
+	if ethClient == nil {
+		err := fmt.Errorf("RPC client not initialized")
+		return err, err
+	}
+
+	header, err := ethClient.HeaderByNumber(ctx, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to fetch chain head: %v", err)
+		return err, err
+	}
+
+	maxLag := getEnvDuration("MAX_CHAIN_HEAD_LAG", MAX_CHAIN_HEAD_LAG)
+	lag := time.Since(time.Unix(int64(header.Time), 0))
+	if lag > maxLag {
+		return nil, fmt.Errorf("chain head %s is %s behind (max %s)", header.Number, lag.Round(time.Second), maxLag)
+	}
+
+	return nil, nil
+}
+
+func checkSignerReady() error {
+	if backendPrivateKey == nil || backendAuth == nil {
+		return fmt.Errorf("BACKEND_PRIVATE_KEY not configured")
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	STREAM_MAX_CONCURRENT     = 4
+	STREAM_WRITE_BATCH        = 500
+	STREAM_MAX_LINE_BYTES     = 64 * 1024
+	STREAM_MAX_BODY_BYTES     = 64 << 20
+	STREAM_MAX_DECODED_BYTES  = 512 << 20
+	STREAM_MAX_LINES          = 200000
+	STREAM_MAX_LINE_RESULTS   = 1000
+	STREAM_ZSTD_WINDOW_BYTES  = 8 << 20
+	STREAM_SLOT_WAIT_DURATION = 2 * time.Second
+)
+
+// Limits concurrent streaming uploads; a full channel means the server is
+// already writing as many streams as it is willing to
+var streamIngestSlots chan struct{}
+
+// Initialize the streaming ingestion concurrency limit
+func initStreamIngestion() {
+	streamIngestSlots = make(chan struct{}, getEnvInt("STREAM_MAX_CONCURRENT", STREAM_MAX_CONCURRENT))
+}
+
+// Ingest a (optionally gzip or zstd compressed) NDJSON stream of events.
+// Lines are validated and written in batches as they are read, so memory
+// stays bounded by STREAM_WRITE_BATCH regardless of the body size and slow
+// database writes stop the body from being read, pushing back on the client.
+func streamEvents(c *gin.Context) {
+	address := c.Query("address")
+	batchID := c.Query("batchId")
+	if address == "" || batchID == "" || len(batchID) > MAX_CLIENT_ID_LENGTH {
+		respondError(c, ERR_INVALID_REQUEST, "address and batchId query parameters are required")
+		return
+	}
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	if !requireEventConsent(c, address) {
+		return
+	}
+
+	if !acquireStreamSlot(c.Request.Context()) {
+		c.Header("Retry-After", "5")
+		respondError(c, ERR_INGESTION_BUSY, "Too many concurrent streaming uploads")
+		return
+	}
+	defer func() { <-streamIngestSlots }()
+
+	body, err := decompressedBody(c)
+	if err != nil {
+		respondError(c, ERR_UNSUPPORTED_ENCODING, err.Error())
+		return
+	}
+	defer body.Close()
+
+	ingester := &streamIngester{
+		ctx:     c.Request.Context(),
+		address: address,
+		summary: StreamIngestSummary{BatchID: batchID},
+		batchID: batchID,
+	}
+
+	// Lines read before malformed input are still stored, but a failed
+	// write is not retried
+	streamErr := ingester.consume(body)
+	var storeErr *streamStoreError
+	if !errors.As(streamErr, &storeErr) {
+		if err := ingester.flush(); err != nil && streamErr == nil {
+			streamErr = err
+		}
+	}
+
+	summary := ingester.summary
+	loggerFromContext(c.Request.Context()).Info("Ingested event stream",
+		"address", address,
+		"batchId", batchID,
+		"lines", summary.Lines,
+		"accepted", summary.AcceptedCount,
+		"duplicates", summary.DuplicateCount,
+		"rejected", summary.RejectedCount,
+		"error", streamErr,
+	)
+
+	// Lines before the failure are already stored; the summary tells the
+	// client where to resume
+	if errors.As(streamErr, &storeErr) {
+		respondError(c, ERR_INTERNAL, fmt.Sprintf("Failed to store events starting at line %d", storeErr.line), summary)
+		return
+	}
+	if streamErr != nil {
+		respondError(c, ERR_STREAM_ABORTED, streamErr.Error(), summary)
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// Wait briefly for a free ingestion slot
+func acquireStreamSlot(ctx context.Context) bool {
+	timer := time.NewTimer(STREAM_SLOT_WAIT_DURATION)
+	defer timer.Stop()
+
+	select {
+	case streamIngestSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Wrap the request body in a decoder matching its Content-Encoding
+func decompressedBody(c *gin.Context) (io.ReadCloser, error) {
+	raw := http.MaxBytesReader(c.Writer, c.Request.Body, STREAM_MAX_BODY_BYTES)
+
+	switch strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding"))) {
+	case "", "identity":
+		return raw, nil
+	case "gzip":
+		reader, err := gzip.NewReader(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip stream: %v", err)
+		}
+		return reader, nil
+	case "zstd":
+		decoder, err := zstd.NewReader(raw,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(STREAM_ZSTD_WINDOW_BYTES),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd stream: %v", err)
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q (use gzip, zstd or identity)", c.GetHeader("Content-Encoding"))
+	}
+}
+
+type streamIngester struct {
+	ctx     context.Context
+	address string
+	batchID string
+
+	pending      []interface{}
+	pendingLines []int
+	pendingIDs   []string
+	summary      StreamIngestSummary
+}
+
+// Read NDJSON lines from r until EOF, an I/O error or a limit is exceeded
+func (s *streamIngester) consume(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), STREAM_MAX_LINE_BYTES)
+
+	var decoded int64
+	line := 0
+	for scanner.Scan() {
+		line++
+		s.summary.Lines = line
+		decoded += int64(len(scanner.Bytes())) + 1
+		if decoded > STREAM_MAX_DECODED_BYTES {
+			return fmt.Errorf("decompressed body exceeds %d bytes", STREAM_MAX_DECODED_BYTES)
+		}
+		if line > STREAM_MAX_LINES {
+			return fmt.Errorf("stream exceeds %d lines", STREAM_MAX_LINES)
+		}
+
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+
+		event, errs := normalizeEvent(json.RawMessage(raw), s.address, s.batchID, time.Now())
+		if len(errs) > 0 {
+			s.summary.RejectedCount++
+			eventsRejected.Inc()
+			s.addResult(StreamLineResult{Line: line, Status: "rejected", Errors: errs})
+			continue
+		}
+
+		s.pending = append(s.pending, event)
+		s.pendingLines = append(s.pendingLines, line)
+		s.pendingIDs = append(s.pendingIDs, event.EventID)
+		if len(s.pending) >= STREAM_WRITE_BATCH {
+			if err := s.flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, bufio.ErrTooLong):
+			return fmt.Errorf("line %d exceeds %d bytes", line+1, STREAM_MAX_LINE_BYTES)
+		case errors.As(err, &maxBytesErr):
+			return fmt.Errorf("request body exceeds %d bytes", STREAM_MAX_BODY_BYTES)
+		default:
+			return fmt.Errorf("failed to read stream after line %d: %v", line, err)
+		}
+	}
+	return nil
+}
+
+// Failure to write buffered events, as opposed to a problem with the stream
+type streamStoreError struct {
+	line int
+	err  error
+}
+
+func (e *streamStoreError) Error() string {
+	return fmt.Sprintf("failed to store events starting at line %d: %v", e.line, e.err)
+}
+
+// Write buffered events and record which lines were duplicates
+func (s *streamIngester) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	duplicates, err := insertUserEvents(s.ctx, s.pending)
+	if err != nil {
+		return &streamStoreError{line: s.pendingLines[0], err: err}
+	}
+
+	var accepted []interface{}
+	for i, event := range s.pending {
+		if duplicates[i] {
+			s.summary.DuplicateCount++
+			s.addResult(StreamLineResult{Line: s.pendingLines[i], Status: "duplicate", EventID: s.pendingIDs[i]})
+			continue
+		}
+		accepted = append(accepted, event)
+	}
+	s.summary.AcceptedCount += len(accepted)
+	recordIngestedEvents(accepted, len(duplicates), 0)
+
+	s.pending = s.pending[:0]
+	s.pendingLines = s.pendingLines[:0]
+	s.pendingIDs = s.pendingIDs[:0]
+	return nil
+}
+
+// Keep per-line results for non-accepted lines, up to a fixed cap
+func (s *streamIngester) addResult(result StreamLineResult) {
+	if len(s.summary.Results) >= STREAM_MAX_LINE_RESULTS {
+		s.summary.ResultsTruncated = true
+		return
+	}
+	s.summary.Results = append(s.summary.Results, result)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	JOB_QUEUE_WORKERS  = 4
+	JOB_QUEUE_CAPACITY = 256
+)
+
+var errJobQueueClosed = errors.New("job queue is closed")
+
+var (
+	periodicJobsStop     = make(chan struct{})
+	periodicJobsStopOnce sync.Once
+)
+
+// Background job executed by the job queue workers. RequestID links the job
+// back to the HTTP request that scheduled it.
+type Job struct {
+	Name      string
+	RequestID string
+	Run       func(ctx context.Context) error
+}
+
+// Bounded queue of background jobs that can be drained on shutdown
+type JobQueue struct {
+	jobs   chan Job
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+var jobQueue *JobQueue
+
+// Initialize the background job queue and start its workers
+func initJobQueue() {
+	workers := getEnvInt("JOB_QUEUE_WORKERS", JOB_QUEUE_WORKERS)
+	capacity := getEnvInt("JOB_QUEUE_CAPACITY", JOB_QUEUE_CAPACITY)
+
+	jobQueue = newJobQueue(workers, capacity)
+
+	slog.Info("Job queue started", "workers", workers, "capacity", capacity)
+}
+
+func newJobQueue(workers, capacity int) *JobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &JobQueue{
+		jobs:   make(chan Job, capacity),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+
+	return q
+}
+
+func (q *JobQueue) worker() {
+	defer q.wg.Done()
+
+	for job := range q.jobs {
+		q.run(job)
+	}
+}
+
+func (q *JobQueue) run(job Job) {
+	ctx := contextWithRequestID(q.ctx, job.RequestID)
+	logger := loggerFromContext(ctx).With("job", job.Name)
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Job panicked", "panic", r)
+		}
+	}()
+
+	if err := job.Run(ctx); err != nil {
+		logger.Error("Job failed", "error", err)
+	}
+}
+
+// Enqueue a job without blocking the caller
+func (q *JobQueue) Enqueue(job Job) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return errJobQueueClosed
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return fmt.Errorf("job queue is full (%d jobs pending)", len(q.jobs))
+	}
+}
+
+// Number of jobs waiting for a worker
+func (q *JobQueue) Depth() int {
+	return len(q.jobs)
+}
+
+// Stop accepting jobs and wait for queued and running jobs to finish.
+// Jobs still running when ctx expires are cancelled through their context.
+func (q *JobQueue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return fmt.Errorf("job queue drain interrupted: %v", ctx.Err())
+	}
+}
+
+// Enqueue a named job on the global job queue, inheriting the request ID from ctx
+func enqueueJob(ctx context.Context, name string, run func(ctx context.Context) error) {
+	job := Job{Name: name, RequestID: requestIDFromContext(ctx), Run: run}
+	if err := jobQueue.Enqueue(job); err != nil {
+		loggerFromContext(ctx).Error("Failed to enqueue job", "job", name, "error", err)
+	}
+}
+
+// Enqueue run on the job queue every interval until stopPeriodicJobs is
+// called. A tick is skipped while the previous run is still queued or
+// running. A non-positive interval disables the job.
+func schedulePeriodicJob(name string, interval time.Duration, run func(ctx context.Context) error) {
+	if interval <= 0 {
+		slog.Info("Periodic job disabled", "job", name)
+		return
+	}
+
+	var running atomic.Bool
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-periodicJobsStop:
+				return
+			case <-ticker.C:
+				if !running.CompareAndSwap(false, true) {
+					continue
+				}
+				job := Job{Name: name, Run: func(ctx context.Context) error {
+					defer running.Store(false)
+					return run(ctx)
+				}}
+				if err := jobQueue.Enqueue(job); err != nil {
+					running.Store(false)
+					slog.Error("Failed to enqueue periodic job", "job", name, "error", err)
+				}
+			}
+		}
+	}()
+
+	slog.Info("Periodic job scheduled", "job", name, "interval", interval)
+}
+
+// Stop scheduling periodic jobs so they are not enqueued while draining
+func stopPeriodicJobs() {
+	periodicJobsStopOnce.Do(func() {
+		close(periodicJobsStop)
+	})
+}
+
+// Sleep for d unless ctx is cancelled first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	KEY_RELEASES_COLLECTION = "key_releases"
+	// How long a signed key request stays valid, and the clock skew allowed
+	KEY_RELEASE_SIGNATURE_TTL  = 5 * time.Minute
+	KEY_RELEASE_CLOCK_SKEW     = time.Minute
+	KEY_RELEASE_ALGORITHM      = "ecies-secp256k1-aes128ctr-hmacsha256"
+	KEY_RELEASE_MESSAGE_HEADER = "TubeDAO dataset key request"
+)
+
+// Outcomes recorded for key requests
+const (
+	KEY_RELEASE_RELEASED = "released"
+	KEY_RELEASE_DENIED   = "denied"
+	KEY_RELEASE_NO_KEY   = "no_key"
+	KEY_RELEASE_REVOKED  = "revoked"
+	KEY_RELEASE_FAILED   = "failed"
+)
+
+// Index the key release audit log by dataset and by buyer
+func initKeyReleases() {
+	ctx, cancel := context.WithTimeout(context.Background(), EVENT_INDEX_INIT_TIMEOUT)
+	defer cancel()
+
+	_, err := db.Collection(KEY_RELEASES_COLLECTION).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "datasetId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "buyer", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	if err != nil {
+		slog.Error("Failed to create key release indexes", "error", err)
+	}
+}
+
+// Text a buyer signs with personal_sign to request a dataset key. The
+// encryption key, if any, is signed as sent so it cannot be swapped.
+func keyReleaseMessage(datasetID common.Hash, buyer common.Address, issuedAt, encryptionKey string) string {
+	message := fmt.Sprintf("%s\nDataset: %s\nBuyer: %s\nIssued At: %s",
+		KEY_RELEASE_MESSAGE_HEADER, datasetID.Hex(), buyer.Hex(), issuedAt)
+	if encryptionKey != "" {
+		message += "\nEncryption Key: " + encryptionKey
+	}
+	return message
+}
+
+// Recover the secp256k1 public key behind an EIP-191 signature of message
+func recoverSignerKey(message, signature string) (*ecies.PublicKey, common.Address, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return nil, common.Address{}, fmt.Errorf("signature must be 65 hex-encoded bytes")
+	}
+	// Wallets sign with v = 27/28, crypto expects 0/1
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+
+	publicKey, err := crypto.SigToPub(accounts.TextHash([]byte(message)), sig)
+	if err != nil {
+		return nil, common.Address{}, fmt.Errorf("failed to recover public key: %v", err)
+	}
+	return ecies.ImportECDSAPublic(publicKey), crypto.PubkeyToAddress(*publicKey), nil
+}
+
+// Release a dataset key to a buyer holding QueryEngine access. The buyer
+// signs a key request with their wallet, and the key is encrypted with
+// ECIES to the encryption key named in the request. Browser wallets cannot
+// decrypt ECIES with their signing key, so buyers using one derive a
+// keypair from a signature, as contributors do; without an encryption key
+// the key is encrypted to the public key recovered from the signature.
+// Every attempt past signature checks is logged in key_releases.
+func releaseDatasetKey(c *gin.Context) {
+	var req KeyReleaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	rawID, err := hex.DecodeString(strings.TrimPrefix(c.Param("datasetId"), "0x"))
+	if err != nil || len(rawID) != common.HashLength {
+		respondError(c, ERR_INVALID_REQUEST, "datasetId must be a 32-byte hex hash")
+		return
+	}
+	datasetID := common.BytesToHash(rawID)
+
+	authAddress, _ := c.Get("address")
+	buyer := common.HexToAddress(authAddress.(string))
+
+	issuedAt, err := time.Parse(time.RFC3339, req.IssuedAt)
+	if err != nil {
+		respondError(c, ERR_INVALID_REQUEST, "issuedAt must be an RFC 3339 timestamp")
+		return
+	}
+	if age := time.Since(issuedAt); age > KEY_RELEASE_SIGNATURE_TTL || age < -KEY_RELEASE_CLOCK_SKEW {
+		respondError(c, ERR_INVALID_SIGNATURE, fmt.Sprintf("Key requests must be signed within %s", KEY_RELEASE_SIGNATURE_TTL))
+		return
+	}
+
+	var encryptionKey *ecdsa.PublicKey
+	if req.EncryptionKey != "" {
+		if encryptionKey, err = parsePublicKey("encryptionKey", req.EncryptionKey); err != nil {
+			respondError(c, ERR_INVALID_REQUEST, err.Error())
+			return
+		}
+	}
+
+	publicKey, signer, err := recoverSignerKey(keyReleaseMessage(datasetID, buyer, req.IssuedAt, req.EncryptionKey), req.Signature)
+	if err != nil {
+		respondError(c, ERR_INVALID_SIGNATURE, err.Error())
+		return
+	}
+	if signer != buyer {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Key request was not signed by the authenticated wallet")
+		return
+	}
+	if encryptionKey != nil {
+		publicKey = ecies.ImportECDSAPublic(encryptionKey)
+	}
+
+	ctx := c.Request.Context()
+	logger := loggerFromContext(ctx)
+	release := KeyRelease{
+		ID:          uuid.New().String(),
+		DatasetID:   datasetID.Hex(),
+		Buyer:       buyer.Hex(),
+		EncryptedTo: crypto.PubkeyToAddress(*publicKey.ExportECDSA()).Hex(),
+		IssuedAt:    issuedAt,
+		RequestID:   c.GetString("requestId"),
+		ClientIP:    c.ClientIP(),
+	}
+
+	allowed, err := checkDataAccess(datasetID, buyer)
+	if err != nil {
+		recordKeyRelease(ctx, release, KEY_RELEASE_FAILED, err.Error())
+		respondError(c, ERR_CHAIN_UNAVAILABLE, fmt.Sprintf("Failed to check dataset access: %v", err))
+		return
+	}
+	if !allowed {
+		recordKeyRelease(ctx, release, KEY_RELEASE_DENIED, "no QueryEngine access")
+		respondError(c, ERR_DATASET_ACCESS_DENIED, "Purchase access to the dataset on QueryEngine first")
+		return
+	}
+
+	key, err := loadDataKey(ctx, datasetID)
+	if err == mongo.ErrNoDocuments {
+		recordKeyRelease(ctx, release, KEY_RELEASE_NO_KEY, "dataset has no stored key")
+		respondError(c, ERR_DATASET_KEY_NOT_FOUND, "No key is stored for the dataset")
+		return
+	}
+	if err == errDataKeyRevoked {
+		recordKeyRelease(ctx, release, KEY_RELEASE_REVOKED, "contributor revoked the dataset key")
+		respondError(c, ERR_DATASET_KEY_REVOKED, "The contributor revoked the dataset")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to load data key", "datasetId", release.DatasetID, "error", err)
+		recordKeyRelease(ctx, release, KEY_RELEASE_FAILED, err.Error())
+		respondError(c, ERR_INTERNAL, "Failed to load the dataset key")
+		return
+	}
+
+	encrypted, err := ecies.Encrypt(rand.Reader, publicKey, key, nil, nil)
+	if err != nil {
+		logger.Error("Failed to encrypt data key", "datasetId", release.DatasetID, "error", err)
+		recordKeyRelease(ctx, release, KEY_RELEASE_FAILED, err.Error())
+		respondError(c, ERR_INTERNAL, "Failed to encrypt the dataset key")
+		return
+	}
+
+	// Refuse to hand out a key that could not be audited
+	if err := recordKeyRelease(ctx, release, KEY_RELEASE_RELEASED, ""); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to record the key release")
+		return
+	}
+	logger.Info("Released dataset key", "datasetId", release.DatasetID, "buyer", release.Buyer, "releaseId", release.ID)
+
+	c.JSON(http.StatusOK, KeyReleaseResponse{
+		ReleaseID:    release.ID,
+		DatasetID:    release.DatasetID,
+		Buyer:        release.Buyer,
+		EncryptedTo:  release.EncryptedTo,
+		Algorithm:    KEY_RELEASE_ALGORITHM,
+		EncryptedKey: "0x" + hex.EncodeToString(encrypted),
+	})
+}
+
+// Append a key request to the audit log
+func recordKeyRelease(ctx context.Context, release KeyRelease, outcome, reason string) error {
+	release.Outcome = outcome
+	release.Reason = reason
+	release.CreatedAt = time.Now()
+	keyReleases.WithLabelValues(outcome).Inc()
+
+	if _, err := db.Collection(KEY_RELEASES_COLLECTION).InsertOne(ctx, release); err != nil {
+		loggerFromContext(ctx).Error("Failed to record key release", "datasetId", release.DatasetID, "outcome", outcome, "error", err)
+		return err
+	}
+	return nil
+}
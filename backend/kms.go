@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Master keys held by a key management service. Data keys never leave the
+// backend unwrapped; only their wrapped form is stored. associatedData binds
+// a wrapped key to its dataset so it cannot be swapped onto another.
+type KeyManager interface {
+	// Master key new data keys are wrapped with
+	CurrentKeyID(ctx context.Context) (string, error)
+	Wrap(ctx context.Context, plaintext, associatedData []byte) (keyID string, wrapped []byte, err error)
+	Unwrap(ctx context.Context, keyID string, wrapped, associatedData []byte) ([]byte, error)
+	// Create a new master key and make it current. Older keys stay usable
+	// for unwrapping until every data key is rewrapped.
+	Rotate(ctx context.Context) (keyID string, err error)
+}
+
+// Losing the keyring loses every dataset, so it must not default to a
+// temporary directory
+const KMS_LOCAL_KEYRING = "/var/lib/tubedao/kms/keyring.json"
+
+var keyManager KeyManager
+
+// Select the key management service. Only the local file keyring is built
+// in; a managed KMS can be plugged in by implementing KeyManager.
+func initKeyManagement() error {
+	switch provider := getEnvOrDefault("KMS_PROVIDER", "local"); provider {
+	case "local":
+		path := getEnvOrDefault("KMS_LOCAL_KEYRING", KMS_LOCAL_KEYRING)
+		manager := &localKeyManager{path: path}
+		if err := manager.ensure(); err != nil {
+			return err
+		}
+		slog.Warn("Using the local KMS; master keys are stored unencrypted", "keyring", path)
+		keyManager = manager
+		return nil
+	default:
+		return fmt.Errorf("unknown KMS_PROVIDER %q", provider)
+	}
+}
+
+// Stand-in KMS keeping AES-256 master keys in a JSON keyring file. The file
+// is re-read when it changes, so rotations by the rotate-master-key command
+// reach running servers.
+type localKeyManager struct {
+	path string
+
+	mu      sync.Mutex
+	keyring localKeyring
+	file    os.FileInfo
+}
+
+type localKeyring struct {
+	Current string           `json:"current"`
+	Keys    []localMasterKey `json:"keys"`
+}
+
+type localMasterKey struct {
+	ID        string    `json:"id"`
+	Key       []byte    `json:"key"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Create the keyring with a first master key unless it exists. The keyring
+// directory must be writable, since rotations replace the file.
+func (m *localKeyManager) ensure() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := ensureWritableDir(filepath.Dir(m.path)); err != nil {
+		return fmt.Errorf("keyring directory: %v", err)
+	}
+
+	if err := m.reload(); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	key, err := newLocalMasterKey()
+	if err != nil {
+		return err
+	}
+	return m.save(localKeyring{Current: key.ID, Keys: []localMasterKey{key}})
+}
+
+// Re-read the keyring if the file changed. Saves replace the file, so a
+// new inode means a new keyring even within one mtime tick. Called with mu
+// held.
+func (m *localKeyManager) reload() error {
+	info, err := os.Stat(m.path)
+	if err != nil {
+		return err
+	}
+	if m.file != nil && os.SameFile(m.file, info) && info.ModTime().Equal(m.file.ModTime()) {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return fmt.Errorf("failed to read keyring: %v", err)
+	}
+	var keyring localKeyring
+	if err := json.Unmarshal(data, &keyring); err != nil {
+		return fmt.Errorf("failed to parse keyring: %v", err)
+	}
+	if _, ok := keyring.key(keyring.Current); !ok {
+		return fmt.Errorf("keyring has no current key %q", keyring.Current)
+	}
+
+	m.keyring = keyring
+	m.file = info
+	return nil
+}
+
+// Replace the keyring file atomically. Called with mu held.
+func (m *localKeyManager) save(keyring localKeyring) error {
+	data, err := json.MarshalIndent(keyring, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize keyring: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".keyring-*")
+	if err != nil {
+		return fmt.Errorf("failed to write keyring: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keyring: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keyring: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write keyring: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		return fmt.Errorf("failed to replace keyring: %v", err)
+	}
+
+	m.file = nil
+	return m.reload()
+}
+
+func (k localKeyring) key(id string) (localMasterKey, bool) {
+	for _, key := range k.Keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return localMasterKey{}, false
+}
+
+func newLocalMasterKey() (localMasterKey, error) {
+	key := make([]byte, 32)
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(key); err != nil {
+		return localMasterKey{}, fmt.Errorf("failed to generate master key: %v", err)
+	}
+	if _, err := rand.Read(suffix); err != nil {
+		return localMasterKey{}, fmt.Errorf("failed to generate master key: %v", err)
+	}
+	now := time.Now().UTC()
+	return localMasterKey{
+		ID:        "local-" + now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix),
+		Key:       key,
+		CreatedAt: now,
+	}, nil
+}
+
+func (m *localKeyManager) CurrentKeyID(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.reload(); err != nil {
+		return "", err
+	}
+	return m.keyring.Current, nil
+}
+
+func (m *localKeyManager) Wrap(ctx context.Context, plaintext, associatedData []byte) (string, []byte, error) {
+	m.mu.Lock()
+	if err := m.reload(); err != nil {
+		m.mu.Unlock()
+		return "", nil, err
+	}
+	master, _ := m.keyring.key(m.keyring.Current)
+	m.mu.Unlock()
+
+	gcm, err := newAESGCM(master.Key)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return master.ID, gcm.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func (m *localKeyManager) Unwrap(ctx context.Context, keyID string, wrapped, associatedData []byte) ([]byte, error) {
+	m.mu.Lock()
+	if err := m.reload(); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	master, ok := m.keyring.key(keyID)
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", keyID)
+	}
+
+	gcm, err := newAESGCM(master.Key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is truncated")
+	}
+	nonce, sealed := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, associatedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	return plaintext, nil
+}
+
+func (m *localKeyManager) Rotate(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.reload(); err != nil {
+		return "", err
+	}
+
+	key, err := newLocalMasterKey()
+	if err != nil {
+		return "", err
+	}
+	keyring := localKeyring{Current: key.ID, Keys: append(append([]localMasterKey(nil), m.keyring.Keys...), key)}
+	if err := m.save(keyring); err != nil {
+		return "", err
+	}
+	return key.ID, nil
+}
+
+// AES-256-GCM, used both to wrap data keys and to encrypt datasets
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %v", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	REQUEST_ID_HEADER     = "X-Request-ID"
+	MAX_REQUEST_ID_LENGTH = 64
+	REDACTED              = "[REDACTED]"
+)
+
+type requestIDKey struct{}
+
+// Attribute keys whose values are never logged
+var sensitiveLogKeys = map[string]bool{
+	"authorization":    true,
+	"token":            true,
+	"temptoken":        true,
+	"signature":        true,
+	"siwesignature":    true,
+	"bindingsignature": true,
+	"privatekey":       true,
+	"accesskey":        true,
+	"jwtsecret":        true,
+}
+
+var (
+	jwtPattern        = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	signaturePattern  = regexp.MustCompile(`(?i)\b(0x)?[0-9a-f]{130}\b`)
+	privateKeyPattern = regexp.MustCompile(`(?i)(^|[^x0-9a-f])([0-9a-f]{64})\b`)
+	addressPattern    = regexp.MustCompile(`(?i)\b0x([0-9a-f]{4})[0-9a-f]{32}([0-9a-f]{4})\b`)
+	requestIDPattern  = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// Configure the default structured logger from LOG_LEVEL and LOG_FORMAT
+func initLogging() {
+	opts := &slog.HandlerOptions{
+		Level:       parseLogLevel(os.Getenv("LOG_LEVEL")),
+		ReplaceAttr: redactLogAttr,
+	}
+
+	var handler slog.Handler
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "text") {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
+
+func parseLogLevel(value string) slog.Level {
+	switch strings.ToLower(value) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Scrub secrets and shorten wallet addresses in every logged attribute,
+// including the message itself
+func redactLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveLogKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, REDACTED)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, redactString(err.Error()))
+		}
+		if s, ok := a.Value.Any().(interface{ String() string }); ok {
+			return slog.String(a.Key, redactString(s.String()))
+		}
+	}
+
+	return a
+}
+
+// Remove JWTs, signatures and private keys from s and truncate addresses
+func redactString(s string) string {
+	s = jwtPattern.ReplaceAllString(s, REDACTED)
+	s = signaturePattern.ReplaceAllString(s, REDACTED)
+	s = privateKeyPattern.ReplaceAllString(s, "${1}"+REDACTED)
+	s = addressPattern.ReplaceAllString(s, "0x${1}…${2}")
+	return s
+}
+
+// Logger carrying the request ID and trace ID stored in ctx, if any
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if ctx == nil {
+		return logger
+	}
+
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		logger = logger.With("requestId", requestID)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		logger = logger.With("traceId", spanContext.TraceID().String())
+	}
+	return logger
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+func contextWithRequestID(ctx context.Context, requestID string) context.Context {
+	if requestID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// Log at error level and exit, replacing log.Fatal
+func logFatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Middleware assigning a request ID, propagating it through the request
+// context and logging each request
+func requestLoggingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(REQUEST_ID_HEADER)
+		if len(requestID) == 0 || len(requestID) > MAX_REQUEST_ID_LENGTH || !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		c.Set("requestId", requestID)
+		c.Header(REQUEST_ID_HEADER, requestID)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request.id", requestID))
+		c.Request = c.Request.WithContext(contextWithRequestID(c.Request.Context(), requestID))
+
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+
+		loggerFromContext(c.Request.Context()).Log(c.Request.Context(), level, "HTTP request",
+			"method", c.Request.Method,
+			"route", route,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"clientIp", c.ClientIP(),
+		)
+	}
+}
@@ -2,48 +2,153 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+)
+
+const (
+	SHUTDOWN_TIMEOUT     = 30 * time.Second
+	READ_HEADER_TIMEOUT  = 10 * time.Second
+	READINESS_DRAIN_WAIT = 5 * time.Second
 )
 
 var db *mongo.Database
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using system environment variables")
+	envErr := godotenv.Load()
+
+	initLogging()
+	if envErr != nil {
+		slog.Info("No .env file found, using system environment variables")
 	}
 
-	log.Printf("Starting backend")
+	slog.Info("Starting backend")
+
+	if err := initTracing(); err != nil {
+		slog.Error("Tracing initialization failed", "error", err)
+	}
 
 	initMongoDB()
+
+	// One-off migration of legacy per-address event arrays
+	if len(os.Args) > 1 && os.Args[1] == "migrate-events" {
+		documents, events, err := migrateLegacyEventDocuments(context.Background())
+		if err != nil {
+			logFatal("Event migration failed", "documents", documents, "events", events, "error", err)
+		}
+		slog.Info("Event migration complete", "documents", documents, "events", events)
+		return
+	}
+
+	// Make a new master key current and rewrap every data key with it
+	if len(os.Args) > 1 && (os.Args[1] == "rotate-master-key" || os.Args[1] == "rewrap-data-keys") {
+		if err := initKeyManagement(); err != nil {
+			logFatal("Key management initialization failed", "error", err)
+		}
+		if os.Args[1] == "rotate-master-key" {
+			keyID, err := keyManager.Rotate(context.Background())
+			if err != nil {
+				logFatal("Master key rotation failed", "error", err)
+			}
+			slog.Info("Master key rotated", "masterKey", keyID)
+		}
+		rewrapped, err := rewrapDataKeys(context.Background())
+		if err != nil {
+			logFatal("Data key rewrap failed", "rewrapped", rewrapped, "error", err)
+		}
+		slog.Info("Data key rewrap complete", "rewrapped", rewrapped)
+		return
+	}
+
+	// Rebuild watch sessions from events ingested since the last run
+	if len(os.Args) > 1 && os.Args[1] == "sessionize-events" {
+		sessions, err := sessionizeEvents(context.Background())
+		if err != nil {
+			logFatal("Sessionization failed", "sessions", sessions, "error", err)
+		}
+		slog.Info("Sessionization complete", "sessions", sessions)
+		return
+	}
+
+	if err := initEventStore(); err != nil {
+		slog.Error("Event store initialization failed", "error", err)
+	}
+
 	initAuth(db)
+	initRegisteredWallets()
+	initPrivacyTransforms()
 
 	// Initialize blockchain integration
 	if err := initBlockchain(); err != nil {
-		log.Printf("Blockchain initialization failed: %v", err)
+		slog.Error("Blockchain initialization failed", "error", err)
 	}
 
 	// Initialize VRC-15 data access integration
 	if err := initVanaDataAccess(); err != nil {
-		log.Printf("Vana data access initialization failed: %v", err)
+		slog.Error("Vana data access initialization failed", "error", err)
+	}
+
+	initJobQueue()
+	initStreamIngestion()
+	initSessionizer()
+	initEventEpochs()
+	initUploads()
+	initAggregates()
+	initDataKeys()
+	initKeyReleases()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
 	}
 
-	r := gin.Default()
+	srv := &http.Server{
+		Addr:              ":" + port,
+		Handler:           newRouter(),
+		ReadHeaderTimeout: READ_HEADER_TIMEOUT,
+	}
+
+	go func() {
+		slog.Info("Server starting", "port", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logFatal("Server failed", "error", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+
+	slog.Info("Shutting down", "signal", sig.String())
+	shutdown(srv)
+}
+
+// Build the HTTP router with all middleware and routes
+func newRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(otelgin.Middleware(TRACING_SERVICE_NAME), requestLoggingMiddleware(), gin.CustomRecovery(recoveryHandler), metricsMiddleware())
+	r.NoRoute(notFoundHandler)
 
 	// Custom CORS middleware to handle all origins properly
 	r.Use(func(c *gin.Context) {
 		origin := c.Request.Header.Get("Origin")
 		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Request-ID, Upload-Offset, Upload-Checksum")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Location, Upload-Offset, Upload-Length, Upload-Expires")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, HEAD, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -68,45 +173,125 @@ func main() {
 		protected := api.Group("/events", jwtAuthMiddleware())
 		{
 			protected.POST("/upload", uploadBatchedEvents)
+			protected.POST("/stream", streamEvents)
 			protected.POST("/upload-data", uploadData)
+			protected.GET("/user/:address", getUserEvents)
+			protected.GET("/user/:address/analytics/watch-time", getWatchTimeAnalytics)
+			protected.GET("/user/:address/analytics/top-channels", getTopChannelsAnalytics)
+			protected.GET("/user/:address/analytics/ads", getAdImpressionsAnalytics)
+			protected.GET("/user/:address/analytics/engagement", getEngagementAnalytics)
 			protected.GET("/user/:address/contributions", getUserContributions)
 			protected.GET("/user/:address/rewards", getUserRewards)
 		}
+
+		// Real-time ingestion; the token may arrive as a query parameter or
+		// subprotocol since browsers cannot set WebSocket headers
+		api.GET("/events/ws", wsTokenMiddleware(), jwtAuthMiddleware(), serveEventSocket)
+
+		// Web app endpoints
+		web := api.Group("", jwtAuthMiddleware())
+		{
+			web.POST("/register-wallet", registerWallet)
+			web.POST("/upload-data", uploadData)
+			web.POST("/takeout/import", importTakeout)
+			web.POST("/uploads", createUpload)
+			web.HEAD("/uploads/:id", headUpload)
+			web.GET("/uploads/:id", getUpload)
+			web.PUT("/uploads/:id", putUploadChunk)
+			web.POST("/uploads/:id/finalize", finalizeUpload)
+			web.DELETE("/uploads/:id", deleteUpload)
+			web.GET("/user/:address", getUser)
+			web.GET("/user/:address/contributions", getUserContributions)
+			web.GET("/user/:address/rewards", getUserRewards)
+			web.GET("/user/:address/consent", getEventConsent)
+			web.PUT("/user/:address/consent", updateEventConsent)
+			web.GET("/user/:address/masking", getMaskingPreferences)
+			web.PUT("/user/:address/masking", updateMaskingPreferences)
+			web.GET("/user/:address/encryption-key", getContributorKey)
+			web.PUT("/user/:address/encryption-key", setContributorKey)
+			web.DELETE("/user/:address/encryption-key", deleteContributorKey)
+			web.GET("/user/:address/data-keys", getContributorDataKeys)
+			web.DELETE("/user/:address/data-keys/:datasetId", revokeContributorDataKey)
+			web.POST("/aggregates/query", queryAggregate)
+			web.POST("/data/:datasetId/key", releaseDatasetKey)
+		}
+
+		api.GET("/schemas", listDataSchemas)
+		api.GET("/aggregates", listAggregateDatasets)
+		api.GET("/openapi.json", serveOpenAPISpec)
 	}
 
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
-	})
+	// Probes
+	r.GET("/livez", livez)
+	r.GET("/readyz", readyz)
+	r.GET("/health", livez)
+	r.GET("/metrics", metricsHandler())
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	return r
+}
+
+// Drain in-flight requests and background jobs, then release connections
+func shutdown(srv *http.Server) {
+	shuttingDown.Store(true)
+
+	// Give load balancers a moment to observe the failing readiness probe
+	time.Sleep(getEnvDuration("READINESS_DRAIN_WAIT", READINESS_DRAIN_WAIT))
+
+	ctx, cancel := context.WithTimeout(context.Background(), getEnvDuration("SHUTDOWN_TIMEOUT", SHUTDOWN_TIMEOUT))
+	defer cancel()
+
+	// Hijacked WebSocket connections are not closed by srv.Shutdown
+	notices.CloseAll()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("HTTP server shutdown did not complete", "error", err)
+	} else {
+		slog.Info("HTTP server stopped")
 	}
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(r.Run(":" + port))
+	stopPeriodicJobs()
+	if err := jobQueue.Drain(ctx); err != nil {
+		slog.Error("Background jobs did not drain", "error", err)
+	} else {
+		slog.Info("Background jobs drained")
+	}
+	stopTransactionWatchers()
+
+	if ethClient != nil {
+		ethClient.Close()
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+
+	if err := db.Client().Disconnect(ctx); err != nil {
+		slog.Error("Failed to disconnect from MongoDB", "error", err)
+	} else {
+		slog.Info("Disconnected from MongoDB")
+	}
 }
 
 func initMongoDB() {
 	mongoURI := os.Getenv("MONGODB_URI")
 	if mongoURI == "" {
-		log.Fatal("MONGODB_URI environment variable is required")
+		logFatal("MONGODB_URI environment variable is required")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI).SetMonitor(otelmongo.NewMonitor()))
 	if err != nil {
-		log.Fatal("Failed to connect to MongoDB:", err)
+		logFatal("Failed to connect to MongoDB", "error", err)
 	}
 
 	err = client.Ping(ctx, nil)
 	if err != nil {
-		log.Fatal("Failed to ping MongoDB:", err)
+		logFatal("Failed to ping MongoDB", "error", err)
 	}
 
 	db = client.Database("tubedao")
 
-	log.Println("Connected to MongoDB")
+	slog.Info("Connected to MongoDB")
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const MASKING_PREFERENCES_COLLECTION = "masking_preferences"
+
+// Masked variants refined from every normalized schema
+const (
+	MASKING_VARIANT_BUYER_TIER_A   = "buyer_tier_a"
+	MASKING_VARIANT_BUYER_TIER_B   = "buyer_tier_b"
+	MASKING_VARIANT_PUBLIC_PREVIEW = "public_preview"
+)
+
+// A masked variant of refined datasets. Variants only ever add rules to the
+// contributor's own, so no variant reveals more than the contributor allowed.
+type MaskingVariant struct {
+	Name string
+	// Rules enabled on top of the contributor's; ignored where a data type
+	// does not have them
+	Rules []string
+	// Enable every rule of the data type
+	AllRules bool
+	// Readable by anyone without purchasing access
+	Public bool
+}
+
+// The first variant is the primary dataset submitted to the DataLiquidityPool
+var maskingVariants = []MaskingVariant{
+	{Name: MASKING_VARIANT_BUYER_TIER_A},
+	{Name: MASKING_VARIANT_BUYER_TIER_B, Rules: []string{"pseudonymizeIds", "searchTokens", "postIds", "tweetIds", "timestamps"}},
+	{Name: MASKING_VARIANT_PUBLIC_PREVIEW, AllRules: true, Public: true},
+}
+
+// Masking rules of the variant for dataSchema data, given the rules the
+// contributor chose
+func (v MaskingVariant) rulesFor(dataSchema *DataSchema, contributorRules map[string]bool) map[string]bool {
+	rules := make(map[string]bool, len(dataSchema.MaskingRules))
+	for _, rule := range dataSchema.MaskingRules {
+		rules[rule] = v.AllRules || contributorRules[rule] || slices.Contains(v.Rules, rule)
+	}
+	return rules
+}
+
+// Every masking rule understood by at least one registered schema
+func knownMaskingRules() []string {
+	seen := make(map[string]bool)
+	var rules []string
+	for _, dataSchema := range dataSchemas {
+		for _, rule := range dataSchema.MaskingRules {
+			if !seen[rule] {
+				seen[rule] = true
+				rules = append(rules, rule)
+			}
+		}
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+// Reject rule names missing from allowed
+func checkMaskingRuleNames(rules map[string]bool, allowed []string) error {
+	var unknown []string
+	for rule := range rules {
+		if !slices.Contains(allowed, rule) {
+			unknown = append(unknown, rule)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown masking rules %s; expected some of %s",
+			strings.Join(unknown, ", "), strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// Reject per-request overrides naming rules the data type does not have
+func validateMaskingOverrides(dataSchema *DataSchema, overrides map[string]bool) error {
+	return checkMaskingRuleNames(overrides, dataSchema.MaskingRules)
+}
+
+// Stored preferences of address. Users who never saved any mask nothing.
+func loadMaskingPreferences(ctx context.Context, address string) (MaskingPreferences, error) {
+	preferences := MaskingPreferences{Address: address}
+	err := db.Collection(MASKING_PREFERENCES_COLLECTION).FindOne(ctx, bson.M{"address": address}).Decode(&preferences)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return preferences, fmt.Errorf("failed to load masking preferences: %v", err)
+	}
+
+	rules := make(map[string]bool)
+	for _, rule := range knownMaskingRules() {
+		rules[rule] = preferences.MaskingRules[rule]
+	}
+	preferences.MaskingRules = rules
+	return preferences, nil
+}
+
+// Masking rules applied when refining dataSchema data of address: the
+// stored preferences, overridden per request by overrides. Every rule of
+// the data type is present so the recorded rules also show what was left
+// unmasked.
+func resolveMaskingRules(ctx context.Context, dataSchema *DataSchema, address string, overrides map[string]bool) (map[string]bool, error) {
+	preferences, err := loadMaskingPreferences(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make(map[string]bool, len(dataSchema.MaskingRules))
+	for _, rule := range dataSchema.MaskingRules {
+		rules[rule] = preferences.MaskingRules[rule]
+		if override, ok := overrides[rule]; ok {
+			rules[rule] = override
+		}
+	}
+	return rules, nil
+}
+
+// Get the masking preferences of a user
+func getMaskingPreferences(c *gin.Context) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	preferences, err := loadMaskingPreferences(c.Request.Context(), address)
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to fetch masking preferences")
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+// Replace the masking preferences of a user. Rules left out are disabled.
+// Applies to contributions refined from now on.
+func updateMaskingPreferences(c *gin.Context) {
+	address := c.Param("address")
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	var req UpdateMaskingPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if err := checkMaskingRuleNames(req.MaskingRules, knownMaskingRules()); err != nil {
+		respondError(c, ERR_INVALID_REQUEST, err.Error())
+		return
+	}
+
+	preferences := MaskingPreferences{Address: address, MaskingRules: make(map[string]bool), UpdatedAt: time.Now()}
+	for _, rule := range knownMaskingRules() {
+		preferences.MaskingRules[rule] = req.MaskingRules[rule]
+	}
+
+	_, err := db.Collection(MASKING_PREFERENCES_COLLECTION).UpdateOne(c.Request.Context(),
+		bson.M{"address": address},
+		bson.M{"$set": preferences},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to update masking preferences")
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
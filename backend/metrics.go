@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const METRICS_NAMESPACE = "tubedao"
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	eventsIngested = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "events_ingested_total",
+		Help:      "Extension events accepted for storage by category.",
+	}, []string{"category"})
+
+	eventsDuplicate = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "events_duplicate_total",
+		Help:      "Extension events skipped because their event ID was already stored.",
+	})
+
+	eventsRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "events_rejected_total",
+		Help:      "Extension events rejected by schema validation.",
+	})
+
+	refinementStageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "refinement_stage_duration_seconds",
+		Help:      "Duration of each VRC-15 refinement stage.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"stage"})
+
+	chainTxSubmissions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "chain_tx_submissions_total",
+		Help:      "Transactions submitted to the chain by contract method.",
+	}, []string{"method"})
+
+	chainTxFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "chain_tx_failures_total",
+		Help:      "Transactions that failed to submit or reverted, by contract method and phase.",
+	}, []string{"method", "phase"})
+
+	chainGasUsed = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "chain_tx_gas_used",
+		Help:      "Gas used by mined transactions by contract method.",
+		Buckets:   prometheus.ExponentialBuckets(21000, 2, 8),
+	}, []string{"method"})
+
+	siweVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "siwe_verifications_total",
+		Help:      "SIWE verification attempts by outcome.",
+	}, []string{"outcome"})
+
+	keyReleases = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "key_releases_total",
+		Help:      "Dataset key requests by outcome (released, denied, no_key, revoked, failed).",
+	}, []string{"outcome"})
+
+	registryMembershipCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "registry_membership_cache_total",
+		Help:      "Registry membership lookups by cache result (hit or miss).",
+	}, []string{"result"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "websocket_connections",
+		Help:      "Open event WebSocket connections.",
+	}, func() float64 {
+		return float64(notices.Count())
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "job_queue_depth",
+		Help:      "Background jobs waiting for a worker.",
+	}, func() float64 {
+		if jobQueue == nil {
+			return 0
+		}
+		return float64(jobQueue.Depth())
+	})
+)
+
+// Handler serving Prometheus metrics
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware recording request latency and status per route
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		httpRequestDuration.WithLabelValues(
+			c.Request.Method,
+			route,
+			strconv.Itoa(c.Writer.Status()),
+		).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Record how long a refinement stage took since start
+func observeRefinementStage(stage string, start time.Time) {
+	refinementStageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// Categories emitted by the extension adapters; anything else is bucketed
+// as "other" to keep label cardinality bounded
+var metricEventCategories = map[string]bool{
+	"playback":    true,
+	"ad":          true,
+	"engagement":  true,
+	"navigation":  true,
+	"interaction": true,
+	"media":       true,
+	"session":     true,
+}
+
+// Count stored events by category along with duplicate and rejected events
+func recordIngestedEvents(events []interface{}, duplicates, rejected int) {
+	for _, event := range events {
+		category := "other"
+		if userEvent, ok := event.(UserEvent); ok && metricEventCategories[userEvent.Category] {
+			category = userEvent.Category
+		}
+		eventsIngested.WithLabelValues(category).Inc()
+	}
+	if duplicates > 0 {
+		eventsDuplicate.Add(float64(duplicates))
+	}
+	if rejected > 0 {
+		eventsRejected.Add(float64(rejected))
+	}
+}
+
+// Record gas usage and revert status of a mined transaction
+func observeReceipt(method string, receipt *types.Receipt) {
+	chainGasUsed.WithLabelValues(method).Observe(float64(receipt.GasUsed))
+	if receipt.Status == types.ReceiptStatusFailed {
+		chainTxFailures.WithLabelValues(method, "reverted").Inc()
+	}
+}
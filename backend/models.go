@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -21,6 +22,21 @@ type UserContribution struct {
 	QualityScore int                `json:"qualityScore" bson:"qualityScore"`
 	TEEJobId     string             `json:"teeJobId" bson:"teeJobId"`
 	IPFSHash     string             `json:"ipfsHash" bson:"ipfsHash"`
+	RequestID    string             `json:"requestId,omitempty" bson:"requestId,omitempty"`
+
+	// Set when the contributor revoked one of its datasets; DataContent is
+	// dropped then
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+
+	// Every masked variant refined from the upload, the primary first
+	Variants []ContributionVariant `json:"variants,omitempty" bson:"variants,omitempty"`
+
+	// Set for contributions refined from extension events: the epoch they
+	// cover and the stored events they were built from
+	EpochStart       *time.Time           `json:"epochStart,omitempty" bson:"epochStart,omitempty"`
+	EpochEnd         *time.Time           `json:"epochEnd,omitempty" bson:"epochEnd,omitempty"`
+	SourceEventCount int                  `json:"sourceEventCount,omitempty" bson:"sourceEventCount,omitempty"`
+	SourceEventIDs   []primitive.ObjectID `json:"sourceEventIds,omitempty" bson:"sourceEventIds,omitempty"`
 }
 
 type UserRewards struct {
@@ -35,6 +51,117 @@ type UploadDataRequest struct {
 	FileName    string      `json:"fileName" binding:"required"`
 	FileSize    int64       `json:"fileSize"`
 	DataContent interface{} `json:"dataContent" binding:"required"`
+	// Overrides the stored masking preferences for this upload only
+	MaskingRules map[string]bool `json:"maskingRules,omitempty"`
+}
+
+type UploadDataResponse struct {
+	Message string           `json:"message"`
+	Data    UserContribution `json:"data"`
+	TxHash  string           `json:"txHash"`
+}
+
+// Start of a resumable upload. Checksum is the hex SHA-256 of the whole
+// file, verified on finalize.
+type CreateUploadRequest struct {
+	Address  string `json:"address" binding:"required"`
+	DataType string `json:"dataType" binding:"required"`
+	FileName string `json:"fileName" binding:"required"`
+	Length   int64  `json:"length" binding:"required,gt=0"`
+	Checksum string `json:"checksum,omitempty" binding:"omitempty,len=64,hexadecimal"`
+	// Overrides the stored masking preferences for this upload only
+	MaskingRules map[string]bool `json:"maskingRules,omitempty"`
+}
+
+type UploadSession struct {
+	ID             string              `json:"id" bson:"_id"`
+	Address        string              `json:"address" bson:"address"`
+	DataType       string              `json:"dataType" bson:"dataType"`
+	FileName       string              `json:"fileName" bson:"fileName"`
+	Length         int64               `json:"length" bson:"length"`
+	Offset         int64               `json:"offset" bson:"offset"`
+	Checksum       string              `json:"checksum,omitempty" bson:"checksum,omitempty"`
+	MaskingRules   map[string]bool     `json:"maskingRules,omitempty" bson:"maskingRules,omitempty"`
+	Status         string              `json:"status" bson:"status"`
+	ContributionID *primitive.ObjectID `json:"contributionId,omitempty" bson:"contributionId,omitempty"`
+	Error          string              `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt      time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      time.Time           `json:"updatedAt" bson:"updatedAt"`
+	ExpiresAt      time.Time           `json:"expiresAt" bson:"expiresAt"`
+}
+
+// Multipart fields of a Takeout import
+type TakeoutImportForm struct {
+	Address string     `json:"address"`
+	File    binaryData `json:"file"`
+	// JSON object overriding the stored masking preferences, e.g. {"titles":true}
+	MaskingRules string `json:"maskingRules,omitempty"`
+}
+
+// What was read from each recognised file of a Takeout archive
+type TakeoutFileSummary struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Format  string `json:"format,omitempty"`
+	Records int    `json:"records"`
+	Skipped int    `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type TakeoutImportSummary struct {
+	ArchiveSHA256 string               `json:"archiveSha256,omitempty"`
+	Files         []TakeoutFileSummary `json:"files"`
+	WatchHistory  int                  `json:"watchHistory"`
+	SearchHistory int                  `json:"searchHistory"`
+	Subscriptions int                  `json:"subscriptions"`
+}
+
+type TakeoutImportResponse struct {
+	Message string               `json:"message"`
+	Data    UserContribution     `json:"data"`
+	TxHash  string               `json:"txHash"`
+	Import  TakeoutImportSummary `json:"import"`
+}
+
+type ContributionsResponse struct {
+	Data []UserContribution `json:"data"`
+}
+
+type RewardsResponse struct {
+	Data UserRewards `json:"data"`
+}
+
+// Wallet registered through the web app
+type RegisteredWallet struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Address   string             `json:"address" bson:"address"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+}
+
+type RegisterWalletRequest struct {
+	Address string `json:"address" binding:"required"`
+}
+
+type WalletResponse struct {
+	Data RegisteredWallet `json:"data"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+type ProbeResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadinessResponse struct {
+	Status string                    `json:"status"`
+	Checks map[string]ReadinessCheck `json:"checks,omitempty"`
+}
+
+type ReadinessCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
 
 // Authentication models
@@ -84,6 +211,17 @@ type RegistrationResponse struct {
 	Status         string `json:"status"`
 }
 
+type RegistrationNeededResponse struct {
+	Error              string `json:"error"`
+	RegistrationNeeded bool   `json:"registrationNeeded"`
+}
+
+type AuthStatusResponse struct {
+	Authenticated bool   `json:"authenticated"`
+	Address       string `json:"address,omitempty"`
+	ChainID       int    `json:"chainId,omitempty"`
+}
+
 type RegistrationStatus struct {
 	Completed bool   `json:"completed"`
 	Failed    bool   `json:"failed"`
@@ -131,6 +269,7 @@ type MokshaRegistration struct {
 	Error            string             `json:"error" bson:"error"`
 	CreatedAt        time.Time          `json:"createdAt" bson:"createdAt"`
 	CompletedAt      *time.Time         `json:"completedAt" bson:"completedAt"`
+	RequestID        string             `json:"requestId,omitempty" bson:"requestId,omitempty"`
 }
 
 type MokshaIdentity struct {
@@ -144,17 +283,24 @@ type MokshaIdentity struct {
 
 // Event models for Chrome extension data capture
 type UserEvent struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Address   string             `json:"address" bson:"address"`
-	EventType string             `json:"eventType" bson:"eventType"`
-	Category  string             `json:"category" bson:"category"`
-	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
-	SessionID string             `json:"sessionId" bson:"sessionId"`
-	PageURL   string             `json:"pageUrl" bson:"pageUrl"`
-	UserAgent string             `json:"userAgent" bson:"userAgent"`
-	Viewport  ViewportData       `json:"viewport" bson:"viewport"`
-	EventData interface{}        `json:"eventData" bson:"eventData"`
-	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	EventID    string             `json:"eventId,omitempty" bson:"eventId,omitempty"`
+	BatchID    string             `json:"batchId,omitempty" bson:"batchId,omitempty"`
+	Address    string             `json:"address" bson:"address"`
+	EventType  string             `json:"eventType" bson:"eventType"`
+	Category   string             `json:"category" bson:"category"`
+	Timestamp  time.Time          `json:"timestamp" bson:"timestamp"`
+	SessionID  string             `json:"sessionId" bson:"sessionId"`
+	PageURL    string             `json:"pageUrl" bson:"pageUrl"`
+	PageDomain string             `json:"pageDomain" bson:"pageDomain"`
+	Adapter    string             `json:"adapter,omitempty" bson:"adapter,omitempty"`
+	UserAgent  string             `json:"userAgent" bson:"userAgent"`
+	Viewport   ViewportData       `json:"viewport" bson:"viewport"`
+	EventData  interface{}        `json:"eventData" bson:"eventData"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+
+	// Legacy per-address document this event was split from
+	MigratedFrom *primitive.ObjectID `json:"-" bson:"migratedFrom,omitempty"`
 }
 
 type ViewportData struct {
@@ -163,17 +309,49 @@ type ViewportData struct {
 }
 
 type BatchEventUploadRequest struct {
-	Address string        `json:"address" binding:"required"`
-	Events  []interface{} `json:"events" binding:"required"` // Accept raw JSON events
+	Address string            `json:"address" binding:"required"`
+	BatchID string            `json:"batchId" binding:"required,max=64"`
+	Events  []json.RawMessage `json:"events" binding:"required"` // Validated per event
 }
 
 type BatchEventUploadResponse struct {
-	Message     string `json:"message"`
-	EventsCount int    `json:"eventsCount"`
-	UpdatedUser any    `json:"updatedUser"`
+	Message        string           `json:"message"`
+	BatchID        string           `json:"batchId"`
+	AcceptedCount  int              `json:"acceptedCount"`
+	DuplicateCount int              `json:"duplicateCount"`
+	RejectedCount  int              `json:"rejectedCount"`
+	Rejected       []EventRejection `json:"rejected,omitempty"`
+	UpdatedUser    any              `json:"updatedUser"`
+}
+
+// Outcome of a streaming NDJSON upload. Results only lists lines that were
+// not stored; every other non-empty line up to Lines was accepted.
+type StreamIngestSummary struct {
+	BatchID          string             `json:"batchId"`
+	Lines            int                `json:"lines"`
+	AcceptedCount    int                `json:"acceptedCount"`
+	DuplicateCount   int                `json:"duplicateCount"`
+	RejectedCount    int                `json:"rejectedCount"`
+	Results          []StreamLineResult `json:"results,omitempty"`
+	ResultsTruncated bool               `json:"resultsTruncated,omitempty"`
+}
+
+type StreamLineResult struct {
+	Line    int          `json:"line"`
+	Status  string       `json:"status"` // duplicate or rejected
+	EventID string       `json:"eventId,omitempty"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// Event that failed validation, identified by its position in the batch
+type EventRejection struct {
+	Index     int          `json:"index"`
+	EventType string       `json:"eventType,omitempty"`
+	Errors    []FieldError `json:"errors"`
 }
 
-// UserEventDocument
+// Legacy layout: all events of an address appended to one document. Only
+// read by the migrate-events command.
 type UserEventDocument struct {
 	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	Address   string             `json:"address" bson:"address"`
@@ -181,3 +359,316 @@ type UserEventDocument struct {
 	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
+
+type EventConsent struct {
+	Address   string    `json:"address" bson:"address"`
+	Enabled   bool      `json:"enabled" bson:"enabled"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty" bson:"updatedAt"`
+}
+
+type UpdateConsentRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// Masking rules a user wants applied to their contributions, keyed by rule
+// name as listed by /api/schemas
+type MaskingPreferences struct {
+	Address      string          `json:"address" bson:"address"`
+	MaskingRules map[string]bool `json:"maskingRules" bson:"maskingRules"`
+	UpdatedAt    time.Time       `json:"updatedAt,omitempty" bson:"updatedAt"`
+}
+
+type UpdateMaskingPreferencesRequest struct {
+	MaskingRules map[string]bool `json:"maskingRules" binding:"required"`
+}
+
+// Message sent by the collector over the event WebSocket
+type WSClientMessage struct {
+	Type    string            `json:"type"` // events or ping
+	Seq     int64             `json:"seq"`
+	BatchID string            `json:"batchId,omitempty"`
+	Events  []json.RawMessage `json:"events,omitempty"`
+}
+
+// Acknowledgement of a WebSocket message, matched to it by Seq
+type WSAck struct {
+	Kind           string           `json:"type"` // ack or pong
+	Seq            int64            `json:"seq"`
+	BatchID        string           `json:"batchId,omitempty"`
+	AcceptedCount  int              `json:"acceptedCount"`
+	DuplicateCount int              `json:"duplicateCount"`
+	RejectedCount  int              `json:"rejectedCount"`
+	Rejected       []EventRejection `json:"rejected,omitempty"`
+}
+
+type WSError struct {
+	Kind   string    `json:"type"` // always error
+	Seq    int64     `json:"seq,omitempty"`
+	Code   ErrorCode `json:"code"`
+	Detail string    `json:"detail"`
+}
+
+// Server-initiated notice pushed to connected collectors
+type Notice struct {
+	Kind      string      `json:"type"` // always notice
+	Type      string      `json:"notice"`
+	Data      interface{} `json:"data,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+type RewardCreditedNotice struct {
+	ContributionID string  `json:"contributionId"`
+	RewardAmount   float64 `json:"rewardAmount"`
+	QualityScore   int     `json:"qualityScore"`
+}
+
+type UploadProcessedNotice struct {
+	UploadID       string `json:"uploadId"`
+	Status         string `json:"status"`
+	ContributionID string `json:"contributionId,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// Page of a user's events, newest first. Pass NextCursor as ?cursor= to
+// fetch the next page; it is empty on the last page.
+type EventPage struct {
+	Events     []UserEvent `json:"events"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// Analytics models. Watch time is estimated from progress checkpoints,
+// which the YouTube adapter emits every PROGRESS_CHECKPOINT_SECONDS of
+// playback.
+type WatchTimeDay struct {
+	Day          string `json:"day" bson:"_id"`
+	WatchSeconds int64  `json:"watchSeconds" bson:"watchSeconds"`
+	VideoCount   int    `json:"videoCount" bson:"videoCount"`
+}
+
+type WatchTimeResponse struct {
+	From         time.Time      `json:"from"`
+	To           time.Time      `json:"to"`
+	Timezone     string         `json:"timezone"`
+	TotalSeconds int64          `json:"totalSeconds"`
+	Days         []WatchTimeDay `json:"days"`
+}
+
+type ChannelWatchTime struct {
+	ChannelID    string `json:"channelId" bson:"_id"`
+	ChannelName  string `json:"channelName" bson:"channelName"`
+	WatchSeconds int64  `json:"watchSeconds" bson:"watchSeconds"`
+	VideoCount   int    `json:"videoCount" bson:"videoCount"`
+}
+
+type TopChannelsResponse struct {
+	From     time.Time          `json:"from"`
+	To       time.Time          `json:"to"`
+	Channels []ChannelWatchTime `json:"channels"`
+}
+
+type AdImpressionDay struct {
+	Day         string `json:"day"`
+	Impressions int    `json:"impressions"`
+	Skips       int    `json:"skips"`
+}
+
+type AdImpressionsResponse struct {
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Timezone    string            `json:"timezone"`
+	Impressions int               `json:"impressions"`
+	Skips       int               `json:"skips"`
+	SkipRate    float64           `json:"skipRate"`
+	ByPosition  map[string]int    `json:"byPosition"`
+	Days        []AdImpressionDay `json:"days"`
+}
+
+type EventTypeCount struct {
+	EventType string `json:"eventType" bson:"_id"`
+	Count     int    `json:"count" bson:"count"`
+}
+
+type EngagementResponse struct {
+	From   time.Time        `json:"from"`
+	To     time.Time        `json:"to"`
+	Total  int              `json:"total"`
+	Counts []EventTypeCount `json:"counts"`
+}
+
+// One viewing of a video, derived from raw extension events by the
+// sessionizer. The embedded WatchHistoryEntry keeps sessions compatible
+// with Takeout watch history so both feed the same refinement pipeline.
+type WatchSession struct {
+	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Address           string             `json:"address" bson:"address"`
+	SessionID         string             `json:"sessionId" bson:"sessionId"`
+	WatchHistoryEntry `bson:",inline"`
+
+	EndedAt            time.Time `json:"endedAt" bson:"endedAt"`
+	WatchedSeconds     int       `json:"watchedSeconds" bson:"watchedSeconds"`
+	MaxPositionSeconds float64   `json:"maxPositionSeconds" bson:"maxPositionSeconds"`
+	AdsSeen            int       `json:"adsSeen" bson:"adsSeen"`
+	AdsSkipped         int       `json:"adsSkipped" bson:"adsSkipped"`
+	SeekCount          int       `json:"seekCount" bson:"seekCount"`
+	PauseCount         int       `json:"pauseCount" bson:"pauseCount"`
+	Completed          bool      `json:"completed" bson:"completed"`
+	EventCount         int       `json:"eventCount" bson:"eventCount"`
+	UpdatedAt          time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Processing record of one address's events for one epoch, so every epoch
+// is contributed at most once
+type EventEpoch struct {
+	ID             primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	Address        string              `json:"address" bson:"address"`
+	EpochStart     time.Time           `json:"epochStart" bson:"epochStart"`
+	EpochEnd       time.Time           `json:"epochEnd" bson:"epochEnd"`
+	Status         string              `json:"status" bson:"status"`
+	Attempts       int                 `json:"attempts" bson:"attempts"`
+	EventCount     int                 `json:"eventCount" bson:"eventCount"`
+	ContributionID *primitive.ObjectID `json:"contributionId,omitempty" bson:"contributionId,omitempty"`
+	Error          string              `json:"error,omitempty" bson:"error,omitempty"`
+	UpdatedAt      time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
+
+// A masked variant of a contribution, identified by the hash of its
+// plaintext
+type ContributionVariant struct {
+	Variant  string `json:"variant" bson:"variant"`
+	DataHash string `json:"dataHash" bson:"dataHash"`
+	IPFSHash string `json:"ipfsHash" bson:"ipfsHash"`
+}
+
+// Data key of a dataset, wrapped with a KMS master key. ContributorKey is
+// a copy ECIES-encrypted to the contributor's key, if they hold one. Once
+// the contributor revokes the dataset, RevokedAt is set and WrappedKey is
+// gone.
+type WrappedDataKey struct {
+	DatasetHash           string     `json:"datasetHash" bson:"_id"`
+	KeyID                 string     `json:"keyId" bson:"keyId"`
+	WrappedKey            []byte     `json:"-" bson:"wrappedKey,omitempty"`
+	Contributor           string     `json:"contributor" bson:"contributor"`
+	DataType              string     `json:"dataType" bson:"dataType"`
+	Variant               string     `json:"variant,omitempty" bson:"variant,omitempty"`
+	ContributorKey        []byte     `json:"-" bson:"contributorKey,omitempty"`
+	ContributorKeyAddress string     `json:"contributorKeyAddress,omitempty" bson:"contributorKeyAddress,omitempty"`
+	CreatedAt             time.Time  `json:"createdAt" bson:"createdAt"`
+	RewrappedAt           *time.Time `json:"rewrappedAt,omitempty" bson:"rewrappedAt,omitempty"`
+	RevokedAt             *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// Key a contributor's data keys are encrypted to. KeyAddress is the
+// Ethereum address of the public key, identifying it in data key copies.
+type ContributorKey struct {
+	Address    string    `json:"address" bson:"_id"`
+	PublicKey  string    `json:"publicKey" bson:"publicKey"`
+	KeyAddress string    `json:"keyAddress" bson:"keyAddress"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// Hex secp256k1 public key, compressed or uncompressed
+type SetContributorKeyRequest struct {
+	PublicKey string `json:"publicKey" binding:"required"`
+}
+
+// Data key of one of a contributor's datasets. EncryptedKey is the hex
+// ECIES ciphertext of the key under the key with address KeyAddress.
+type ContributorDataKey struct {
+	DatasetHash  string     `json:"datasetHash"`
+	DataType     string     `json:"dataType"`
+	Variant      string     `json:"variant,omitempty"`
+	EncryptedKey string     `json:"encryptedKey,omitempty"`
+	KeyAddress   string     `json:"keyAddress,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+}
+
+type ContributorDataKeysResponse struct {
+	Data []ContributorDataKey `json:"data"`
+}
+
+// Datasets whose keys a revocation deleted, every variant of the revoked
+// contributions
+type RevokeDataKeysResponse struct {
+	DatasetHashes   []string `json:"datasetHashes"`
+	ContributionIDs []string `json:"contributionIds"`
+}
+
+// Signed request for a dataset key. Signature is the personal_sign of
+// "TubeDAO dataset key request\nDataset: <datasetId>\nBuyer: <address>\nIssued At: <issuedAt>"
+// with checksummed hex values, followed by "\nEncryption Key: <encryptionKey>"
+// when one is sent.
+type KeyReleaseRequest struct {
+	IssuedAt  string `json:"issuedAt" binding:"required"`
+	Signature string `json:"signature" binding:"required"`
+	// Hex secp256k1 public key to encrypt the dataset key to, included in
+	// the signed message; defaults to the wallet's signing key
+	EncryptionKey string `json:"encryptionKey,omitempty"`
+}
+
+// EncryptedTo is the address of the key EncryptedKey is encrypted to
+type KeyReleaseResponse struct {
+	ReleaseID    string `json:"releaseId"`
+	DatasetID    string `json:"datasetId"`
+	Buyer        string `json:"buyer"`
+	EncryptedTo  string `json:"encryptedTo"`
+	Algorithm    string `json:"algorithm"`
+	EncryptedKey string `json:"encryptedKey"`
+}
+
+// Audit record of a dataset key request
+type KeyRelease struct {
+	ID          string    `json:"id" bson:"_id"`
+	DatasetID   string    `json:"datasetId" bson:"datasetId"`
+	Buyer       string    `json:"buyer" bson:"buyer"`
+	EncryptedTo string    `json:"encryptedTo" bson:"encryptedTo"`
+	Outcome     string    `json:"outcome" bson:"outcome"`
+	Reason      string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	IssuedAt    time.Time `json:"issuedAt" bson:"issuedAt"`
+	RequestID   string    `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	ClientIP    string    `json:"clientIp" bson:"clientIp"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// Epsilon spent on an aggregate dataset
+type PrivacyBudget struct {
+	DatasetID        string  `json:"datasetId" bson:"_id"`
+	DataType         string  `json:"dataType" bson:"dataType"`
+	EpsilonTotal     float64 `json:"epsilonTotal" bson:"epsilonTotal"`
+	EpsilonSpent     float64 `json:"epsilonSpent" bson:"epsilonSpent"`
+	EpsilonRemaining float64 `json:"epsilonRemaining" bson:"-"`
+	DeltaSpent       float64 `json:"deltaSpent" bson:"deltaSpent"`
+	Queries          int     `json:"queries" bson:"queries"`
+	// Whether the dataset's access price is set on QueryEngine
+	Registered bool      `json:"registered" bson:"registered"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+type AggregateDatasetsResponse struct {
+	Data []PrivacyBudget `json:"data"`
+}
+
+type AggregateQueryRequest struct {
+	DataType  string   `json:"dataType" binding:"required"`
+	Query     string   `json:"query" binding:"required,oneof=watch_time_by_hour channel_audience_overlap"`
+	Channels  []string `json:"channels,omitempty" binding:"omitempty,dive,required"`
+	Epsilon   float64  `json:"epsilon" binding:"required,gt=0"`
+	Mechanism string   `json:"mechanism,omitempty" binding:"omitempty,oneof=laplace gaussian"`
+}
+
+type AggregateValue struct {
+	Label string  `json:"label"`
+	Value float64 `json:"value"`
+}
+
+type AggregateQueryResponse struct {
+	DatasetID   string           `json:"datasetId"`
+	Query       string           `json:"query"`
+	Mechanism   string           `json:"mechanism"`
+	Epsilon     float64          `json:"epsilon"`
+	Delta       float64          `json:"delta"`
+	NoiseStdDev float64          `json:"noiseStdDev"`
+	Values      []AggregateValue `json:"values"`
+	Budget      PrivacyBudget    `json:"budget"`
+}
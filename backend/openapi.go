@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const OPENAPI_VERSION = "3.0.3"
+
+// Description of one HTTP operation in the OpenAPI document. Request and
+// response bodies are described by the same Go types the handlers bind and
+// return, so the schemas cannot silently diverge from the code.
+type apiOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Tag         string
+	Auth        bool
+	Request     interface{}
+	Responses   map[int]interface{}
+	ContentType string
+
+	// Required and optional query parameters and a non-JSON request media type
+	Query              []string
+	OptionalQuery      []string
+	RequestContentType string
+}
+
+type rawJSONBody struct{}
+
+// Raw file bytes, as a multipart field or a whole request body
+type binaryData struct{}
+
+// Every route served by newRouter must be listed here with the types its
+// handler binds and writes; openapi_test.go fails when they drift apart.
+var apiOperations = []apiOperation{
+	// Authentication
+	{Method: "POST", Path: "/api/auth/nonce", Summary: "Generate a SIWE nonce", Tag: "auth",
+		Request: NonceRequest{}, Responses: map[int]interface{}{200: NonceResponse{}}},
+	{Method: "POST", Path: "/api/auth/verify", Summary: "Verify a SIWE signature", Tag: "auth",
+		Request: SIWERequest{}, Responses: map[int]interface{}{200: AuthResponse{}, 202: TempAuthResponse{}}},
+	{Method: "POST", Path: "/api/auth/bind-moksha", Summary: "Bind a Moksha identity", Tag: "auth", Auth: true,
+		Request: BindMokshaRequest{}, Responses: map[int]interface{}{200: AuthResponse{}, 202: RegistrationNeededResponse{}}},
+	{Method: "POST", Path: "/api/auth/register-moksha", Summary: "Register with the Moksha network", Tag: "auth",
+		Request: RegisterMokshaRequest{}, Responses: map[int]interface{}{200: RegistrationResponse{}}},
+	{Method: "GET", Path: "/api/auth/registration-status/:registrationId", Summary: "Poll Moksha registration status", Tag: "auth",
+		Responses: map[int]interface{}{200: RegistrationStatus{}}},
+	{Method: "POST", Path: "/api/auth/logout", Summary: "Invalidate the current session", Tag: "auth",
+		Responses: map[int]interface{}{200: MessageResponse{}}},
+	{Method: "GET", Path: "/api/auth/status", Summary: "Check authentication status", Tag: "auth", Auth: true,
+		Responses: map[int]interface{}{200: AuthStatusResponse{}, 401: AuthStatusResponse{}}},
+
+	// Wallets and users
+	{Method: "POST", Path: "/api/register-wallet", Summary: "Register the authenticated wallet", Tag: "users", Auth: true,
+		Request: RegisterWalletRequest{}, Responses: map[int]interface{}{200: WalletResponse{}, 201: WalletResponse{}}},
+	{Method: "GET", Path: "/api/user/:address", Summary: "Get a registered wallet", Tag: "users", Auth: true,
+		Responses: map[int]interface{}{200: WalletResponse{}}},
+	{Method: "GET", Path: "/api/user/:address/contributions", Summary: "List contributions for a user", Tag: "contributions", Auth: true,
+		Responses: map[int]interface{}{200: ContributionsResponse{}}},
+	{Method: "GET", Path: "/api/user/:address/rewards", Summary: "Get total rewards for a user", Tag: "contributions", Auth: true,
+		Responses: map[int]interface{}{200: RewardsResponse{}}},
+	{Method: "GET", Path: "/api/user/:address/consent", Summary: "Get event collection consent", Tag: "users", Auth: true,
+		Responses: map[int]interface{}{200: EventConsent{}}},
+	{Method: "PUT", Path: "/api/user/:address/consent", Summary: "Grant or revoke event collection consent", Tag: "users", Auth: true,
+		Request: UpdateConsentRequest{}, Responses: map[int]interface{}{200: EventConsent{}}},
+	{Method: "GET", Path: "/api/user/:address/masking", Summary: "Get privacy masking preferences", Tag: "users", Auth: true,
+		Responses: map[int]interface{}{200: MaskingPreferences{}}},
+	{Method: "PUT", Path: "/api/user/:address/masking", Summary: "Replace privacy masking preferences", Tag: "users", Auth: true,
+		Request: UpdateMaskingPreferencesRequest{}, Responses: map[int]interface{}{200: MaskingPreferences{}}},
+	{Method: "GET", Path: "/api/user/:address/encryption-key", Summary: "Get the key new data keys are encrypted to", Tag: "users", Auth: true,
+		Responses: map[int]interface{}{200: ContributorKey{}}},
+	{Method: "PUT", Path: "/api/user/:address/encryption-key", Summary: "Hold copies of data keys under a public key", Tag: "users", Auth: true,
+		Request: SetContributorKeyRequest{}, Responses: map[int]interface{}{200: ContributorKey{}}},
+	{Method: "DELETE", Path: "/api/user/:address/encryption-key", Summary: "Stop encrypting new data keys to the user", Tag: "users", Auth: true,
+		Responses: map[int]interface{}{204: nil}},
+	{Method: "GET", Path: "/api/user/:address/data-keys", Summary: "Data keys of the user's datasets, ECIES-encrypted to their key", Tag: "users", Auth: true,
+		Responses: map[int]interface{}{200: ContributorDataKeysResponse{}}},
+	{Method: "DELETE", Path: "/api/user/:address/data-keys/:datasetId", Summary: "Revoke a contribution by deleting the backend's keys and plaintext copy", Tag: "users", Auth: true,
+		Responses: map[int]interface{}{200: RevokeDataKeysResponse{}}},
+	{Method: "POST", Path: "/api/upload-data", Summary: "Upload and refine contribution data", Tag: "contributions", Auth: true,
+		Request: UploadDataRequest{}, Responses: map[int]interface{}{201: UploadDataResponse{}}},
+
+	// Extension events (legacy paths kept for the Chrome extension)
+	{Method: "POST", Path: "/api/events/upload", Summary: "Upload a batch of extension events", Tag: "events", Auth: true,
+		Request: BatchEventUploadRequest{}, Responses: map[int]interface{}{200: BatchEventUploadResponse{}, 201: BatchEventUploadResponse{}}},
+	{Method: "POST", Path: "/api/events/stream", Summary: "Stream NDJSON events, optionally gzip or zstd encoded", Tag: "events", Auth: true,
+		Query: []string{"address", "batchId"}, Request: IncomingEvent{}, RequestContentType: "application/x-ndjson",
+		Responses: map[int]interface{}{200: StreamIngestSummary{}}},
+	{Method: "GET", Path: "/api/events/ws", Summary: "Event WebSocket (token via Authorization, ?token= or a bearer.<token> subprotocol alongside tubedao.events.v1)", Tag: "events", Auth: true,
+		Responses: map[int]interface{}{101: nil}},
+	{Method: "POST", Path: "/api/events/upload-data", Summary: "Upload and refine contribution data", Tag: "contributions", Auth: true,
+		Request: UploadDataRequest{}, Responses: map[int]interface{}{201: UploadDataResponse{}}},
+	{Method: "GET", Path: "/api/events/user/:address", Summary: "List a user's events, newest first (from/to are RFC 3339; pass nextCursor as cursor)", Tag: "events", Auth: true,
+		OptionalQuery: []string{"from", "to", "category", "eventType", "domain", "limit", "cursor"},
+		Responses:     map[int]interface{}{200: EventPage{}}},
+	{Method: "GET", Path: "/api/events/user/:address/analytics/watch-time", Summary: "Watch time per day (tz is an IANA timezone, default UTC)", Tag: "analytics", Auth: true,
+		OptionalQuery: []string{"from", "to", "tz"}, Responses: map[int]interface{}{200: WatchTimeResponse{}}},
+	{Method: "GET", Path: "/api/events/user/:address/analytics/top-channels", Summary: "Channels ranked by watch time", Tag: "analytics", Auth: true,
+		OptionalQuery: []string{"from", "to", "limit"}, Responses: map[int]interface{}{200: TopChannelsResponse{}}},
+	{Method: "GET", Path: "/api/events/user/:address/analytics/ads", Summary: "Ad impressions and skips per day and by position", Tag: "analytics", Auth: true,
+		OptionalQuery: []string{"from", "to", "tz"}, Responses: map[int]interface{}{200: AdImpressionsResponse{}}},
+	{Method: "GET", Path: "/api/events/user/:address/analytics/engagement", Summary: "Engagement events counted by type", Tag: "analytics", Auth: true,
+		OptionalQuery: []string{"from", "to"}, Responses: map[int]interface{}{200: EngagementResponse{}}},
+	{Method: "GET", Path: "/api/events/user/:address/contributions", Summary: "List contributions for a user", Tag: "contributions", Auth: true,
+		Responses: map[int]interface{}{200: ContributionsResponse{}}},
+	{Method: "GET", Path: "/api/events/user/:address/rewards", Summary: "Get total rewards for a user", Tag: "contributions", Auth: true,
+		Responses: map[int]interface{}{200: RewardsResponse{}}},
+
+	{Method: "POST", Path: "/api/takeout/import", Summary: "Import a Google Takeout zip (watch and search history as JSON or HTML, subscriptions.csv)", Tag: "contributions", Auth: true,
+		Request: TakeoutImportForm{}, RequestContentType: "multipart/form-data",
+		Responses: map[int]interface{}{201: TakeoutImportResponse{}}},
+	{Method: "POST", Path: "/api/uploads", Summary: "Start a resumable upload (checksum is the hex SHA-256 of the whole file)", Tag: "uploads", Auth: true,
+		Request: CreateUploadRequest{}, Responses: map[int]interface{}{201: UploadSession{}}},
+	{Method: "HEAD", Path: "/api/uploads/:id", Summary: "Upload progress in the Upload-Offset and Upload-Length headers", Tag: "uploads", Auth: true,
+		Responses: map[int]interface{}{200: nil}},
+	{Method: "GET", Path: "/api/uploads/:id", Summary: "Upload status, including the contribution once refined", Tag: "uploads", Auth: true,
+		Responses: map[int]interface{}{200: UploadSession{}}},
+	{Method: "PUT", Path: "/api/uploads/:id", Summary: "Append a chunk at the Upload-Offset header, optionally verified by Upload-Checksum: sha256 <base64>", Tag: "uploads", Auth: true,
+		Request: binaryData{}, RequestContentType: "application/offset+octet-stream",
+		Responses: map[int]interface{}{204: nil}},
+	{Method: "POST", Path: "/api/uploads/:id/finalize", Summary: "Verify a complete upload and refine it in the background", Tag: "uploads", Auth: true,
+		Responses: map[int]interface{}{202: UploadSession{}}},
+	{Method: "DELETE", Path: "/api/uploads/:id", Summary: "Abort an upload", Tag: "uploads", Auth: true,
+		Responses: map[int]interface{}{204: nil}},
+	{Method: "GET", Path: "/api/schemas", Summary: "Data types accepted by upload-data and their masking rules", Tag: "contributions",
+		Responses: map[int]interface{}{200: DataSchemasResponse{}}},
+
+	// Differentially private aggregates for data buyers
+	{Method: "GET", Path: "/api/aggregates", Summary: "List aggregate datasets and their privacy budgets", Tag: "aggregates",
+		Responses: map[int]interface{}{200: AggregateDatasetsResponse{}}},
+	{Method: "POST", Path: "/api/aggregates/query", Summary: "Run a differentially private aggregate query", Tag: "aggregates", Auth: true,
+		Request: AggregateQueryRequest{}, Responses: map[int]interface{}{200: AggregateQueryResponse{}}},
+
+	// Dataset keys for data buyers
+	{Method: "POST", Path: "/api/data/:datasetId/key", Summary: "Release a purchased dataset key, ECIES-encrypted to the wallet that signed the request", Tag: "datasets", Auth: true,
+		Request: KeyReleaseRequest{}, Responses: map[int]interface{}{200: KeyReleaseResponse{}}},
+
+	// Operations
+	{Method: "GET", Path: "/api/openapi.json", Summary: "This OpenAPI document", Tag: "meta",
+		Responses: map[int]interface{}{200: rawJSONBody{}}},
+	{Method: "GET", Path: "/livez", Summary: "Liveness probe", Tag: "meta",
+		Responses: map[int]interface{}{200: ProbeResponse{}}},
+	{Method: "GET", Path: "/health", Summary: "Liveness probe (legacy alias)", Tag: "meta",
+		Responses: map[int]interface{}{200: ProbeResponse{}}},
+	{Method: "GET", Path: "/readyz", Summary: "Readiness probe", Tag: "meta",
+		Responses: map[int]interface{}{200: ReadinessResponse{}, 503: ReadinessResponse{}}},
+	{Method: "GET", Path: "/metrics", Summary: "Prometheus metrics", Tag: "meta", ContentType: "text/plain",
+		Responses: map[int]interface{}{200: ""}},
+}
+
+var (
+	openAPISpecOnce sync.Once
+	openAPISpec     map[string]interface{}
+)
+
+// Serve the generated OpenAPI document
+func serveOpenAPISpec(c *gin.Context) {
+	openAPISpecOnce.Do(func() {
+		openAPISpec = buildOpenAPISpec()
+	})
+	c.JSON(http.StatusOK, openAPISpec)
+}
+
+// Build the OpenAPI document from apiOperations and the Go types they reference
+func buildOpenAPISpec() map[string]interface{} {
+	gen := &schemaGenerator{schemas: make(map[string]interface{})}
+	paths := make(map[string]map[string]interface{})
+
+	for _, op := range apiOperations {
+		path := openAPIPath(op.Path)
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+
+		operation := map[string]interface{}{
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"operationId": operationID(op),
+			"responses":   gen.responses(op),
+		}
+		params := pathParameters(op.Path)
+		for _, name := range op.Query {
+			params = append(params, queryParameter(name, true))
+		}
+		for _, name := range op.OptionalQuery {
+			params = append(params, queryParameter(name, false))
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			requestContentType := op.RequestContentType
+			if requestContentType == "" {
+				requestContentType = "application/json"
+			}
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					requestContentType: map[string]interface{}{"schema": gen.schemaFor(reflect.TypeOf(op.Request))},
+				},
+			}
+		}
+		if op.Auth {
+			operation["security"] = []map[string][]string{{"bearerAuth": {}}}
+		}
+
+		paths[path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": OPENAPI_VERSION,
+		"info": map[string]interface{}{
+			"title":       "TubeDAO Backend API",
+			"version":     "1.0.0",
+			"description": errorCatalogueDescription(),
+		},
+		"servers": []map[string]string{{"url": "/"}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": gen.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+func queryParameter(name string, required bool) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"in":       "query",
+		"required": required,
+		"schema":   map[string]string{"type": "string"},
+	}
+}
+
+var ginParamPattern = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+func openAPIPath(path string) string {
+	return ginParamPattern.ReplaceAllString(path, "{$1}")
+}
+
+func pathParameters(path string) []map[string]interface{} {
+	var params []map[string]interface{}
+	for _, match := range ginParamPattern.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]string{"type": "string"},
+		})
+	}
+	return params
+}
+
+func operationID(op apiOperation) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	for _, part := range strings.FieldsFunc(openAPIPath(op.Path), func(r rune) bool {
+		return r == '/' || r == '-' || r == '.' || r == '{' || r == '}'
+	}) {
+		if part == "api" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// Reflection-based JSON schema generator following encoding/json tag rules
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	rawJSONType  = reflect.TypeOf(json.RawMessage{})
+	fileType     = reflect.TypeOf(binaryData{})
+)
+
+func (g *schemaGenerator) responses(op apiOperation) map[string]interface{} {
+	responses := make(map[string]interface{})
+	for status, body := range op.Responses {
+		response := map[string]interface{}{"description": http.StatusText(status)}
+
+		switch {
+		case op.ContentType != "":
+			response["content"] = map[string]interface{}{
+				op.ContentType: map[string]interface{}{"schema": map[string]string{"type": "string"}},
+			}
+		case body != nil:
+			schema := map[string]interface{}{"type": "object"}
+			if _, raw := body.(rawJSONBody); !raw {
+				schema = g.schemaFor(reflect.TypeOf(body))
+			}
+			response["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schema},
+			}
+		}
+
+		responses[fmt.Sprintf("%d", status)] = response
+	}
+
+	responses["default"] = map[string]interface{}{
+		"description": "Error response; see the code catalogue in the API description",
+		"content": map[string]interface{}{
+			PROBLEM_CONTENT_TYPE: map[string]interface{}{"schema": g.problemSchema()},
+		},
+	}
+	return responses
+}
+
+// Problem details schema with the error code catalogue as an enum
+func (g *schemaGenerator) problemSchema() map[string]interface{} {
+	ref := g.schemaFor(reflect.TypeOf(ProblemDetails{}))
+
+	schema := g.schemas["ProblemDetails"].(map[string]interface{})
+	properties := schema["properties"].(map[string]interface{})
+	if _, done := properties["code"].(map[string]interface{})["enum"]; !done {
+		var codes []string
+		for _, code := range sortedErrorCodes() {
+			codes = append(codes, string(code))
+		}
+		properties["code"] = map[string]interface{}{"type": "string", "enum": codes}
+	}
+	return ref
+}
+
+// Markdown table of error codes for the API description
+func errorCatalogueDescription() string {
+	var b strings.Builder
+	b.WriteString("Errors are returned as RFC 7807 `application/problem+json` bodies. ")
+	b.WriteString("Clients should branch on `code`:\n\n| Code | Status | Meaning |\n| --- | --- | --- |\n")
+	for _, code := range sortedErrorCodes() {
+		info := errorCatalogue[code]
+		fmt.Fprintf(&b, "| `%s` | %d | %s |\n", code, info.Status, info.Title)
+	}
+	return b.String()
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case objectIDType:
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	case rawJSONType:
+		return map[string]interface{}{}
+	case fileType:
+		return map[string]interface{}{"type": "string", "format": "binary"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, exists := g.schemas[t.Name()]; !exists {
+			// Reserve the name first so recursive types terminate
+			g.schemas[t.Name()] = map[string]interface{}{}
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	return map[string]interface{}{}
+}
+
+// Request types declare required fields with gin binding tags; for other
+// types every field without omitempty is always present in the JSON output.
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	validated := false
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup("binding"); ok {
+			validated = true
+		}
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, omitempty, skip := jsonFieldName(field)
+		if skip {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			embedded := g.structSchema(field.Type)
+			for key, value := range embedded["properties"].(map[string]interface{}) {
+				properties[key] = value
+			}
+			if embeddedRequired, ok := embedded["required"].([]string); ok {
+				required = append(required, embeddedRequired...)
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = g.schemaFor(field.Type)
+		if validated {
+			if strings.Contains(field.Tag.Get("binding"), "required") {
+				required = append(required, name)
+			}
+		} else if !omitempty && field.Type.Kind() != reflect.Interface && field.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func jsonFieldName(field reflect.StructField) (name string, omitempty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+
+	parts := strings.Split(tag, ",")
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitempty = true
+		}
+	}
+	return parts[0], omitempty, false
+}
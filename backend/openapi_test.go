@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every registered route is documented and every documented operation is
+// routed
+func TestOpenAPIOperationsMatchRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	documented := make(map[string]bool)
+	for _, op := range apiOperations {
+		documented[op.Method+" "+op.Path] = true
+	}
+	registered := make(map[string]bool)
+	for _, route := range newRouter().Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+
+	for key := range registered {
+		if !documented[key] {
+			t.Errorf("route missing from OpenAPI spec: %s", key)
+		}
+	}
+	for key := range documented {
+		if !registered[key] {
+			t.Errorf("OpenAPI operation has no route: %s", key)
+		}
+	}
+}
+
+// Every schema the document references is defined
+func TestOpenAPISchemaReferencesResolve(t *testing.T) {
+	spec := buildOpenAPISpec()
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	var walk func(path string, value interface{})
+	walk = func(path string, value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				if _, defined := schemas[name]; !defined {
+					t.Errorf("%s references undefined schema %s", path, name)
+				}
+			}
+			for key, child := range v {
+				walk(path+"/"+key, child)
+			}
+		case map[string]map[string]interface{}:
+			for key, child := range v {
+				walk(path+"/"+key, child)
+			}
+		case []interface{}:
+			for i, child := range v {
+				walk(fmt.Sprintf("%s/%d", path, i), child)
+			}
+		}
+	}
+	walk("#", spec)
+}
+
+// The request and response types of each operation are the ones its
+// handler binds and writes, found by type-checking the handler source
+func TestOpenAPIOperationTypesMatchHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	contracts, err := loadHandlerContracts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := make(map[string]string)
+	for _, route := range newRouter().Routes() {
+		// Handler names are qualified by the module path
+		handlers[route.Method+" "+route.Path] = route.Handler[strings.Index(route.Handler, ".")+1:]
+	}
+
+	for _, op := range apiOperations {
+		key := op.Method + " " + op.Path
+		name := handlers[key]
+		if name == "" || strings.Contains(name, ".") {
+			continue // unrouted, or a closure without a declaration to inspect
+		}
+		contract := contracts.analyze(name)
+		if contract == nil {
+			t.Errorf("%s: handler %s not found", key, name)
+			continue
+		}
+
+		for _, problem := range contract.compare(op) {
+			t.Errorf("%s (%s): %s", key, name, problem)
+		}
+	}
+}
+
+// Bodies a handler binds and writes, and the multipart form fields it
+// reads. A status of -1 means the status is not a constant.
+type handlerContract struct {
+	requests   map[string]bool
+	formFields map[string]bool
+	responses  map[int]map[string]bool
+}
+
+func (h *handlerContract) compare(op apiOperation) []string {
+	var problems []string
+
+	switch op.RequestContentType {
+	case "", "application/json":
+		problems = append(problems, h.compareRequest(op)...)
+	case "multipart/form-data":
+		documented := make(map[string]bool)
+		form := reflect.TypeOf(op.Request)
+		for i := 0; i < form.NumField(); i++ {
+			name, _, _ := jsonFieldName(form.Field(i))
+			documented[name] = true
+			if !h.formFields[name] {
+				problems = append(problems, fmt.Sprintf("documented form field %q is never read", name))
+			}
+		}
+		for name := range h.formFields {
+			if !documented[name] {
+				problems = append(problems, fmt.Sprintf("reads undocumented form field %q", name))
+			}
+		}
+	}
+	// Other media types, such as NDJSON streams, are read from the body
+	// directly
+	return append(problems, h.compareResponses(op)...)
+}
+
+func (h *handlerContract) compareRequest(op apiOperation) []string {
+	var problems []string
+	if op.Request != nil {
+		want := reflect.TypeOf(op.Request).Name()
+		if !h.requests[want] {
+			problems = append(problems, fmt.Sprintf("documented request %s is not bound (binds %v)", want, sortedKeys(h.requests)))
+		}
+	}
+	for got := range h.requests {
+		if op.Request == nil || reflect.TypeOf(op.Request).Name() != got {
+			problems = append(problems, fmt.Sprintf("binds undocumented request %s", got))
+		}
+	}
+	return problems
+}
+
+func (h *handlerContract) compareResponses(op apiOperation) []string {
+	// Plain-text bodies are not described by Go types
+	if op.ContentType != "" {
+		return nil
+	}
+	var problems []string
+	documented := func(status int, body string) bool {
+		for documentedStatus, documentedBody := range op.Responses {
+			if status != -1 && status != documentedStatus {
+				continue
+			}
+			if _, raw := documentedBody.(rawJSONBody); raw || bodyName(documentedBody) == body {
+				return true
+			}
+		}
+		return false
+	}
+	for status, bodies := range h.responses {
+		for body := range bodies {
+			if !documented(status, body) {
+				problems = append(problems, fmt.Sprintf("writes undocumented response %d %q", status, body))
+			}
+		}
+	}
+	for status, body := range op.Responses {
+		// Upgrades are answered by the WebSocket upgrader
+		if _, raw := body.(rawJSONBody); raw || status == http.StatusSwitchingProtocols {
+			continue
+		}
+		if !h.responses[status][bodyName(body)] && !h.responses[-1][bodyName(body)] {
+			problems = append(problems, fmt.Sprintf("documented response %d %q is never written", status, bodyName(body)))
+		}
+	}
+	return problems
+}
+
+func bodyName(body interface{}) string {
+	if body == nil {
+		return ""
+	}
+	return reflect.TypeOf(body).Name()
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Type-checked package source, used to follow handlers into the helpers
+// they pass their *gin.Context to
+type handlerSource struct {
+	info  *types.Info
+	funcs map[string]*ast.FuncDecl
+	cache map[string]*handlerContract
+}
+
+// Helpers writing error responses, which are documented as the default
+// response of every operation
+var errorResponders = map[string]bool{"respondError": true, "respondBindError": true}
+
+func loadHandlerContracts() (*handlerSource, error) {
+	fset := token.NewFileSet()
+	paths, err := filepath.Glob("*.go")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*ast.File
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	// Only the standard library is imported, for constants such as
+	// http.StatusOK; other packages are faked, leaving their types invalid
+	std := importer.Default()
+	conf := types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if strings.Contains(strings.Split(path, "/")[0], ".") {
+				return nil, fmt.Errorf("not a standard library package: %s", path)
+			}
+			return std.Import(path)
+		}),
+		Error:       func(error) {},
+		FakeImportC: true,
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	conf.Check("main", fset, files, info)
+
+	source := &handlerSource{info: info, funcs: make(map[string]*ast.FuncDecl), cache: make(map[string]*handlerContract)}
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+				source.funcs[fn.Name.Name] = fn
+			}
+		}
+	}
+	return source, nil
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+// Contract of a handler taking the context as its first parameter
+func (s *handlerSource) analyze(name string) *handlerContract {
+	return s.analyzeParam(name, 0, map[string]bool{})
+}
+
+func (s *handlerSource) analyzeParam(name string, param int, visiting map[string]bool) *handlerContract {
+	key := fmt.Sprintf("%s/%d", name, param)
+	if contract, ok := s.cache[key]; ok {
+		return contract
+	}
+	fn := s.funcs[name]
+	if fn == nil || visiting[key] {
+		return nil
+	}
+	visiting[key] = true
+	defer delete(visiting, key)
+
+	var ctx types.Object
+	index := 0
+	for _, field := range fn.Type.Params.List {
+		for _, ident := range field.Names {
+			if index == param {
+				ctx = s.info.Defs[ident]
+			}
+			index++
+		}
+	}
+	contract := &handlerContract{requests: map[string]bool{}, formFields: map[string]bool{}, responses: map[int]map[string]bool{}}
+	if ctx == nil {
+		return contract
+	}
+
+	isCtx := func(expr ast.Expr) bool {
+		ident, ok := expr.(*ast.Ident)
+		return ok && s.info.Uses[ident] == ctx
+	}
+	addResponse := func(status int, body string) {
+		if contract.responses[status] == nil {
+			contract.responses[status] = map[string]bool{}
+		}
+		contract.responses[status][body] = true
+	}
+
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		if selector, ok := call.Fun.(*ast.SelectorExpr); ok && isCtx(selector.X) {
+			switch selector.Sel.Name {
+			case "ShouldBindJSON", "ShouldBind", "BindJSON":
+				if len(call.Args) == 1 {
+					contract.requests[s.typeName(call.Args[0])] = true
+				}
+			case "PostForm", "FormFile", "FormValue":
+				if len(call.Args) == 1 {
+					if value := s.info.Types[call.Args[0]].Value; value != nil && value.Kind() == constant.String {
+						contract.formFields[constant.StringVal(value)] = true
+					}
+				}
+			case "JSON", "IndentedJSON", "AbortWithStatusJSON":
+				if len(call.Args) == 2 {
+					if body := s.typeName(call.Args[1]); body != "ProblemDetails" {
+						addResponse(s.status(call.Args[0]), body)
+					}
+				}
+			case "Status", "AbortWithStatus":
+				if len(call.Args) == 1 {
+					addResponse(s.status(call.Args[0]), "")
+				}
+			}
+			return true
+		}
+
+		// Follow helpers the context is passed to
+		ident, ok := call.Fun.(*ast.Ident)
+		if !ok || errorResponders[ident.Name] {
+			return true
+		}
+		if _, isFunc := s.info.Uses[ident].(*types.Func); !isFunc {
+			return true
+		}
+		for i, arg := range call.Args {
+			if !isCtx(arg) {
+				continue
+			}
+			if helper := s.analyzeParam(ident.Name, i, visiting); helper != nil {
+				for request := range helper.requests {
+					contract.requests[request] = true
+				}
+				for field := range helper.formFields {
+					contract.formFields[field] = true
+				}
+				for status, bodies := range helper.responses {
+					for body := range bodies {
+						addResponse(status, body)
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	s.cache[key] = contract
+	return contract
+}
+
+// Name of the type of expr, dereferencing pointers
+func (s *handlerSource) typeName(expr ast.Expr) string {
+	typ := s.info.Types[expr].Type
+	for {
+		pointer, ok := typ.(*types.Pointer)
+		if !ok {
+			break
+		}
+		typ = pointer.Elem()
+	}
+	if named, ok := typ.(*types.Named); ok {
+		return named.Obj().Name()
+	}
+	if typ == nil {
+		return "<invalid>"
+	}
+	return typ.String()
+}
+
+func (s *handlerSource) status(expr ast.Expr) int {
+	value := s.info.Types[expr].Value
+	if value == nil || value.Kind() != constant.Int {
+		return -1
+	}
+	status, _ := constant.Int64Val(value)
+	return int(status)
+}
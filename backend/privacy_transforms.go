@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	PSEUDONYM_PREFIX                = "ps_"
+	CHANNEL_CONTRIBUTORS_COLLECTION = "channel_contributors"
+	// Contributors who must share a channel before it is published
+	CHANNEL_K_ANONYMITY = 5
+)
+
+// Granularities timestamps can be bucketed to
+const (
+	TIME_BUCKET_HOUR  = "hour"
+	TIME_BUCKET_DAY   = "day"
+	TIME_BUCKET_WEEK  = "week"
+	TIME_BUCKET_MONTH = "month"
+	TIME_BUCKET_YEAR  = "year"
+)
+
+var (
+	pseudonymKey []byte
+	// Granularity the timestamps masking rule buckets to
+	maskTimeGranularity = TIME_BUCKET_MONTH
+)
+
+var (
+	emailPattern      = regexp.MustCompile(`[\p{L}\p{N}._%+-]+@[\p{L}\p{N}-]+(?:\.[\p{L}\p{N}-]+)*\.\p{L}{2,}`)
+	phonePattern      = regexp.MustCompile(`\+?\(?\d[\d\s().-]{5,}\d`)
+	handlePattern     = regexp.MustCompile(`@[\p{L}\p{N}_.]{2,}`)
+	personNamePattern = regexp.MustCompile(`\p{Lu}\p{Ll}+(?:[ \t]+\p{Lu}\p{Ll}+)+`)
+)
+
+// Load the pseudonymization key and time granularity, and index the
+// channel registry behind the k-anonymity check. The key is required: with
+// a new key after every restart, pseudonyms would stop joining and the
+// channel registry would count returning contributors as new ones.
+func initPrivacyTransforms() {
+	key := getEnvOrDefault("PSEUDONYM_KEY", "")
+	if key == "" {
+		logFatal("PSEUDONYM_KEY environment variable is required")
+	}
+	decoded, err := hex.DecodeString(key)
+	if err != nil || len(decoded) < 32 {
+		logFatal("PSEUDONYM_KEY must be at least 32 hex-encoded bytes")
+	}
+	pseudonymKey = decoded
+
+	granularity := getEnvOrDefault("MASK_TIME_GRANULARITY", TIME_BUCKET_MONTH)
+	if _, err := bucketTime(time.Now(), granularity); err != nil {
+		slog.Error("Invalid MASK_TIME_GRANULARITY, using month", "error", err)
+		granularity = TIME_BUCKET_MONTH
+	}
+	maskTimeGranularity = granularity
+
+	ctx, cancel := context.WithTimeout(context.Background(), EVENT_INDEX_INIT_TIMEOUT)
+	defer cancel()
+
+	_, err = db.Collection(CHANNEL_CONTRIBUTORS_COLLECTION).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "channel", Value: 1}, {Key: "contributor", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		slog.Error("Failed to create channel contributor index", "error", err)
+	}
+}
+
+// Replace an identifier with a keyed HMAC so datasets can still be joined
+// on it without revealing it. kind separates namespaces, e.g. "video" and
+// "channel", so equal strings of different kinds do not collide.
+func pseudonymize(kind, value string) string {
+	if value == "" || isPseudonym(value) {
+		return value
+	}
+	mac := hmac.New(sha256.New, pseudonymKey)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return PSEUDONYM_PREFIX + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func isPseudonym(value string) bool {
+	return strings.HasPrefix(value, PSEUDONYM_PREFIX) && len(value) == len(PSEUDONYM_PREFIX)+22
+}
+
+// Truncate t to the start of its hour, day, ISO week (Monday), month or
+// year in UTC
+func bucketTime(t time.Time, granularity string) (time.Time, error) {
+	t = t.UTC()
+	switch granularity {
+	case TIME_BUCKET_HOUR:
+		return t.Truncate(time.Hour), nil
+	case TIME_BUCKET_DAY:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	case TIME_BUCKET_WEEK:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7), nil
+	case TIME_BUCKET_MONTH:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	case TIME_BUCKET_YEAR:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC), nil
+	default:
+		return t, fmt.Errorf("unknown time granularity %q", granularity)
+	}
+}
+
+// Bucket t at the granularity of the timestamps masking rule
+func maskTime(t time.Time) time.Time {
+	bucketed, _ := bucketTime(t, maskTimeGranularity)
+	return bucketed
+}
+
+// Replace personal data in free text with placeholders: emails, phone
+// numbers, @handles and capitalised name sequences such as "Jane Doe".
+// Name detection is a heuristic and misses names typed in lower case.
+func scrubPII(text string) string {
+	text = emailPattern.ReplaceAllString(text, " <email> ")
+	text = phonePattern.ReplaceAllStringFunc(text, func(match string) string {
+		digits := 0
+		for _, r := range match {
+			if unicode.IsDigit(r) {
+				digits++
+			}
+		}
+		if digits < 7 {
+			return match
+		}
+		return " <phone> "
+	})
+	text = handlePattern.ReplaceAllString(text, " <handle> ")
+	return personNamePattern.ReplaceAllString(text, " <name> ")
+}
+
+// Split a search query into lower-case word tokens after scrubbing PII.
+// Placeholders such as <email> are kept as single tokens.
+func tokenizeSearchQuery(query string) []string {
+	var tokens []string
+	for _, field := range strings.Fields(scrubPII(query)) {
+		if strings.HasPrefix(field, "<") && strings.HasSuffix(field, ">") {
+			tokens = append(tokens, field)
+			continue
+		}
+		tokens = append(tokens, strings.FieldsFunc(strings.ToLower(field), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})...)
+	}
+	return tokens
+}
+
+// Implemented by schemas naming channels, which are checked for
+// k-anonymity before publication
+type channelSetSchema interface {
+	// Pseudonymous keys of the channels the schema names
+	channelKeys() []string
+	// Remove every mention of the channels in rare
+	suppressChannels(rare map[string]bool)
+}
+
+// Registry key of a channel: the pseudonym of its ID, or of its name when
+// the ID is unknown. Pseudonymized IDs are already keys.
+func channelKey(channelID, channelName string) string {
+	if channelID != "" {
+		return pseudonymize("channel", channelID)
+	}
+	if channelName != "" && channelName != "[MASKED]" {
+		return pseudonymize("channelName", strings.ToLower(channelName))
+	}
+	return ""
+}
+
+// Outcome of a k-anonymity check, applied to every masked variant
+type channelKAnonymity struct {
+	k        int
+	channels int
+	rare     map[string]bool
+}
+
+// Find the channels shared by fewer than CHANNEL_K_ANONYMITY contributors,
+// counting this one, so a dataset cannot single its contributor out by an
+// obscure channel. The contributor's channels are registered first, so
+// channels become publishable as more contributors share them. Each
+// channel is checked on its own; rare combinations of common channels are
+// not detected. Returns nil for schemas without channels.
+func checkChannelKAnonymity(ctx context.Context, schema RefinedSchema) (*channelKAnonymity, error) {
+	channels, ok := schema.(channelSetSchema)
+	k := getEnvInt("CHANNEL_K_ANONYMITY", CHANNEL_K_ANONYMITY)
+	if !ok || k <= 1 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	var keys []string
+	for _, key := range channels.channelKeys() {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	contributor := pseudonymize("contributor", strings.ToLower(schema.envelope().Contributor))
+	registry := db.Collection(CHANNEL_CONTRIBUTORS_COLLECTION)
+
+	writes := make([]mongo.WriteModel, len(keys))
+	for i, key := range keys {
+		filter := bson.M{"channel": key, "contributor": contributor}
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(bson.M{"$setOnInsert": bson.M{"firstSeenAt": time.Now()}}).
+			SetUpsert(true)
+	}
+	if _, err := registry.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return nil, fmt.Errorf("failed to register channels: %v", err)
+	}
+
+	cursor, err := registry.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"channel": bson.M{"$in": keys}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$channel", "contributors": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count channel contributors: %v", err)
+	}
+	var counts []struct {
+		Channel      string `bson:"_id"`
+		Contributors int    `bson:"contributors"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, fmt.Errorf("failed to count channel contributors: %v", err)
+	}
+
+	rare := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		rare[key] = true
+	}
+	for _, count := range counts {
+		if count.Contributors >= k {
+			delete(rare, count.Channel)
+		}
+	}
+	return &channelKAnonymity{k: k, channels: len(keys), rare: rare}, nil
+}
+
+// Suppress the rare channels in a masked variant and record the check
+func (c *channelKAnonymity) apply(schema RefinedSchema) {
+	channels, ok := schema.(channelSetSchema)
+	if c == nil || !ok {
+		return
+	}
+	channels.suppressChannels(c.rare)
+	schema.envelope().Metadata["kAnonymity"] = map[string]interface{}{
+		"k":                  c.k,
+		"channels":           c.channels,
+		"suppressedChannels": len(c.rare),
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Medium engagement events and the action they record
+var mediumInteractionTypes = map[string]string{
+	"article_clap":     "clap",
+	"author_follow":    "follow",
+	"article_bookmark": "bookmark",
+	"article_share":    "share",
+	"text_highlight":   "highlight",
+	"article_comment":  "comment",
+}
+
+func init() {
+	eventTypes := map[string]bool{"article_view": true, "article_exit": true, "reading_progress": true}
+	for eventType := range mediumInteractionTypes {
+		eventTypes[eventType] = true
+	}
+
+	registerDataSchema(DataSchema{
+		DataType:     "medium_reading",
+		Platform:     "medium",
+		Description:  "Medium articles read, reading depth, claps and follows captured by the extension",
+		MaskingRules: []string{"titles", "authors", "timestamps"},
+		Validate: func(rawData interface{}) error {
+			return validateExportedEvents(rawData, "Medium", eventTypes)
+		},
+		Normalize: func(rawData interface{}, contributor string) (RefinedSchema, error) {
+			return normalizeMediumData(rawData, contributor)
+		},
+		Mask: func(schema RefinedSchema, maskingRules map[string]bool) RefinedSchema {
+			return maskMediumData(schema.(*MediumDataSchema), maskingRules)
+		},
+		Quality: func(schema RefinedSchema) float64 {
+			return calculateMediumQuality(schema.(*MediumDataSchema))
+		},
+	})
+}
+
+type MediumDataSchema struct {
+	SchemaEnvelope `bson:",inline"`
+	ArticleReads   []MediumArticleRead `json:"articleReads,omitempty"`
+	Interactions   []MediumInteraction `json:"interactions,omitempty"`
+}
+
+type MediumArticleRead struct {
+	Title              string    `json:"title"`
+	Author             string    `json:"author,omitempty"`
+	Publication        string    `json:"publication,omitempty"`
+	WordCount          int       `json:"wordCount,omitempty"`
+	HasPaywall         bool      `json:"hasPaywall"`
+	ReadingTimeSeconds int       `json:"readingTimeSeconds"`
+	MaxScrollDepth     int       `json:"maxScrollDepth"`
+	Completed          bool      `json:"completed"`
+	ReadAt             time.Time `json:"readAt"`
+}
+
+type MediumInteraction struct {
+	Action    string    `json:"action"`
+	Title     string    `json:"title,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Normalize Medium events of an extension export. article_view opens a
+// read; reading_progress and article_exit for the same title update it.
+func normalizeMediumData(rawData interface{}, contributor string) (*MediumDataSchema, error) {
+	events, err := exportedEvents(rawData)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &MediumDataSchema{SchemaEnvelope: newSchemaEnvelope("medium_reading", contributor)}
+	openReads := make(map[string]int)
+
+	for _, event := range events {
+		title := strings.TrimSpace(firstString(event.Fields, "title"))
+		author := strings.TrimSpace(firstString(event.Fields, "author"))
+
+		switch event.EventType {
+		case "article_view":
+			if title == "" {
+				continue
+			}
+			openReads[title] = len(schema.ArticleReads)
+			schema.ArticleReads = append(schema.ArticleReads, MediumArticleRead{
+				Title:       title,
+				Author:      author,
+				Publication: strings.TrimSpace(firstString(event.Fields, "publication")),
+				WordCount:   fieldInt(event.Fields, "word_count"),
+				HasPaywall:  fieldBool(event.Fields, "has_paywall"),
+				ReadAt:      event.Timestamp,
+			})
+		case "reading_progress", "article_exit":
+			index, ok := openReads[title]
+			if !ok {
+				continue
+			}
+			read := &schema.ArticleReads[index]
+			if seconds := fieldInt(event.Fields, "reading_time_seconds"); seconds > read.ReadingTimeSeconds {
+				read.ReadingTimeSeconds = seconds
+			}
+			for _, key := range []string{"max_scroll_depth", "progress_percentage"} {
+				if depth := fieldInt(event.Fields, key); depth > read.MaxScrollDepth {
+					read.MaxScrollDepth = depth
+				}
+			}
+			if fieldBool(event.Fields, "completed") {
+				read.Completed = true
+			}
+		default:
+			if action, ok := mediumInteractionTypes[event.EventType]; ok {
+				schema.Interactions = append(schema.Interactions, MediumInteraction{
+					Action:    action,
+					Title:     title,
+					Author:    author,
+					Timestamp: event.Timestamp,
+				})
+			}
+		}
+	}
+
+	totalReadingTime := 0
+	for _, read := range schema.ArticleReads {
+		totalReadingTime += read.ReadingTimeSeconds
+	}
+
+	schema.Metadata["totalArticleReads"] = len(schema.ArticleReads)
+	schema.Metadata["totalInteractions"] = len(schema.Interactions)
+	schema.Metadata["totalReadingTimeSeconds"] = totalReadingTime
+	schema.Metadata["processedAt"] = time.Now().Unix()
+
+	return schema, nil
+}
+
+// Deep copy for masking
+func (s *MediumDataSchema) clone() *MediumDataSchema {
+	return &MediumDataSchema{
+		SchemaEnvelope: s.SchemaEnvelope.clone(),
+		ArticleReads:   slices.Clone(s.ArticleReads),
+		Interactions:   slices.Clone(s.Interactions),
+	}
+}
+
+// Return a masked copy of schema; schema itself is left untouched
+func maskMediumData(schema *MediumDataSchema, maskingRules map[string]bool) *MediumDataSchema {
+	masked := schema.clone()
+
+	if maskingRules["titles"] {
+		for i := range masked.ArticleReads {
+			masked.ArticleReads[i].Title = "[MASKED]"
+		}
+		for i := range masked.Interactions {
+			masked.Interactions[i].Title = "[MASKED]"
+		}
+	}
+
+	if maskingRules["authors"] {
+		for i := range masked.ArticleReads {
+			masked.ArticleReads[i].Author = "[MASKED]"
+			masked.ArticleReads[i].Publication = "[MASKED]"
+		}
+		for i := range masked.Interactions {
+			masked.Interactions[i].Author = "[MASKED]"
+		}
+	}
+
+	if maskingRules["timestamps"] {
+		for i := range masked.ArticleReads {
+			masked.ArticleReads[i].ReadAt = maskTime(masked.ArticleReads[i].ReadAt)
+		}
+		for i := range masked.Interactions {
+			masked.Interactions[i].Timestamp = maskTime(masked.Interactions[i].Timestamp)
+		}
+	}
+
+	return masked
+}
+
+func calculateMediumQuality(schema *MediumDataSchema) float64 {
+	score := 0.0
+	maxScore := 5.0
+
+	if len(schema.ArticleReads) > 0 {
+		score += 1.0
+		if len(schema.ArticleReads) > 50 {
+			score += 0.5 // Bonus for substantial data
+		}
+	}
+
+	if len(schema.Interactions) > 0 {
+		score += 1.0
+	}
+
+	hasReadingTime := false
+	hasTimestamps := false
+	hasAuthors := false
+	for _, read := range schema.ArticleReads {
+		if read.ReadingTimeSeconds > 0 {
+			hasReadingTime = true
+		}
+		if !read.ReadAt.IsZero() {
+			hasTimestamps = true
+		}
+		if read.Author != "" {
+			hasAuthors = true
+		}
+	}
+
+	if hasReadingTime {
+		score += 1.0
+	}
+	if hasTimestamps {
+		score += 1.0
+	}
+	if hasAuthors {
+		score += 0.5
+	}
+
+	return (score / maxScore) * 10
+}
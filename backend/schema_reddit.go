@@ -0,0 +1,199 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// Reddit engagement events and the action they record
+var redditInteractionTypes = map[string]string{
+	"post_upvote":    "upvote",
+	"post_downvote":  "downvote",
+	"post_save":      "save",
+	"post_share":     "share",
+	"post_award":     "award",
+	"subreddit_join": "join",
+}
+
+func init() {
+	eventTypes := map[string]bool{"post_view": true, "post_click": true, "reddit_navigation": true}
+	for eventType := range redditInteractionTypes {
+		eventTypes[eventType] = true
+	}
+
+	registerDataSchema(DataSchema{
+		DataType:     "reddit_activity",
+		Platform:     "reddit",
+		Description:  "Reddit posts viewed, votes, saves and subreddit memberships captured by the extension",
+		MaskingRules: []string{"subreddits", "postIds", "timestamps"},
+		Validate: func(rawData interface{}) error {
+			return validateExportedEvents(rawData, "Reddit", eventTypes)
+		},
+		Normalize: func(rawData interface{}, contributor string) (RefinedSchema, error) {
+			return normalizeRedditData(rawData, contributor)
+		},
+		Mask: func(schema RefinedSchema, maskingRules map[string]bool) RefinedSchema {
+			return maskRedditData(schema.(*RedditDataSchema), maskingRules)
+		},
+		Quality: func(schema RefinedSchema) float64 {
+			return calculateRedditQuality(schema.(*RedditDataSchema))
+		},
+	})
+}
+
+type RedditDataSchema struct {
+	SchemaEnvelope `bson:",inline"`
+	PostViews      []RedditPostView    `json:"postViews,omitempty"`
+	Interactions   []RedditInteraction `json:"interactions,omitempty"`
+	Subreddits     []string            `json:"subreddits,omitempty"`
+}
+
+type RedditPostView struct {
+	PostID    string    `json:"postId"`
+	Subreddit string    `json:"subreddit,omitempty"`
+	PostType  string    `json:"postType,omitempty"`
+	HasMedia  bool      `json:"hasMedia"`
+	Comments  int       `json:"comments,omitempty"`
+	ViewedAt  time.Time `json:"viewedAt"`
+}
+
+type RedditInteraction struct {
+	Action    string    `json:"action"`
+	PostID    string    `json:"postId,omitempty"`
+	Subreddit string    `json:"subreddit,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Normalize Reddit events of an extension export
+func normalizeRedditData(rawData interface{}, contributor string) (*RedditDataSchema, error) {
+	events, err := exportedEvents(rawData)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &RedditDataSchema{SchemaEnvelope: newSchemaEnvelope("reddit_activity", contributor)}
+	subreddits := make(map[string]bool)
+
+	for _, event := range events {
+		subreddit := firstString(event.Fields, "subreddit")
+		if subreddit != "" {
+			subreddits[subreddit] = true
+		}
+
+		if event.EventType == "post_view" {
+			schema.PostViews = append(schema.PostViews, RedditPostView{
+				PostID:    firstString(event.Fields, "post_id"),
+				Subreddit: subreddit,
+				PostType:  firstString(event.Fields, "post_type"),
+				HasMedia:  fieldBool(event.Fields, "has_media"),
+				Comments:  fieldInt(event.Fields, "comments"),
+				ViewedAt:  event.Timestamp,
+			})
+		} else if action, ok := redditInteractionTypes[event.EventType]; ok {
+			schema.Interactions = append(schema.Interactions, RedditInteraction{
+				Action:    action,
+				PostID:    firstString(event.Fields, "post_id"),
+				Subreddit: subreddit,
+				Timestamp: event.Timestamp,
+			})
+		}
+	}
+
+	for subreddit := range subreddits {
+		schema.Subreddits = append(schema.Subreddits, subreddit)
+	}
+	sort.Strings(schema.Subreddits)
+
+	schema.Metadata["totalPostViews"] = len(schema.PostViews)
+	schema.Metadata["totalInteractions"] = len(schema.Interactions)
+	schema.Metadata["totalSubreddits"] = len(schema.Subreddits)
+	schema.Metadata["processedAt"] = time.Now().Unix()
+
+	return schema, nil
+}
+
+// Deep copy for masking
+func (s *RedditDataSchema) clone() *RedditDataSchema {
+	return &RedditDataSchema{
+		SchemaEnvelope: s.SchemaEnvelope.clone(),
+		PostViews:      slices.Clone(s.PostViews),
+		Interactions:   slices.Clone(s.Interactions),
+		Subreddits:     slices.Clone(s.Subreddits),
+	}
+}
+
+// Return a masked copy of schema; schema itself is left untouched
+func maskRedditData(schema *RedditDataSchema, maskingRules map[string]bool) *RedditDataSchema {
+	masked := schema.clone()
+
+	if maskingRules["subreddits"] {
+		for i := range masked.PostViews {
+			masked.PostViews[i].Subreddit = "[MASKED]"
+		}
+		for i := range masked.Interactions {
+			masked.Interactions[i].Subreddit = "[MASKED]"
+		}
+		masked.Subreddits = nil
+	}
+
+	if maskingRules["postIds"] {
+		for i := range masked.PostViews {
+			masked.PostViews[i].PostID = "[MASKED]"
+		}
+		for i := range masked.Interactions {
+			masked.Interactions[i].PostID = "[MASKED]"
+		}
+	}
+
+	if maskingRules["timestamps"] {
+		for i := range masked.PostViews {
+			masked.PostViews[i].ViewedAt = maskTime(masked.PostViews[i].ViewedAt)
+		}
+		for i := range masked.Interactions {
+			masked.Interactions[i].Timestamp = maskTime(masked.Interactions[i].Timestamp)
+		}
+	}
+
+	return masked
+}
+
+func calculateRedditQuality(schema *RedditDataSchema) float64 {
+	score := 0.0
+	maxScore := 5.0
+
+	if len(schema.PostViews) > 0 {
+		score += 1.0
+		if len(schema.PostViews) > 100 {
+			score += 0.5 // Bonus for substantial data
+		}
+	}
+
+	if len(schema.Interactions) > 0 {
+		score += 1.0
+	}
+
+	if len(schema.Subreddits) > 0 {
+		score += 1.0
+	}
+
+	hasTimestamps := false
+	hasPostTypes := false
+	for _, view := range schema.PostViews {
+		if !view.ViewedAt.IsZero() {
+			hasTimestamps = true
+		}
+		if view.PostType != "" && view.PostType != "unknown" {
+			hasPostTypes = true
+		}
+	}
+
+	if hasTimestamps {
+		score += 1.0
+	}
+	if hasPostTypes {
+		score += 0.5
+	}
+
+	return (score / maxScore) * 10
+}
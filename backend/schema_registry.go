@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Data type refined when a client sends a MIME type instead of a
+// registered DataType, as older web app versions do
+const DEFAULT_DATA_TYPE = "youtube_takeout"
+
+// Fields every refined dataset starts with
+type SchemaEnvelope struct {
+	Version     string                 `json:"version"`
+	Contributor string                 `json:"contributor"`
+	Timestamp   int64                  `json:"timestamp"`
+	DataType    string                 `json:"dataType"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+func (e *SchemaEnvelope) envelope() *SchemaEnvelope {
+	return e
+}
+
+// Copy with its own Metadata map
+func (e SchemaEnvelope) clone() SchemaEnvelope {
+	e.Metadata = maps.Clone(e.Metadata)
+	return e
+}
+
+func newSchemaEnvelope(dataType, contributor string) SchemaEnvelope {
+	return SchemaEnvelope{
+		Version:     "1.0",
+		Contributor: contributor,
+		Timestamp:   time.Now().Unix(),
+		DataType:    dataType,
+		Metadata:    make(map[string]interface{}),
+	}
+}
+
+// Normalized dataset of one platform. Implemented by pointers to schema
+// structs embedding SchemaEnvelope.
+type RefinedSchema interface {
+	envelope() *SchemaEnvelope
+}
+
+// Refinement steps of one DataType
+type DataSchema struct {
+	DataType    string
+	Platform    string
+	Description string
+
+	// Masking rule names Mask understands, e.g. "titles"
+	MaskingRules []string
+
+	// Reject uploads the normalizer cannot handle
+	Validate func(rawData interface{}) error
+	// Map raw uploaded data into the platform schema
+	Normalize func(rawData interface{}, contributor string) (RefinedSchema, error)
+	// Apply the enabled masking rules
+	Mask func(schema RefinedSchema, maskingRules map[string]bool) RefinedSchema
+	// Score the normalized data from 0 to 10
+	Quality func(schema RefinedSchema) float64
+}
+
+// Public description of a registered schema
+type DataSchemaInfo struct {
+	DataType     string   `json:"dataType"`
+	Platform     string   `json:"platform"`
+	Description  string   `json:"description"`
+	MaskingRules []string `json:"maskingRules"`
+}
+
+type DataSchemasResponse struct {
+	Data []DataSchemaInfo `json:"data"`
+}
+
+var dataSchemas = make(map[string]*DataSchema)
+
+// Register the refinement steps of a DataType. Called from init functions;
+// panics on incomplete or duplicate registrations.
+func registerDataSchema(schema DataSchema) {
+	if schema.DataType == "" || schema.Validate == nil || schema.Normalize == nil || schema.Mask == nil || schema.Quality == nil {
+		panic(fmt.Sprintf("incomplete data schema registration for %q", schema.DataType))
+	}
+	if _, exists := dataSchemas[schema.DataType]; exists {
+		panic(fmt.Sprintf("data schema %q registered twice", schema.DataType))
+	}
+	dataSchemas[schema.DataType] = &schema
+}
+
+// Find the schema for dataType, mapping MIME types sent by older clients
+// to DEFAULT_DATA_TYPE
+func lookupDataSchema(dataType string) (*DataSchema, error) {
+	if schema, ok := dataSchemas[dataType]; ok {
+		return schema, nil
+	}
+	if strings.Contains(dataType, "/") {
+		return dataSchemas[DEFAULT_DATA_TYPE], nil
+	}
+	return nil, fmt.Errorf("unsupported data type %q (supported: %s)", dataType, strings.Join(registeredDataTypes(), ", "))
+}
+
+func registeredDataTypes() []string {
+	dataTypes := make([]string, 0, len(dataSchemas))
+	for dataType := range dataSchemas {
+		dataTypes = append(dataTypes, dataType)
+	}
+	sort.Strings(dataTypes)
+	return dataTypes
+}
+
+// List the data types accepted by /api/upload-data
+func listDataSchemas(c *gin.Context) {
+	schemas := make([]DataSchemaInfo, 0, len(dataSchemas))
+	for _, dataType := range registeredDataTypes() {
+		schema := dataSchemas[dataType]
+		schemas = append(schemas, DataSchemaInfo{
+			DataType:     schema.DataType,
+			Platform:     schema.Platform,
+			Description:  schema.Description,
+			MaskingRules: schema.MaskingRules,
+		})
+	}
+
+	c.JSON(http.StatusOK, DataSchemasResponse{Data: schemas})
+}
+
+// Extension event as found in uploaded exports, flattened so adapter
+// fields sit next to the envelope. Accepts both the local snake_case layout
+// and the upload layout with adapter fields under eventData.
+type exportedEvent struct {
+	EventType string
+	Site      string
+	Timestamp time.Time
+	Fields    map[string]interface{}
+}
+
+// Read the "events" array of an extension export
+func exportedEvents(rawData interface{}) ([]exportedEvent, error) {
+	data, ok := rawData.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid data format")
+	}
+	rawEvents, ok := data["events"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("missing events array")
+	}
+
+	events := make([]exportedEvent, 0, len(rawEvents))
+	for _, rawEvent := range rawEvents {
+		fields, ok := rawEvent.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if eventData, ok := fields["eventData"].(map[string]interface{}); ok {
+			merged := make(map[string]interface{}, len(fields)+len(eventData))
+			for key, value := range fields {
+				merged[key] = value
+			}
+			for key, value := range eventData {
+				merged[key] = value
+			}
+			fields = merged
+		}
+
+		event := exportedEvent{
+			EventType: firstString(fields, "event_type", "eventType"),
+			Site:      strings.TrimPrefix(strings.ToLower(firstString(fields, "site", "pageDomain")), "www."),
+			Fields:    fields,
+		}
+		if timestamp, err := time.Parse(time.RFC3339Nano, firstString(fields, "timestamp")); err == nil {
+			event.Timestamp = timestamp.UTC()
+		}
+		if event.EventType != "" {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Require an extension export holding at least one event of eventTypes
+func validateExportedEvents(rawData interface{}, platform string, eventTypes map[string]bool) error {
+	events, err := exportedEvents(rawData)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if eventTypes[event.EventType] {
+			return nil
+		}
+	}
+	return fmt.Errorf("no %s events found", platform)
+}
+
+func firstString(fields map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := fields[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func fieldInt(fields map[string]interface{}, key string) int {
+	if value, ok := fields[key].(float64); ok {
+		return int(value)
+	}
+	return 0
+}
+
+func fieldBool(fields map[string]interface{}, key string) bool {
+	value, _ := fields[key].(bool)
+	return value
+}
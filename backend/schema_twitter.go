@@ -0,0 +1,209 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Twitter/X engagement events and the action they record. Follows are kept
+// separately.
+var twitterInteractionTypes = map[string]string{
+	"tweet_like":     "like",
+	"tweet_retweet":  "retweet",
+	"tweet_reply":    "reply",
+	"tweet_share":    "share",
+	"tweet_bookmark": "bookmark",
+}
+
+func init() {
+	eventTypes := map[string]bool{"tweet_view": true, "tweet_click": true, "twitter_navigation": true, "user_follow": true}
+	for eventType := range twitterInteractionTypes {
+		eventTypes[eventType] = true
+	}
+
+	registerDataSchema(DataSchema{
+		DataType:     "twitter_activity",
+		Platform:     "twitter",
+		Description:  "Twitter/X tweets viewed, likes, retweets and follows captured by the extension",
+		MaskingRules: []string{"authors", "tweetIds", "timestamps"},
+		Validate: func(rawData interface{}) error {
+			return validateExportedEvents(rawData, "Twitter/X", eventTypes)
+		},
+		Normalize: func(rawData interface{}, contributor string) (RefinedSchema, error) {
+			return normalizeTwitterData(rawData, contributor)
+		},
+		Mask: func(schema RefinedSchema, maskingRules map[string]bool) RefinedSchema {
+			return maskTwitterData(schema.(*TwitterDataSchema), maskingRules)
+		},
+		Quality: func(schema RefinedSchema) float64 {
+			return calculateTwitterQuality(schema.(*TwitterDataSchema))
+		},
+	})
+}
+
+type TwitterDataSchema struct {
+	SchemaEnvelope `bson:",inline"`
+	TweetViews     []TweetView        `json:"tweetViews,omitempty"`
+	Interactions   []TweetInteraction `json:"interactions,omitempty"`
+	Follows        []TwitterFollow    `json:"follows,omitempty"`
+}
+
+type TweetView struct {
+	TweetID   string    `json:"tweetId"`
+	Author    string    `json:"author,omitempty"`
+	HasMedia  bool      `json:"hasMedia"`
+	IsReply   bool      `json:"isReply"`
+	IsRetweet bool      `json:"isRetweet"`
+	ViewedAt  time.Time `json:"viewedAt"`
+}
+
+type TweetInteraction struct {
+	Action    string    `json:"action"`
+	TweetID   string    `json:"tweetId,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type TwitterFollow struct {
+	Author     string    `json:"author"`
+	FollowedAt time.Time `json:"followedAt"`
+}
+
+// Normalize Twitter/X events of an extension export
+func normalizeTwitterData(rawData interface{}, contributor string) (*TwitterDataSchema, error) {
+	events, err := exportedEvents(rawData)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &TwitterDataSchema{SchemaEnvelope: newSchemaEnvelope("twitter_activity", contributor)}
+	authors := make(map[string]bool)
+
+	for _, event := range events {
+		author := strings.TrimSpace(firstString(event.Fields, "tweet_author"))
+		if author != "" {
+			authors[author] = true
+		}
+
+		if event.EventType == "tweet_view" {
+			schema.TweetViews = append(schema.TweetViews, TweetView{
+				TweetID:   firstString(event.Fields, "tweet_id"),
+				Author:    author,
+				HasMedia:  fieldBool(event.Fields, "has_media"),
+				IsReply:   fieldBool(event.Fields, "is_reply"),
+				IsRetweet: fieldBool(event.Fields, "is_retweet"),
+				ViewedAt:  event.Timestamp,
+			})
+		} else if event.EventType == "user_follow" {
+			if author != "" {
+				schema.Follows = append(schema.Follows, TwitterFollow{Author: author, FollowedAt: event.Timestamp})
+			}
+		} else if action, ok := twitterInteractionTypes[event.EventType]; ok {
+			schema.Interactions = append(schema.Interactions, TweetInteraction{
+				Action:    action,
+				TweetID:   firstString(event.Fields, "tweet_id"),
+				Author:    author,
+				Timestamp: event.Timestamp,
+			})
+		}
+	}
+
+	schema.Metadata["totalTweetViews"] = len(schema.TweetViews)
+	schema.Metadata["totalInteractions"] = len(schema.Interactions)
+	schema.Metadata["totalFollows"] = len(schema.Follows)
+	schema.Metadata["uniqueAuthors"] = len(authors)
+	schema.Metadata["processedAt"] = time.Now().Unix()
+
+	return schema, nil
+}
+
+// Deep copy for masking
+func (s *TwitterDataSchema) clone() *TwitterDataSchema {
+	return &TwitterDataSchema{
+		SchemaEnvelope: s.SchemaEnvelope.clone(),
+		TweetViews:     slices.Clone(s.TweetViews),
+		Interactions:   slices.Clone(s.Interactions),
+		Follows:        slices.Clone(s.Follows),
+	}
+}
+
+// Return a masked copy of schema; schema itself is left untouched
+func maskTwitterData(schema *TwitterDataSchema, maskingRules map[string]bool) *TwitterDataSchema {
+	masked := schema.clone()
+
+	if maskingRules["authors"] {
+		for i := range masked.TweetViews {
+			masked.TweetViews[i].Author = "[MASKED]"
+		}
+		for i := range masked.Interactions {
+			masked.Interactions[i].Author = "[MASKED]"
+		}
+		for i := range masked.Follows {
+			masked.Follows[i].Author = "[MASKED]"
+		}
+	}
+
+	if maskingRules["tweetIds"] {
+		for i := range masked.TweetViews {
+			masked.TweetViews[i].TweetID = "[MASKED]"
+		}
+		for i := range masked.Interactions {
+			masked.Interactions[i].TweetID = "[MASKED]"
+		}
+	}
+
+	if maskingRules["timestamps"] {
+		for i := range masked.TweetViews {
+			masked.TweetViews[i].ViewedAt = maskTime(masked.TweetViews[i].ViewedAt)
+		}
+		for i := range masked.Interactions {
+			masked.Interactions[i].Timestamp = maskTime(masked.Interactions[i].Timestamp)
+		}
+		for i := range masked.Follows {
+			masked.Follows[i].FollowedAt = maskTime(masked.Follows[i].FollowedAt)
+		}
+	}
+
+	return masked
+}
+
+func calculateTwitterQuality(schema *TwitterDataSchema) float64 {
+	score := 0.0
+	maxScore := 5.0
+
+	if len(schema.TweetViews) > 0 {
+		score += 1.0
+		if len(schema.TweetViews) > 100 {
+			score += 0.5 // Bonus for substantial data
+		}
+	}
+
+	if len(schema.Interactions) > 0 {
+		score += 1.0
+	}
+
+	if len(schema.Follows) > 0 {
+		score += 1.0
+	}
+
+	hasTimestamps := false
+	hasAuthors := false
+	for _, view := range schema.TweetViews {
+		if !view.ViewedAt.IsZero() {
+			hasTimestamps = true
+		}
+		if view.Author != "" {
+			hasAuthors = true
+		}
+	}
+
+	if hasTimestamps {
+		score += 1.0
+	}
+	if hasAuthors {
+		score += 0.5
+	}
+
+	return (score / maxScore) * 10
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	WATCH_SESSIONS_COLLECTION = "watch_sessions"
+	JOB_STATE_COLLECTION      = "job_state"
+	SESSIONIZER_STATE_ID      = "sessionizer"
+
+	SESSIONIZE_INTERVAL         = 5 * time.Minute
+	SESSIONIZE_SETTLE_DELAY     = time.Minute
+	WATCH_SESSION_IDLE_GAP      = 30 * time.Minute
+	WATCH_COMPLETE_PERCENT      = 95.0
+	MAX_EVENTS_PER_SESSION_SCAN = 20000
+)
+
+// Categories whose events carry eventData.videoId
+var videoEventCategories = bson.A{"playback", "ad", "engagement", "navigation"}
+
+// Fields of a stored event the sessionizer reads
+type sessionEvent struct {
+	EventType string    `bson:"eventType"`
+	Timestamp time.Time `bson:"timestamp"`
+	Data      struct {
+		VideoID            string   `bson:"videoId"`
+		ChannelID          string   `bson:"channelId"`
+		ChannelName        string   `bson:"channelName"`
+		CurrentTimeSeconds *float64 `bson:"currentTimeSeconds"`
+		ProgressSeconds    *float64 `bson:"progressSeconds"`
+		DurationSeconds    *float64 `bson:"durationSeconds"`
+	} `bson:"eventData"`
+}
+
+// Sessionizer watermark: events ingested up to ProcessedUntil are reflected
+// in watch_sessions
+type sessionizerState struct {
+	ID             string    `bson:"_id"`
+	ProcessedUntil time.Time `bson:"processedUntil"`
+}
+
+// Create the watch session indexes and schedule the sessionizer.
+// SESSIONIZE_INTERVAL=0 disables the periodic run.
+func initSessionizer() {
+	ctx, cancel := context.WithTimeout(context.Background(), EVENT_INDEX_INIT_TIMEOUT)
+	defer cancel()
+
+	_, err := db.Collection(WATCH_SESSIONS_COLLECTION).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "address", Value: 1},
+				{Key: "sessionId", Value: 1},
+				{Key: "videoId", Value: 1},
+				{Key: "watchTime", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "address", Value: 1}, {Key: "watchTime", Value: -1}}},
+	})
+	if err != nil {
+		slog.Error("Failed to create watch session indexes", "error", err)
+	}
+
+	_, err = db.Collection(USER_EVENTS_COLLECTION).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "createdAt", Value: 1}},
+	})
+	if err != nil {
+		slog.Error("Failed to create event ingestion time index", "error", err)
+	}
+
+	schedulePeriodicJob("sessionize-events", getEnvDuration("SESSIONIZE_INTERVAL", SESSIONIZE_INTERVAL), func(ctx context.Context) error {
+		_, err := sessionizeEvents(ctx)
+		return err
+	})
+}
+
+// Rebuild the watch sessions of every (address, sessionId) that received
+// events since the last run. Sessions are rebuilt from all of their events,
+// so late or retried uploads are folded in and re-running is harmless.
+func sessionizeEvents(ctx context.Context) (int, error) {
+	states := db.Collection(JOB_STATE_COLLECTION)
+
+	var state sessionizerState
+	err := states.FindOne(ctx, bson.M{"_id": SESSIONIZER_STATE_ID}).Decode(&state)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, fmt.Errorf("failed to load sessionizer state: %v", err)
+	}
+
+	// Events are stamped before they are written, so leave in-flight
+	// inserts for the next run
+	until := time.Now().UTC().Add(-SESSIONIZE_SETTLE_DELAY)
+	if !until.After(state.ProcessedUntil) {
+		return 0, nil
+	}
+
+	cursor, err := db.Collection(USER_EVENTS_COLLECTION).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"createdAt": bson.M{"$gt": state.ProcessedUntil, "$lte": until},
+			"category":  bson.M{"$in": videoEventCategories},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"address": "$address", "sessionId": "$sessionId"}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find updated sessions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	total := 0
+	for cursor.Next(ctx) {
+		var group struct {
+			Key struct {
+				Address   string `bson:"address"`
+				SessionID string `bson:"sessionId"`
+			} `bson:"_id"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return total, fmt.Errorf("failed to decode session key: %v", err)
+		}
+
+		count, err := rebuildWatchSessions(ctx, group.Key.Address, group.Key.SessionID)
+		if err != nil {
+			return total, err
+		}
+		total += count
+	}
+	if err := cursor.Err(); err != nil {
+		return total, fmt.Errorf("failed to iterate updated sessions: %v", err)
+	}
+
+	_, err = states.UpdateOne(ctx,
+		bson.M{"_id": SESSIONIZER_STATE_ID},
+		bson.M{"$set": bson.M{"processedUntil": until}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return total, fmt.Errorf("failed to save sessionizer state: %v", err)
+	}
+
+	loggerFromContext(ctx).Info("Sessionized events", "sessions", total, "processedUntil", until)
+	return total, nil
+}
+
+// Replace the stored watch sessions of one extension session with ones
+// rebuilt from its events
+func rebuildWatchSessions(ctx context.Context, address, sessionID string) (int, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(MAX_EVENTS_PER_SESSION_SCAN).
+		SetProjection(bson.M{"eventType": 1, "timestamp": 1, "eventData": 1})
+	cursor, err := db.Collection(USER_EVENTS_COLLECTION).Find(ctx, bson.M{
+		"address":           address,
+		"sessionId":         sessionID,
+		"category":          bson.M{"$in": videoEventCategories},
+		"eventData.videoId": bson.M{"$type": "string"},
+	}, findOptions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load events of session %s: %v", sessionID, err)
+	}
+	defer cursor.Close(ctx)
+
+	var events []sessionEvent
+	for cursor.Next(ctx) {
+		var event sessionEvent
+		if err := cursor.Decode(&event); err != nil {
+			// Migrated legacy events may not match the typed payloads
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read events of session %s: %v", sessionID, err)
+	}
+
+	sessions := buildWatchSessions(address, sessionID, events)
+	collection := db.Collection(WATCH_SESSIONS_COLLECTION)
+	now := time.Now()
+
+	keys := bson.A{}
+	for _, session := range sessions {
+		session.UpdatedAt = now
+		key := bson.M{"address": address, "sessionId": sessionID, "videoId": session.VideoID, "watchTime": session.WatchTime}
+		keys = append(keys, bson.M{"videoId": session.VideoID, "watchTime": session.WatchTime})
+
+		_, err := collection.UpdateOne(ctx, key, bson.M{"$set": session}, options.Update().SetUpsert(true))
+		if err != nil {
+			return 0, fmt.Errorf("failed to store watch session: %v", err)
+		}
+	}
+
+	// Drop sessions that no longer exist, e.g. after a late event merged two
+	stale := bson.M{"address": address, "sessionId": sessionID}
+	if len(keys) > 0 {
+		stale["$nor"] = keys
+	}
+	if _, err := collection.DeleteMany(ctx, stale); err != nil {
+		return 0, fmt.Errorf("failed to remove stale watch sessions: %v", err)
+	}
+
+	return len(sessions), nil
+}
+
+// Group time-ordered events into watch sessions. A session covers
+// consecutive events for the same video; switching videos or a gap longer
+// than WATCH_SESSION_IDLE_GAP starts a new one.
+func buildWatchSessions(address, sessionID string, events []sessionEvent) []WatchSession {
+	var sessions []WatchSession
+	var current *WatchSession
+	var last sessionEvent
+
+	for _, event := range events {
+		videoID := event.Data.VideoID
+		if videoID == "" {
+			continue
+		}
+
+		if current == nil || current.VideoID != videoID || event.Timestamp.Sub(last.Timestamp) > WATCH_SESSION_IDLE_GAP {
+			if current != nil {
+				sessions = append(sessions, finishWatchSession(*current))
+			}
+			current = &WatchSession{
+				Address:           address,
+				SessionID:         sessionID,
+				WatchHistoryEntry: WatchHistoryEntry{VideoID: videoID, WatchTime: event.Timestamp},
+			}
+			last = sessionEvent{}
+		}
+
+		switch event.EventType {
+		case "progress_checkpoint":
+			current.WatchedSeconds += PROGRESS_CHECKPOINT_SECONDS
+		case "pause":
+			current.PauseCount++
+		case "ended":
+			current.Completed = true
+			// Players fire pause right before ended
+			if last.EventType == "pause" {
+				current.PauseCount--
+			}
+		case "seek_start":
+			current.SeekCount++
+		case "ad_start":
+			current.AdsSeen++
+		case "ad_skip":
+			current.AdsSkipped++
+		}
+
+		for _, position := range []*float64{event.Data.CurrentTimeSeconds, event.Data.ProgressSeconds} {
+			if position != nil && *position > current.MaxPositionSeconds {
+				current.MaxPositionSeconds = *position
+			}
+		}
+		if duration := event.Data.DurationSeconds; duration != nil && int(*duration) > current.Duration {
+			current.Duration = int(*duration)
+		}
+		if event.Data.ChannelID != "" {
+			current.ChannelID = event.Data.ChannelID
+		}
+		if event.Data.ChannelName != "" {
+			current.ChannelName = event.Data.ChannelName
+		}
+
+		current.EndedAt = event.Timestamp
+		current.EventCount++
+		last = event
+	}
+
+	if current != nil {
+		sessions = append(sessions, finishWatchSession(*current))
+	}
+	return sessions
+}
+
+func finishWatchSession(session WatchSession) WatchSession {
+	if session.Duration > 0 {
+		percent := math.Min(100, session.MaxPositionSeconds/float64(session.Duration)*100)
+		session.PercentWatched = math.Round(percent*10) / 10
+	}
+	if session.PercentWatched >= WATCH_COMPLETE_PERCENT {
+		session.Completed = true
+	}
+	return session
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/html"
+)
+
+const (
+	TAKEOUT_MAX_UPLOAD_MB   = 512
+	TAKEOUT_MAX_FILE_BYTES  = 1 << 30
+	TAKEOUT_MAX_RECORDS     = 500000
+	TAKEOUT_SNIFF_BYTES     = 512
+	TAKEOUT_FORMAT_JSON     = "json"
+	TAKEOUT_FORMAT_HTML     = "html"
+	TAKEOUT_FORMAT_CSV      = "csv"
+	TAKEOUT_KIND_WATCH      = "watch_history"
+	TAKEOUT_KIND_SEARCH     = "search_history"
+	TAKEOUT_KIND_SUBSCRIBED = "subscriptions"
+	TAKEOUT_SEARCHED_PREFIX = "Searched for "
+)
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// One record of a Takeout "My Activity" export. The JSON files hold these
+// directly; the HTML files are parsed into the same shape.
+type takeoutActivity struct {
+	Header    string            `json:"header"`
+	Title     string            `json:"title"`
+	TitleURL  string            `json:"titleUrl"`
+	Subtitles []takeoutSubtitle `json:"subtitles"`
+	Time      string            `json:"time"`
+	Details   []takeoutSubtitle `json:"details"`
+
+	// Set by the HTML parser, which reads localized display dates
+	parsedTime time.Time
+}
+
+type takeoutSubtitle struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Import a Google Takeout zip. The archive is read file by file and each
+// file is stream-parsed, so memory is bounded by the refined schema rather
+// than the archive size.
+func importTakeout(c *gin.Context) {
+	maxBytes := int64(getEnvInt("TAKEOUT_MAX_UPLOAD_MB", TAKEOUT_MAX_UPLOAD_MB)) << 20
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+
+	address := c.PostForm("address")
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, ERR_UPLOAD_TOO_LARGE, fmt.Sprintf("Takeout archives are limited to %d bytes", maxBytes))
+			return
+		}
+		respondError(c, ERR_INVALID_REQUEST, "A multipart file field named file is required")
+		return
+	}
+	if address == "" {
+		respondError(c, ERR_INVALID_REQUEST, "address form field is required")
+		return
+	}
+
+	authAddress, _ := c.Get("address")
+	if authAddress != address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	var overrides map[string]bool
+	if raw := c.PostForm("maskingRules"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+			respondError(c, ERR_INVALID_REQUEST, "maskingRules form field must be a JSON object of booleans")
+			return
+		}
+		if err := validateMaskingOverrides(dataSchemas[DEFAULT_DATA_TYPE], overrides); err != nil {
+			respondError(c, ERR_INVALID_REQUEST, err.Error())
+			return
+		}
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to read uploaded archive")
+		return
+	}
+	defer file.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, file); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to read uploaded archive")
+		return
+	}
+
+	archive, err := zip.NewReader(file, fileHeader.Size)
+	if err != nil {
+		respondError(c, ERR_INVALID_DATA_FORMAT, "Upload is not a zip archive")
+		return
+	}
+
+	ctx := c.Request.Context()
+	logger := loggerFromContext(ctx)
+
+	schema, summary, err := parseTakeoutArchive(ctx, archive, address)
+	if err != nil {
+		respondError(c, ERR_INVALID_DATA_FORMAT, err.Error(), summary)
+		return
+	}
+	summary.ArchiveSHA256 = hex.EncodeToString(digest.Sum(nil))
+
+	dataSchema := dataSchemas[DEFAULT_DATA_TYPE]
+	maskingRules, err := resolveMaskingRules(ctx, dataSchema, address, overrides)
+	if err != nil {
+		logger.Error("Masking preferences unavailable", "error", err)
+		respondError(c, ERR_INTERNAL, "Failed to load masking preferences")
+		return
+	}
+
+	refinedData, err := processSchemaForVRC15(ctx, dataSchema, schema, summary, maskingRules)
+	if err != nil {
+		logger.Error("Takeout refinement failed", "error", err)
+		respondError(c, ERR_REFINEMENT_FAILED, "Data refinement failed")
+		return
+	}
+
+	contribution, err := recordContribution(ctx, UserContribution{
+		Address:  address,
+		DataType: dataSchema.DataType,
+		FileName: fileHeader.Filename,
+		FileSize: fileHeader.Size,
+	}, refinedData)
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to upload data")
+		return
+	}
+
+	logger.Info("Imported Takeout archive",
+		"address", address,
+		"watchHistory", summary.WatchHistory,
+		"searchHistory", summary.SearchHistory,
+		"subscriptions", summary.Subscriptions,
+	)
+
+	c.JSON(http.StatusCreated, TakeoutImportResponse{
+		Message: "Takeout imported successfully",
+		Data:    contribution,
+		TxHash:  contribution.TxHash,
+		Import:  summary,
+	})
+}
+
+// Parse the recognised files of a Takeout archive into a YouTube schema.
+// Other files in the archive are ignored.
+func parseTakeoutArchive(ctx context.Context, archive *zip.Reader, contributor string) (*YouTubeDataSchema, TakeoutImportSummary, error) {
+	schema := &YouTubeDataSchema{SchemaEnvelope: newSchemaEnvelope(DEFAULT_DATA_TYPE, contributor)}
+	summary := TakeoutImportSummary{}
+
+	for _, entry := range archive.File {
+		if ctx.Err() != nil {
+			return nil, summary, ctx.Err()
+		}
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		kind := takeoutFileKind(entry.Name)
+		if kind == "" {
+			continue
+		}
+		fileSummary := TakeoutFileSummary{Name: entry.Name, Kind: kind}
+		if entry.UncompressedSize64 > TAKEOUT_MAX_FILE_BYTES {
+			fileSummary.Error = fmt.Sprintf("file exceeds %d bytes", TAKEOUT_MAX_FILE_BYTES)
+			summary.Files = append(summary.Files, fileSummary)
+			continue
+		}
+
+		err := parseTakeoutFile(entry, schema, &fileSummary)
+		if err != nil {
+			fileSummary.Error = err.Error()
+		}
+		summary.Files = append(summary.Files, fileSummary)
+	}
+
+	summary.WatchHistory = len(schema.WatchHistory)
+	summary.SearchHistory = len(schema.SearchHistory)
+	summary.Subscriptions = len(schema.Subscriptions)
+	if summary.WatchHistory+summary.SearchHistory+summary.Subscriptions == 0 {
+		return nil, summary, fmt.Errorf("no YouTube watch history, search history or subscriptions found in archive")
+	}
+
+	countWatchEntryKinds(schema)
+	schema.Metadata["source"] = "google_takeout"
+	schema.Metadata["takeoutFiles"] = len(summary.Files)
+	schema.Metadata["processedAt"] = time.Now().Unix()
+	return schema, summary, nil
+}
+
+// Classify a Takeout file by name. Takeout keeps the English file names
+// for these exports regardless of account language.
+func takeoutFileKind(name string) string {
+	base := strings.ToLower(path.Base(name))
+	switch {
+	case strings.HasPrefix(base, "watch-history."):
+		return TAKEOUT_KIND_WATCH
+	case strings.HasPrefix(base, "search-history."):
+		return TAKEOUT_KIND_SEARCH
+	case base == "subscriptions.csv":
+		return TAKEOUT_KIND_SUBSCRIBED
+	}
+	return ""
+}
+
+// Detect the format of one archive file from its content and stream it
+// into schema
+func parseTakeoutFile(entry *zip.File, schema *YouTubeDataSchema, fileSummary *TakeoutFileSummary) error {
+	rc, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open: %v", err)
+	}
+	defer rc.Close()
+
+	reader := bufio.NewReader(io.LimitReader(rc, TAKEOUT_MAX_FILE_BYTES))
+	if bom, _ := reader.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		reader.Discard(len(utf8BOM))
+	}
+	fileSummary.Format = sniffTakeoutFormat(reader, fileSummary.Kind)
+
+	if fileSummary.Kind == TAKEOUT_KIND_SUBSCRIBED {
+		return parseTakeoutSubscriptions(reader, func(entry SubscriptionEntry) error {
+			if len(schema.Subscriptions) >= TAKEOUT_MAX_RECORDS {
+				return fmt.Errorf("more than %d subscriptions", TAKEOUT_MAX_RECORDS)
+			}
+			schema.Subscriptions = append(schema.Subscriptions, entry)
+			fileSummary.Records++
+			return nil
+		})
+	}
+
+	emit := func(activity takeoutActivity) error {
+		switch fileSummary.Kind {
+		case TAKEOUT_KIND_WATCH:
+			entry, ok := activity.watchEntry()
+			if !ok {
+				fileSummary.Skipped++
+				return nil
+			}
+			if len(schema.WatchHistory) >= TAKEOUT_MAX_RECORDS {
+				return fmt.Errorf("more than %d watch history entries", TAKEOUT_MAX_RECORDS)
+			}
+			schema.WatchHistory = append(schema.WatchHistory, entry)
+		case TAKEOUT_KIND_SEARCH:
+			entry, ok := activity.searchEntry()
+			if !ok {
+				fileSummary.Skipped++
+				return nil
+			}
+			if len(schema.SearchHistory) >= TAKEOUT_MAX_RECORDS {
+				return fmt.Errorf("more than %d search history entries", TAKEOUT_MAX_RECORDS)
+			}
+			schema.SearchHistory = append(schema.SearchHistory, entry)
+		}
+		fileSummary.Records++
+		return nil
+	}
+
+	switch fileSummary.Format {
+	case TAKEOUT_FORMAT_JSON:
+		return parseTakeoutActivityJSON(reader, emit)
+	case TAKEOUT_FORMAT_HTML:
+		return parseTakeoutActivityHTML(reader, emit)
+	}
+	return fmt.Errorf("unrecognised file format")
+}
+
+// Guess the format from the first non-blank bytes, since Takeout lets
+// users pick JSON or HTML without changing anything else
+func sniffTakeoutFormat(reader *bufio.Reader, kind string) string {
+	if kind == TAKEOUT_KIND_SUBSCRIBED {
+		return TAKEOUT_FORMAT_CSV
+	}
+
+	head, _ := reader.Peek(TAKEOUT_SNIFF_BYTES)
+	head = bytes.TrimLeft(head, " \t\r\n")
+	switch {
+	case len(head) == 0:
+		return ""
+	case head[0] == '[' || head[0] == '{':
+		return TAKEOUT_FORMAT_JSON
+	case head[0] == '<':
+		return TAKEOUT_FORMAT_HTML
+	}
+	return ""
+}
+
+// Decode a JSON array of activities one element at a time
+func parseTakeoutActivityJSON(reader io.Reader, emit func(takeoutActivity) error) error {
+	decoder := json.NewDecoder(reader)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return fmt.Errorf("expected a JSON array of activities")
+	}
+
+	for decoder.More() {
+		var activity takeoutActivity
+		if err := decoder.Decode(&activity); err != nil {
+			return fmt.Errorf("invalid activity JSON: %v", err)
+		}
+		if err := emit(activity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tokenize a My Activity HTML page. Each activity is an "outer-cell" div
+// holding a header cell with the product name, a body cell with the title
+// link, channel link and display date separated by <br>, and a caption cell
+// with details such as "From Google Ads".
+func parseTakeoutActivityHTML(reader io.Reader, emit func(takeoutActivity) error) error {
+	tokenizer := html.NewTokenizer(reader)
+
+	var (
+		divs       []string
+		activity   *takeoutActivity
+		lines      []string
+		line       strings.Builder
+		inAnchor   bool
+		anchorHref string
+		anchorText strings.Builder
+		caption    strings.Builder
+	)
+
+	cell := func() string {
+		for i := len(divs) - 1; i >= 0; i-- {
+			switch {
+			case strings.Contains(divs[i], "header-cell"):
+				return "header"
+			case strings.Contains(divs[i], "mdl-typography--caption"):
+				return "caption"
+			case strings.Contains(divs[i], "content-cell") && strings.Contains(divs[i], "mdl-typography--body-1") && !strings.Contains(divs[i], "text-right"):
+				return "body"
+			}
+		}
+		return ""
+	}
+	endLine := func() {
+		if text := cleanTakeoutText(line.String()); text != "" {
+			lines = append(lines, text)
+		}
+		line.Reset()
+	}
+	flush := func() error {
+		if activity == nil {
+			return nil
+		}
+		endLine()
+		if len(lines) > 0 {
+			if activity.Title == "" {
+				activity.Title = lines[0]
+			}
+			activity.parsedTime = parseTakeoutDisplayTime(lines[len(lines)-1])
+		}
+		if strings.Contains(caption.String(), TAKEOUT_ADS_DETAIL) {
+			activity.Details = append(activity.Details, takeoutSubtitle{Name: TAKEOUT_ADS_DETAIL})
+		}
+		current := *activity
+		activity, lines = nil, nil
+		caption.Reset()
+		return emit(current)
+	}
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return flush()
+			}
+			return fmt.Errorf("invalid activity HTML: %v", tokenizer.Err())
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "div":
+				class := htmlAttr(token, "class")
+				if strings.Contains(class, "outer-cell") {
+					if err := flush(); err != nil {
+						return err
+					}
+					activity = &takeoutActivity{}
+				}
+				if token.Type == html.StartTagToken {
+					divs = append(divs, class)
+				}
+			case "a":
+				if activity != nil && cell() == "body" {
+					inAnchor = true
+					anchorHref = htmlAttr(token, "href")
+					anchorText.Reset()
+				}
+			case "br":
+				if activity != nil && cell() == "body" {
+					endLine()
+				}
+			}
+
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "div":
+				if len(divs) > 0 {
+					if activity != nil && cell() == "body" {
+						endLine()
+					}
+					divs = divs[:len(divs)-1]
+				}
+			case "a":
+				if inAnchor {
+					inAnchor = false
+					text := cleanTakeoutText(anchorText.String())
+					if activity.TitleURL == "" && len(lines) == 0 {
+						activity.TitleURL = anchorHref
+						activity.Title = cleanTakeoutText(line.String() + text)
+					} else {
+						activity.Subtitles = append(activity.Subtitles, takeoutSubtitle{Name: text, URL: anchorHref})
+					}
+					line.WriteString(text)
+				}
+			}
+
+		case html.TextToken:
+			if activity == nil {
+				continue
+			}
+			text := string(tokenizer.Text())
+			switch cell() {
+			case "header":
+				activity.Header += cleanTakeoutText(text)
+			case "caption":
+				caption.WriteString(text)
+			case "body":
+				if inAnchor {
+					anchorText.WriteString(text)
+				} else {
+					line.WriteString(text)
+				}
+			}
+		}
+	}
+}
+
+// Read subscriptions.csv, locating columns by header name
+func parseTakeoutSubscriptions(reader io.Reader, emit func(SubscriptionEntry) error) error {
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+
+	header, err := records.Read()
+	if err != nil {
+		return fmt.Errorf("missing CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	for {
+		record, err := records.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid CSV: %v", err)
+		}
+
+		entry := SubscriptionEntry{
+			ChannelID:   column(record, "channel id"),
+			ChannelName: column(record, "channel title"),
+		}
+		if entry.ChannelID == "" {
+			entry.ChannelID = channelRefFromURL(column(record, "channel url"))
+		}
+		if entry.ChannelID == "" && entry.ChannelName == "" {
+			continue
+		}
+		if err := emit(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// Map a search-history activity to a schema entry, preferring the query in
+// the results URL over the display title
+func (a takeoutActivity) searchEntry() (SearchHistoryEntry, bool) {
+	query := youtubeQueryParam(a.TitleURL, "search_query")
+	if query == "" {
+		query = strings.TrimPrefix(a.Title, TAKEOUT_SEARCHED_PREFIX)
+	}
+	if query == "" {
+		return SearchHistoryEntry{}, false
+	}
+	return SearchHistoryEntry{Query: query, Timestamp: a.timestamp()}, true
+}
+
+func (a takeoutActivity) timestamp() time.Time {
+	if !a.parsedTime.IsZero() {
+		return a.parsedTime
+	}
+	t, err := time.Parse(time.RFC3339Nano, a.Time)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+func youtubeQueryParam(rawURL, name string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Query().Get(name)
+}
+
+func htmlAttr(token html.Token, name string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// Collapse the non-breaking and narrow spaces Takeout puts in display text
+func cleanTakeoutText(text string) string {
+	text = strings.NewReplacer("\u00a0", " ", "\u202f", " ", "\u2009", " ").Replace(text)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Display date layouts of English HTML exports, older and newer
+var takeoutDisplayLayouts = []string{
+	"Jan 2, 2006, 3:04:05 PM MST",
+	"Jan 2, 2006, 3:04:05 PM GMT-07:00",
+	"2 Jan 2006, 15:04:05 MST",
+	"2 Jan 2006, 15:04:05 GMT-07:00",
+}
+
+// Offsets of zone abbreviations Takeout prints, which time.Parse cannot
+// resolve on its own
+var takeoutZoneOffsets = map[string]int{
+	"UTC": 0, "GMT": 0,
+	"EST": -5, "EDT": -4, "CST": -6, "CDT": -5, "MST": -7, "MDT": -6, "PST": -8, "PDT": -7,
+	"BST": 1, "CET": 1, "CEST": 2, "EET": 2, "EEST": 3,
+}
+
+// Parse the display date of an HTML activity. Unknown zone abbreviations
+// are read as UTC.
+func parseTakeoutDisplayTime(text string) time.Time {
+	for _, layout := range takeoutDisplayLayouts {
+		t, err := time.Parse(layout, text)
+		if err != nil {
+			continue
+		}
+		if name, offset := t.Zone(); offset == 0 {
+			if hours, ok := takeoutZoneOffsets[name]; ok {
+				t = t.Add(-time.Duration(hours) * time.Hour)
+			}
+		}
+		return t.UTC()
+	}
+	return time.Time{}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TRACING_SERVICE_NAME = "tubedao-backend"
+	RPC_HTTP_TIMEOUT     = 30 * time.Second
+)
+
+var (
+	tracer         = otel.Tracer(TRACING_SERVICE_NAME)
+	tracerProvider *sdktrace.TracerProvider
+)
+
+// Configure the global tracer provider from OTEL_TRACES_EXPORTER
+// ("otlp", "stdout" or "none"). The OTLP exporter honours the standard
+// OTEL_EXPORTER_OTLP_* variables.
+func initTracing() error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch strings.ToLower(getEnvOrDefault("OTEL_TRACES_EXPORTER", "none")) {
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "none", "":
+		slog.Info("Tracing disabled")
+		return nil
+	default:
+		return fmt.Errorf("unsupported OTEL_TRACES_EXPORTER %q", os.Getenv("OTEL_TRACES_EXPORTER"))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create trace exporter: %v", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(getEnvOrDefault("OTEL_SERVICE_NAME", TRACING_SERVICE_NAME)),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to build trace resource: %v", err)
+	}
+
+	ratio := 1.0
+	if value := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			ratio = parsed
+		}
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+
+	slog.Info("Tracing enabled", "exporter", os.Getenv("OTEL_TRACES_EXPORTER"), "sampleRatio", ratio)
+	return nil
+}
+
+// Flush pending spans and stop the tracer provider
+func shutdownTracing(ctx context.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	return tracerProvider.Shutdown(ctx)
+}
+
+// Start a span and return a function that ends it, recording err if non-nil
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// Trace a refinement stage and record its duration metric
+func startRefinementStage(ctx context.Context, stage string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, end := startSpan(ctx, "refinement."+stage, attribute.String("refinement.stage", stage))
+	return ctx, func(err error) {
+		observeRefinementStage(stage, start)
+		end(err)
+	}
+}
+
+// Dial an Ethereum JSON-RPC endpoint with a span around every call
+func dialTracedEthClient(ctx context.Context, url string) (*ethclient.Client, error) {
+	httpClient := &http.Client{
+		Timeout:   RPC_HTTP_TIMEOUT,
+		Transport: &rpcTracingTransport{base: http.DefaultTransport},
+	}
+
+	client, err := rpc.DialOptions(ctx, url, rpc.WithHTTPClient(httpClient))
+	if err != nil {
+		return nil, err
+	}
+
+	return ethclient.NewClient(client), nil
+}
+
+// HTTP transport naming spans after the JSON-RPC method being called
+type rpcTracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *rpcTracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := "unknown"
+	if req.Body != nil && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			method = jsonRPCMethod(body)
+			body.Close()
+		}
+	}
+
+	ctx, span := tracer.Start(req.Context(), "jsonrpc "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("jsonrpc"),
+			semconv.RPCMethod(method),
+			semconv.ServerAddress(req.URL.Host),
+		),
+	)
+	defer span.End()
+
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
+
+// Extract the method name of a single or batched JSON-RPC request body
+func jsonRPCMethod(body io.Reader) string {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "unknown"
+	}
+
+	var single struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(data, &single); err == nil && single.Method != "" {
+		return single.Method
+	}
+
+	var batch []struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(data), &batch); err == nil && len(batch) > 0 {
+		return "batch:" + batch[0].Method
+	}
+
+	return "unknown"
+}
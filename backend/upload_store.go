@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Staged bytes of a resumable upload, readable sequentially or at offsets
+// (zip archives need the latter)
+type StagedUpload interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// Storage for resumable uploads while they are being received. Appends
+// must land exactly at the current end of the upload.
+type UploadStore interface {
+	Create(ctx context.Context, id string) error
+	Append(ctx context.Context, id string, offset int64, r io.Reader) (int64, error)
+	Truncate(ctx context.Context, id string, size int64) error
+	Open(ctx context.Context, id string) (StagedUpload, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// Sessions outlive restarts, so staged bytes must not sit in a temporary
+// directory that a reboot clears
+const UPLOAD_STAGING_DIR = "/var/lib/tubedao/uploads"
+
+var uploadStore UploadStore
+
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Select the staging store. Only local disk is built in; an object store
+// can be plugged in by implementing UploadStore.
+func initUploadStore() error {
+	switch kind := getEnvOrDefault("UPLOAD_STORE", "disk"); kind {
+	case "disk":
+		dir := getEnvOrDefault("UPLOAD_STAGING_DIR", UPLOAD_STAGING_DIR)
+		if err := ensureWritableDir(dir); err != nil {
+			return fmt.Errorf("upload staging directory: %v", err)
+		}
+		uploadStore = &diskUploadStore{dir: dir}
+		return nil
+	default:
+		return fmt.Errorf("unknown UPLOAD_STORE %q", kind)
+	}
+}
+
+// Stages each upload as one file in dir
+type diskUploadStore struct {
+	dir string
+}
+
+func (s *diskUploadStore) path(id string) (string, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid upload id %q", id)
+	}
+	return filepath.Join(s.dir, id), nil
+}
+
+func (s *diskUploadStore) Create(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create staged upload: %v", err)
+	}
+	return file.Close()
+}
+
+func (s *diskUploadStore) Append(ctx context.Context, id string, offset int64, r io.Reader) (int64, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open staged upload: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat staged upload: %v", err)
+	}
+	if info.Size() != offset {
+		return 0, fmt.Errorf("staged upload has %d bytes, not %d", info.Size(), offset)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek staged upload: %v", err)
+	}
+
+	written, err := io.Copy(file, r)
+	if syncErr := file.Sync(); err == nil && syncErr != nil {
+		err = fmt.Errorf("failed to sync staged upload: %v", syncErr)
+	}
+	return written, err
+}
+
+func (s *diskUploadStore) Truncate(ctx context.Context, id string, size int64) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Truncate(path, size); err != nil {
+		return fmt.Errorf("failed to truncate staged upload: %v", err)
+	}
+	return nil
+}
+
+func (s *diskUploadStore) Open(ctx context.Context, id string) (StagedUpload, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open staged upload: %v", err)
+	}
+	return file, nil
+}
+
+func (s *diskUploadStore) Delete(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete staged upload: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	UPLOAD_SESSIONS_COLLECTION    = "upload_sessions"
+	UPLOAD_MAX_MB                 = 2048
+	UPLOAD_MAX_CHUNK_BYTES        = 64 << 20
+	UPLOAD_DATA_MAX_BODY_MB       = 32
+	UPLOAD_SESSION_TTL            = 24 * time.Hour
+	UPLOAD_MAINTENANCE_INTERVAL   = 15 * time.Minute
+	UPLOAD_PROCESSING_STALE_AFTER = time.Hour
+	UPLOAD_CHECKSUM_ALGORITHM     = "sha256"
+
+	UPLOAD_STATUS_UPLOADING  = "uploading"
+	UPLOAD_STATUS_PROCESSING = "processing"
+	UPLOAD_STATUS_COMPLETED  = "completed"
+	UPLOAD_STATUS_FAILED     = "failed"
+)
+
+var zipMagic = []byte("PK\x03\x04")
+
+// Serializes chunk writes, finalization and deletion per upload. Entries
+// are only created for existing uploads and removed with them.
+var uploadLocks sync.Map
+
+func lockUpload(id string) func() {
+	value, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Load the caller's upload named in the path and hold its lock. The session
+// is checked before locking, so unknown IDs never get a lock, and read again
+// once locked since it may have changed meanwhile.
+func lockUploadSession(c *gin.Context) (UploadSession, func(), bool) {
+	if _, ok := loadUploadSession(c); !ok {
+		return UploadSession{}, nil, false
+	}
+
+	unlock := lockUpload(c.Param("id"))
+	session, ok := loadUploadSession(c)
+	if !ok {
+		unlock()
+		return session, nil, false
+	}
+	return session, unlock, true
+}
+
+// Set up the staging store, indexes and the job expiring abandoned uploads
+func initUploads() {
+	if err := initUploadStore(); err != nil {
+		logFatal("Upload store initialization failed", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), EVENT_INDEX_INIT_TIMEOUT)
+	defer cancel()
+
+	_, err := db.Collection(UPLOAD_SESSIONS_COLLECTION).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "address", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updatedAt", Value: 1}}},
+	})
+	if err != nil {
+		slog.Error("Failed to create upload session indexes", "error", err)
+	}
+
+	schedulePeriodicJob("maintain-uploads", getEnvDuration("UPLOAD_MAINTENANCE_INTERVAL", UPLOAD_MAINTENANCE_INTERVAL), maintainUploads)
+}
+
+// Start a resumable upload. The client then PUTs chunks at Upload-Offset
+// and finalizes once every byte has arrived.
+func createUpload(c *gin.Context) {
+	var req CreateUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	authAddress, _ := c.Get("address")
+	if authAddress != req.Address {
+		respondError(c, ERR_ADDRESS_MISMATCH, "Address mismatch")
+		return
+	}
+
+	dataSchema, err := lookupDataSchema(req.DataType)
+	if err != nil {
+		respondError(c, ERR_UNSUPPORTED_DATA_TYPE, err.Error())
+		return
+	}
+
+	if err := validateMaskingOverrides(dataSchema, req.MaskingRules); err != nil {
+		respondError(c, ERR_INVALID_REQUEST, err.Error())
+		return
+	}
+
+	maxBytes := int64(getEnvInt("UPLOAD_MAX_MB", UPLOAD_MAX_MB)) << 20
+	if req.Length > maxBytes {
+		respondError(c, ERR_UPLOAD_TOO_LARGE, fmt.Sprintf("Uploads are limited to %d bytes", maxBytes))
+		return
+	}
+
+	if uploadStore == nil {
+		respondError(c, ERR_INTERNAL, "Upload staging is not configured")
+		return
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to create upload")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := uploadStore.Create(ctx, id); err != nil {
+		loggerFromContext(ctx).Error("Failed to stage upload", "error", err)
+		respondError(c, ERR_INTERNAL, "Failed to create upload")
+		return
+	}
+
+	now := time.Now()
+	session := UploadSession{
+		ID:           id,
+		Address:      req.Address,
+		DataType:     dataSchema.DataType,
+		FileName:     req.FileName,
+		Length:       req.Length,
+		Checksum:     strings.ToLower(req.Checksum),
+		MaskingRules: req.MaskingRules,
+		Status:       UPLOAD_STATUS_UPLOADING,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		ExpiresAt:    now.Add(UPLOAD_SESSION_TTL),
+	}
+	if _, err := db.Collection(UPLOAD_SESSIONS_COLLECTION).InsertOne(ctx, session); err != nil {
+		uploadStore.Delete(ctx, id)
+		respondError(c, ERR_INTERNAL, "Failed to create upload")
+		return
+	}
+
+	c.Header("Location", "/api/uploads/"+id)
+	setUploadHeaders(c, session)
+	c.JSON(http.StatusCreated, session)
+}
+
+// Report the upload offset in headers, for clients resuming an upload
+func headUpload(c *gin.Context) {
+	session, ok := loadUploadSession(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	setUploadHeaders(c, session)
+	c.Status(http.StatusOK)
+}
+
+// Get an upload, including the refinement outcome once finalized
+func getUpload(c *gin.Context) {
+	session, ok := loadUploadSession(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	setUploadHeaders(c, session)
+	c.JSON(http.StatusOK, session)
+}
+
+// Append a chunk at Upload-Offset. With an Upload-Checksum header
+// ("sha256 <base64 digest>") a chunk that does not match is discarded;
+// without one, the bytes received before a dropped connection are kept and
+// the client resumes from the returned offset.
+func putUploadChunk(c *gin.Context) {
+	session, unlock, ok := lockUploadSession(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if session.Status != UPLOAD_STATUS_UPLOADING {
+		respondError(c, ERR_UPLOAD_CLOSED, fmt.Sprintf("Upload is %s", session.Status))
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondError(c, ERR_INVALID_REQUEST, "Upload-Offset header is required")
+		return
+	}
+	if offset != session.Offset {
+		setUploadHeaders(c, session)
+		respondError(c, ERR_UPLOAD_OFFSET_MISMATCH, fmt.Sprintf("Upload is at offset %d, not %d", session.Offset, offset))
+		return
+	}
+
+	expected, err := parseUploadChecksum(c.GetHeader("Upload-Checksum"))
+	if err != nil {
+		respondError(c, ERR_INVALID_REQUEST, err.Error())
+		return
+	}
+
+	maxChunk := min(session.Length-session.Offset, UPLOAD_MAX_CHUNK_BYTES)
+	if c.Request.ContentLength > maxChunk {
+		respondError(c, ERR_UPLOAD_TOO_LARGE, fmt.Sprintf("Chunk may be at most %d bytes", maxChunk))
+		return
+	}
+
+	ctx := c.Request.Context()
+	logger := loggerFromContext(ctx)
+
+	digest := sha256.New()
+	written, writeErr := uploadStore.Append(ctx, session.ID, offset, io.TeeReader(io.LimitReader(c.Request.Body, maxChunk), digest))
+
+	discard := func(code ErrorCode, detail string) {
+		if err := uploadStore.Truncate(ctx, session.ID, offset); err != nil {
+			logger.Error("Failed to discard upload chunk", "upload", session.ID, "error", err)
+		}
+		setUploadHeaders(c, session)
+		respondError(c, code, detail)
+	}
+
+	if writeErr == nil && written == maxChunk {
+		if n, _ := c.Request.Body.Read(make([]byte, 1)); n > 0 {
+			discard(ERR_UPLOAD_TOO_LARGE, fmt.Sprintf("Chunk may be at most %d bytes", maxChunk))
+			return
+		}
+	}
+	if expected != nil && (writeErr != nil || !bytes.Equal(digest.Sum(nil), expected)) {
+		discard(ERR_CHECKSUM_MISMATCH, "Chunk does not match Upload-Checksum")
+		return
+	}
+
+	now := time.Now()
+	result, err := db.Collection(UPLOAD_SESSIONS_COLLECTION).UpdateOne(ctx,
+		bson.M{"_id": session.ID, "offset": offset},
+		bson.M{"$set": bson.M{"offset": offset + written, "updatedAt": now, "expiresAt": now.Add(UPLOAD_SESSION_TTL)}})
+	if err != nil || result.MatchedCount == 0 {
+		discard(ERR_INTERNAL, "Failed to record upload progress")
+		return
+	}
+	session.Offset = offset + written
+	session.ExpiresAt = now.Add(UPLOAD_SESSION_TTL)
+
+	setUploadHeaders(c, session)
+	if writeErr != nil {
+		logger.Warn("Upload chunk interrupted", "upload", session.ID, "written", written, "error", writeErr)
+		respondError(c, ERR_UPLOAD_INTERRUPTED, fmt.Sprintf("Chunk was cut short; resume at offset %d", session.Offset))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Verify a complete upload and queue it for refinement. Finalizing an
+// upload that is already processing or done returns its current state.
+func finalizeUpload(c *gin.Context) {
+	session, unlock, ok := lockUploadSession(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	switch session.Status {
+	case UPLOAD_STATUS_PROCESSING, UPLOAD_STATUS_COMPLETED:
+		c.JSON(http.StatusAccepted, session)
+		return
+	case UPLOAD_STATUS_FAILED:
+		respondError(c, ERR_UPLOAD_CLOSED, "Upload failed: "+session.Error)
+		return
+	}
+
+	if session.Offset != session.Length {
+		setUploadHeaders(c, session)
+		respondError(c, ERR_UPLOAD_INCOMPLETE, fmt.Sprintf("Upload has %d of %d bytes", session.Offset, session.Length))
+		return
+	}
+
+	ctx := c.Request.Context()
+	collection := db.Collection(UPLOAD_SESSIONS_COLLECTION)
+
+	if session.Checksum != "" {
+		sum, err := stagedUploadChecksum(ctx, session.ID)
+		if err != nil {
+			respondError(c, ERR_INTERNAL, "Failed to verify upload")
+			return
+		}
+		if sum != session.Checksum {
+			failUpload(ctx, session.ID, "checksum mismatch")
+			respondError(c, ERR_CHECKSUM_MISMATCH, "Upload does not match its checksum")
+			return
+		}
+	}
+
+	now := time.Now()
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": session.ID, "status": UPLOAD_STATUS_UPLOADING},
+		bson.M{"$set": bson.M{"status": UPLOAD_STATUS_PROCESSING, "updatedAt": now}})
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to finalize upload")
+		return
+	}
+	session.Status = UPLOAD_STATUS_PROCESSING
+	session.UpdatedAt = now
+
+	id := session.ID
+	err = jobQueue.Enqueue(Job{Name: "refine-upload", RequestID: requestIDFromContext(ctx), Run: func(ctx context.Context) error {
+		return refineStagedUpload(ctx, id)
+	}})
+	if err != nil {
+		collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": UPLOAD_STATUS_UPLOADING}})
+		c.Header("Retry-After", "30")
+		respondError(c, ERR_INGESTION_BUSY, "Refinement queue is full, finalize again later")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, session)
+}
+
+// Abort an upload and discard its staged bytes
+func deleteUpload(c *gin.Context) {
+	session, unlock, ok := lockUploadSession(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if session.Status == UPLOAD_STATUS_PROCESSING {
+		respondError(c, ERR_UPLOAD_CLOSED, "Upload is being refined")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := removeUpload(ctx, session.ID); err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to delete upload")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Load the upload named in the path, which must belong to the caller
+func loadUploadSession(c *gin.Context) (UploadSession, bool) {
+	authAddress, _ := c.Get("address")
+
+	var session UploadSession
+	if !uploadIDPattern.MatchString(c.Param("id")) {
+		respondError(c, ERR_UPLOAD_NOT_FOUND, "Upload not found")
+		return session, false
+	}
+	err := db.Collection(UPLOAD_SESSIONS_COLLECTION).FindOne(c.Request.Context(), bson.M{
+		"_id":     c.Param("id"),
+		"address": authAddress,
+	}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, ERR_UPLOAD_NOT_FOUND, "Upload not found")
+		return session, false
+	}
+	if err != nil {
+		respondError(c, ERR_INTERNAL, "Failed to fetch upload")
+		return session, false
+	}
+	return session, true
+}
+
+func setUploadHeaders(c *gin.Context, session UploadSession) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Length, 10))
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// Parse an Upload-Checksum header, "sha256 <base64 digest>". An empty
+// header returns a nil digest.
+func parseUploadChecksum(header string) ([]byte, error) {
+	if header == "" {
+		return nil, nil
+	}
+	algorithm, encoded, found := strings.Cut(strings.TrimSpace(header), " ")
+	if !found || !strings.EqualFold(algorithm, UPLOAD_CHECKSUM_ALGORITHM) {
+		return nil, fmt.Errorf("Upload-Checksum must be %q followed by a base64 digest", UPLOAD_CHECKSUM_ALGORITHM)
+	}
+	digest, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("Upload-Checksum digest is not a base64 SHA-256 digest")
+	}
+	return digest, nil
+}
+
+func newUploadID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Hex SHA-256 of a staged upload
+func stagedUploadChecksum(ctx context.Context, id string) (string, error) {
+	file, err := uploadStore.Open(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, file); err != nil {
+		return "", fmt.Errorf("failed to read staged upload: %v", err)
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// Refine a finalized upload and record the outcome on its session. The
+// staged bytes are discarded either way.
+func refineStagedUpload(ctx context.Context, id string) error {
+	collection := db.Collection(UPLOAD_SESSIONS_COLLECTION)
+
+	var session UploadSession
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&session); err != nil {
+		return fmt.Errorf("failed to load upload %s: %v", id, err)
+	}
+	if session.Status != UPLOAD_STATUS_PROCESSING {
+		return nil
+	}
+
+	contribution, err := refineUploadedFile(ctx, session)
+	if err != nil {
+		failUpload(ctx, id, err.Error())
+		return fmt.Errorf("failed to refine upload %s: %v", id, err)
+	}
+
+	if err := uploadStore.Delete(ctx, id); err != nil {
+		loggerFromContext(ctx).Warn("Failed to delete staged upload", "upload", id, "error", err)
+	}
+
+	now := time.Now()
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"status":         UPLOAD_STATUS_COMPLETED,
+		"contributionId": contribution.ID,
+		"updatedAt":      now,
+		"expiresAt":      now.Add(UPLOAD_SESSION_TTL),
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to complete upload %s: %v", id, err)
+	}
+
+	notices.Publish(session.Address, Notice{Type: NOTICE_UPLOAD_PROCESSED, Data: UploadProcessedNotice{
+		UploadID:       id,
+		Status:         UPLOAD_STATUS_COMPLETED,
+		ContributionID: contribution.ID.Hex(),
+	}})
+	return nil
+}
+
+// Run a staged file through the refinement pipeline. Zip archives are read
+// as Google Takeout exports; anything else must be a JSON document in the
+// shape the upload's data type expects. Masking preferences are read now,
+// with the overrides given when the upload was created.
+func refineUploadedFile(ctx context.Context, session UploadSession) (UserContribution, error) {
+	dataSchema, err := lookupDataSchema(session.DataType)
+	if err != nil {
+		return UserContribution{}, err
+	}
+
+	file, err := uploadStore.Open(ctx, session.ID)
+	if err != nil {
+		return UserContribution{}, err
+	}
+	defer file.Close()
+
+	maskingRules, err := resolveMaskingRules(ctx, dataSchema, session.Address, session.MaskingRules)
+	if err != nil {
+		return UserContribution{}, err
+	}
+
+	magic := make([]byte, len(zipMagic))
+	file.ReadAt(magic, 0)
+
+	var refinedData *RefinedData
+	if bytes.Equal(magic, zipMagic) {
+		if dataSchema.DataType != DEFAULT_DATA_TYPE {
+			return UserContribution{}, fmt.Errorf("zip archives are only accepted as %s", DEFAULT_DATA_TYPE)
+		}
+		archive, err := zip.NewReader(file, session.Length)
+		if err != nil {
+			return UserContribution{}, fmt.Errorf("invalid zip archive: %v", err)
+		}
+		schema, summary, err := parseTakeoutArchive(ctx, archive, session.Address)
+		if err != nil {
+			return UserContribution{}, err
+		}
+		summary.ArchiveSHA256 = session.Checksum
+		refinedData, err = processSchemaForVRC15(ctx, dataSchema, schema, summary, maskingRules)
+		if err != nil {
+			return UserContribution{}, err
+		}
+	} else {
+		// Plain JSON is decoded whole, so it gets the same limit as a
+		// direct upload; only Takeout archives are read as a stream
+		maxJSON := int64(getEnvInt("UPLOAD_DATA_MAX_BODY_MB", UPLOAD_DATA_MAX_BODY_MB)) << 20
+		if session.Length > maxJSON {
+			return UserContribution{}, fmt.Errorf("JSON uploads are limited to %d bytes; upload larger exports as a Takeout zip archive", maxJSON)
+		}
+		var dataContent interface{}
+		if err := json.NewDecoder(file).Decode(&dataContent); err != nil {
+			return UserContribution{}, fmt.Errorf("upload is neither a zip archive nor JSON: %v", err)
+		}
+		if err := dataSchema.Validate(dataContent); err != nil {
+			return UserContribution{}, fmt.Errorf("invalid %s data: %v", dataSchema.DataType, err)
+		}
+		refinedData, err = processDataForVRC15(ctx, dataSchema, session.Address, dataContent, maskingRules)
+		if err != nil {
+			return UserContribution{}, err
+		}
+	}
+
+	return recordContribution(ctx, UserContribution{
+		Address:  session.Address,
+		DataType: dataSchema.DataType,
+		FileName: session.FileName,
+		FileSize: session.Length,
+	}, refinedData)
+}
+
+// Mark an upload failed and discard its staged bytes
+func failUpload(ctx context.Context, id, reason string) {
+	logger := loggerFromContext(ctx)
+	if err := uploadStore.Delete(ctx, id); err != nil {
+		logger.Warn("Failed to delete staged upload", "upload", id, "error", err)
+	}
+
+	var session UploadSession
+	now := time.Now()
+	err := db.Collection(UPLOAD_SESSIONS_COLLECTION).FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"status":    UPLOAD_STATUS_FAILED,
+		"error":     reason,
+		"updatedAt": now,
+		"expiresAt": now.Add(UPLOAD_SESSION_TTL),
+	}}).Decode(&session)
+	if err != nil {
+		logger.Error("Failed to mark upload failed", "upload", id, "error", err)
+		return
+	}
+
+	notices.Publish(session.Address, Notice{Type: NOTICE_UPLOAD_PROCESSED, Data: UploadProcessedNotice{
+		UploadID: id,
+		Status:   UPLOAD_STATUS_FAILED,
+		Error:    reason,
+	}})
+}
+
+func removeUpload(ctx context.Context, id string) error {
+	if err := uploadStore.Delete(ctx, id); err != nil {
+		return err
+	}
+	if _, err := db.Collection(UPLOAD_SESSIONS_COLLECTION).DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return fmt.Errorf("failed to delete upload session: %v", err)
+	}
+	uploadLocks.Delete(id)
+	return nil
+}
+
+// Remove expired uploads and requeue refinements interrupted by a restart
+func maintainUploads(ctx context.Context) error {
+	collection := db.Collection(UPLOAD_SESSIONS_COLLECTION)
+	now := time.Now()
+
+	cursor, err := collection.Find(ctx, bson.M{
+		"expiresAt": bson.M{"$lt": now},
+		"status":    bson.M{"$ne": UPLOAD_STATUS_PROCESSING},
+	}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to find expired uploads: %v", err)
+	}
+	var expired []UploadSession
+	if err := cursor.All(ctx, &expired); err != nil {
+		return fmt.Errorf("failed to read expired uploads: %v", err)
+	}
+	for _, session := range expired {
+		unlock := lockUpload(session.ID)
+		err := removeUpload(ctx, session.ID)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	cursor, err = collection.Find(ctx, bson.M{
+		"status":    UPLOAD_STATUS_PROCESSING,
+		"updatedAt": bson.M{"$lt": now.Add(-UPLOAD_PROCESSING_STALE_AFTER)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find stalled uploads: %v", err)
+	}
+	var stalled []UploadSession
+	if err := cursor.All(ctx, &stalled); err != nil {
+		return fmt.Errorf("failed to read stalled uploads: %v", err)
+	}
+	for _, session := range stalled {
+		// Claim by bumping updatedAt so overlapping runs requeue it once
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": session.ID, "status": UPLOAD_STATUS_PROCESSING, "updatedAt": session.UpdatedAt},
+			bson.M{"$set": bson.M{"updatedAt": now}})
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+		id := session.ID
+		enqueueJob(ctx, "refine-upload", func(ctx context.Context) error {
+			return refineStagedUpload(ctx, id)
+		})
+	}
+
+	if len(expired) > 0 || len(stalled) > 0 {
+		loggerFromContext(ctx).Info("Maintained uploads", "expired", len(expired), "requeued", len(stalled))
+	}
+	return nil
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/big"
 	"os"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
@@ -27,12 +29,12 @@ func initVanaDataAccess() error {
 	queryEngineAddress = common.HexToAddress(getEnvOrDefault("QUERY_ENGINE_ADDRESS", ""))
 	dataRefinerRegistryAddr = common.HexToAddress(getEnvOrDefault("DATA_REFINER_REGISTRY_ADDRESS", ""))
 
-	log.Println("Vana data access contracts initialized")
+	slog.Info("Vana data access contracts initialized")
 	return nil
 }
 
 // Publish proof of data refinement to DataRegistry
-func publishRefinementProof(dataHash [32]byte, ipfsHash string, refinedDataHash [32]byte) error {
+func publishRefinementProof(ctx context.Context, dataHash [32]byte, ipfsHash string, refinedDataHash [32]byte) error {
 	if ethClient == nil || dataRegistryAddress == (common.Address{}) {
 		return fmt.Errorf("DataRegistry not configured")
 	}
@@ -45,8 +47,9 @@ func publishRefinementProof(dataHash [32]byte, ipfsHash string, refinedDataHash
 		ethClient,
 	)
 
-	tx, err := contract.Transact(
-		backendAuth,
+	tx, err := transactContract(
+		ctx,
+		contract,
 		"addProof",
 		dataHash,
 		ipfsHash,
@@ -57,12 +60,12 @@ func publishRefinementProof(dataHash [32]byte, ipfsHash string, refinedDataHash
 		return fmt.Errorf("failed to publish proof: %v", err)
 	}
 
-	log.Printf("Published refinement proof: %s", tx.Hash())
+	trackTransaction(ctx, "addProof", tx, nil)
 	return nil
 }
 
 // Set data access permissions and pricing on QueryEngine
-func setDataAccessPermissions(datasetId [32]byte, accessPrice *big.Int, isPublic bool) error {
+func setDataAccessPermissions(ctx context.Context, datasetId [32]byte, accessPrice *big.Int, isPublic bool) error {
 	if ethClient == nil || queryEngineAddress == (common.Address{}) {
 		return fmt.Errorf("QueryEngine not configured")
 	}
@@ -75,8 +78,9 @@ func setDataAccessPermissions(datasetId [32]byte, accessPrice *big.Int, isPublic
 		ethClient,
 	)
 
-	tx, err := contract.Transact(
-		backendAuth,
+	tx, err := transactContract(
+		ctx,
+		contract,
 		"addGenericPermission",
 		datasetId,
 		accessPrice,
@@ -86,12 +90,12 @@ func setDataAccessPermissions(datasetId [32]byte, accessPrice *big.Int, isPublic
 		return fmt.Errorf("failed to set access permissions: %v", err)
 	}
 
-	log.Printf("Set data access permissions: %s", tx.Hash())
+	trackTransaction(ctx, "addGenericPermission", tx, nil)
 	return nil
 }
 
 // Register dataset schema on DataRefinerRegistry
-func registerDataSchema(schemaHash [32]byte, schemaIPFS string, description string) error {
+func registerRefinerSchema(ctx context.Context, schemaHash [32]byte, schemaIPFS string, description string) error {
 	if ethClient == nil || dataRefinerRegistryAddr == (common.Address{}) {
 		return fmt.Errorf("DataRefinerRegistry not configured")
 	}
@@ -104,8 +108,9 @@ func registerDataSchema(schemaHash [32]byte, schemaIPFS string, description stri
 		ethClient,
 	)
 
-	tx, err := contract.Transact(
-		backendAuth,
+	tx, err := transactContract(
+		ctx,
+		contract,
 		"registerSchema",
 		schemaHash,
 		schemaIPFS,
@@ -115,41 +120,115 @@ func registerDataSchema(schemaHash [32]byte, schemaIPFS string, description stri
 		return fmt.Errorf("failed to register schema: %v", err)
 	}
 
-	log.Printf("Registered data schema: %s", tx.Hash())
+	trackTransaction(ctx, "registerSchema", tx, nil)
 	return nil
 }
 
 // Complete VRC-15 data refinement workflow
-func processDataForVRC15(contributorAddr string, rawData interface{}, maskingRules map[string]bool) (*RefinedData, error) {
-	normalizedData, err := normalizeYouTubeData(rawData, contributorAddr)
+func processDataForVRC15(ctx context.Context, dataSchema *DataSchema, contributorAddr string, rawData interface{}, maskingRules map[string]bool) (*RefinedData, error) {
+	ctx, endProcess := startSpan(ctx, "refinement.processDataForVRC15", attribute.String("refinement.data_type", dataSchema.DataType))
+	refinedData, err := runRefinementStages(ctx, dataSchema, contributorAddr, rawData, maskingRules)
+	endProcess(err)
+	return refinedData, err
+}
+
+func runRefinementStages(ctx context.Context, dataSchema *DataSchema, contributorAddr string, rawData interface{}, maskingRules map[string]bool) (*RefinedData, error) {
+	_, end := startRefinementStage(ctx, "normalize")
+	normalizedData, err := dataSchema.Normalize(rawData, contributorAddr)
+	end(err)
 	if err != nil {
 		return nil, fmt.Errorf("normalization failed: %v", err)
 	}
 
-	maskedData := applyPrivacyMasking(normalizedData, maskingRules)
+	return refineNormalizedData(ctx, dataSchema, normalizedData, rawData, maskingRules)
+}
+
+// Refine a schema built by the backend itself, such as an event epoch,
+// skipping normalization. source is hashed into the refinement proof.
+func processSchemaForVRC15(ctx context.Context, dataSchema *DataSchema, schema RefinedSchema, source interface{}, maskingRules map[string]bool) (*RefinedData, error) {
+	ctx, endProcess := startSpan(ctx, "refinement.processSchemaForVRC15", attribute.String("refinement.data_type", dataSchema.DataType))
+	refinedData, err := refineNormalizedData(ctx, dataSchema, schema, source, maskingRules)
+	endProcess(err)
+	return refinedData, err
+}
+
+// Score a normalized schema, then mask, encrypt and upload one dataset per
+// masking variant, each with its own hash. Quality is scored before masking
+// so masking choices do not lower rewards. Returns the primary variant with
+// the others in Variants; only the primary gets a refinement proof.
+func refineNormalizedData(ctx context.Context, dataSchema *DataSchema, normalizedData RefinedSchema, rawData interface{}, maskingRules map[string]bool) (*RefinedData, error) {
+	normalizedData.envelope().Metadata["dataQuality"] = dataSchema.Quality(normalizedData)
+
+	stageCtx, end := startRefinementStage(ctx, "k_anonymity")
+	kAnonymity, err := checkChannelKAnonymity(stageCtx, normalizedData)
+	end(err)
+	if err != nil {
+		return nil, fmt.Errorf("k-anonymity check failed: %v", err)
+	}
+
+	var primary *RefinedData
+	for _, variant := range maskingVariants {
+		refinedData, err := refineMaskingVariant(ctx, dataSchema, normalizedData, variant, variant.rulesFor(dataSchema, maskingRules), kAnonymity)
+		if err != nil {
+			return nil, fmt.Errorf("%s variant: %v", variant.Name, err)
+		}
+		if primary == nil {
+			primary = refinedData
+		} else {
+			primary.Variants = append(primary.Variants, refinedData)
+		}
+	}
+
+	stageCtx, end = startRefinementStage(ctx, "publish_proof")
+	originalHash := calculateDataHash(rawData)
+	err = publishRefinementProof(stageCtx, originalHash, primary.IPFSHash, primary.Hash)
+	end(err)
+	if err != nil {
+		loggerFromContext(ctx).Warn("Failed to publish proof to DataRegistry", "error", err)
+	}
 
-	refinedData, err := encryptRefinedData(maskedData)
+	return primary, nil
+}
+
+// Mask, encrypt and upload one variant and price it on QueryEngine. The
+// applied masking rules are recorded so buyers know what was masked.
+func refineMaskingVariant(ctx context.Context, dataSchema *DataSchema, normalizedData RefinedSchema, variant MaskingVariant, maskingRules map[string]bool, kAnonymity *channelKAnonymity) (*RefinedData, error) {
+	_, end := startRefinementStage(ctx, "mask")
+	maskedData := dataSchema.Mask(normalizedData, maskingRules)
+	kAnonymity.apply(maskedData)
+	metadata := maskedData.envelope().Metadata
+	metadata["variant"] = variant.Name
+	metadata["maskingRules"] = maskingRules
+	if maskingRules["timestamps"] {
+		metadata["timestampGranularity"] = maskTimeGranularity
+	}
+	end(nil)
+
+	stageCtx, end := startRefinementStage(ctx, "encrypt")
+	refinedData, err := encryptRefinedData(stageCtx, maskedData)
+	end(err)
 	if err != nil {
 		return nil, fmt.Errorf("encryption failed: %v", err)
 	}
+	refinedData.Variant = variant.Name
 
+	_, end = startRefinementStage(ctx, "ipfs_upload")
 	ipfsHash, err := uploadRefinedDataToIPFS(refinedData)
+	end(err)
 	if err != nil {
 		return nil, fmt.Errorf("IPFS upload failed: %v", err)
 	}
 	refinedData.IPFSHash = ipfsHash
 
-	originalHash := calculateDataHash(rawData)
-	err = publishRefinementProof(originalHash, ipfsHash, refinedData.Hash)
-	if err != nil {
-		log.Printf("Warning: Failed to publish proof to DataRegistry: %v", err)
-	}
-
-	datasetId := refinedData.Hash
+	stageCtx, end = startRefinementStage(ctx, "set_permissions")
 	accessPrice := big.NewInt(1000000000000000000)
-	err = setDataAccessPermissions(datasetId, accessPrice, false)
+	if variant.Public {
+		accessPrice = big.NewInt(0)
+	}
+	err = setDataAccessPermissions(stageCtx, refinedData.Hash, accessPrice, variant.Public)
+	end(err)
 	if err != nil {
-		log.Printf("Warning: Failed to set access permissions: %v", err)
+		loggerFromContext(ctx).Warn("Failed to set access permissions", "variant", variant.Name, "error", err)
 	}
 
 	return refinedData, nil
@@ -162,7 +241,7 @@ func uploadRefinedDataToIPFS(refinedData *RefinedData) (string, error) {
 }
 
 // Grant data access to specific address
-func grantDataAccess(datasetId [32]byte, userAddress common.Address, duration *big.Int) error {
+func grantDataAccess(ctx context.Context, datasetId [32]byte, userAddress common.Address, duration *big.Int) error {
 	if ethClient == nil || queryEngineAddress == (common.Address{}) {
 		return fmt.Errorf("QueryEngine not configured")
 	}
@@ -175,8 +254,9 @@ func grantDataAccess(datasetId [32]byte, userAddress common.Address, duration *b
 		ethClient,
 	)
 
-	tx, err := contract.Transact(
-		backendAuth,
+	tx, err := transactContract(
+		ctx,
+		contract,
 		"grantAccess",
 		datasetId,
 		userAddress,
@@ -186,7 +266,8 @@ func grantDataAccess(datasetId [32]byte, userAddress common.Address, duration *b
 		return fmt.Errorf("failed to grant access: %v", err)
 	}
 
-	log.Printf("Granted data access to %s: %s", userAddress.Hex(), tx.Hash())
+	trackTransaction(ctx, "grantAccess", tx, nil)
+	loggerFromContext(ctx).Info("Granted data access", "user", userAddress.Hex(), "txHash", tx.Hash().Hex())
 	return nil
 }
 
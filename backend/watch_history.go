@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Kinds of watch history entries
+const (
+	WATCH_ENTRY_VIDEO   = "video"
+	WATCH_ENTRY_AD      = "ad"
+	WATCH_ENTRY_REMOVED = "removed"
+)
+
+const (
+	TAKEOUT_WATCHED_PREFIX = "Watched "
+	TAKEOUT_ADS_DETAIL     = "From Google Ads"
+)
+
+// Hosts serving YouTube videos, without "www."
+var youtubeHosts = map[string]bool{
+	"youtube.com":          true,
+	"m.youtube.com":        true,
+	"music.youtube.com":    true,
+	"gaming.youtube.com":   true,
+	"youtube-nocookie.com": true,
+	"youtu.be":             true,
+}
+
+// Paths whose next segment is a video ID
+var videoPathPrefixes = []string{"/shorts/", "/embed/", "/live/", "/v/", "/e/", "/watch/"}
+
+// Extract the video ID from any YouTube URL shape: watch pages on every
+// YouTube host, youtu.be links, Shorts, embeds, live streams and
+// attribution links. Returns "" for anything that is not a valid video URL.
+func extractVideoID(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return ""
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	if !youtubeHosts[host] {
+		return ""
+	}
+
+	var candidate string
+	switch {
+	case host == "youtu.be":
+		candidate, _, _ = strings.Cut(strings.TrimPrefix(parsed.Path, "/"), "/")
+	case strings.TrimSuffix(parsed.Path, "/") == "/watch":
+		candidate = parsed.Query().Get("v")
+	case parsed.Path == "/attribution_link":
+		// u holds a relative watch URL, e.g. /watch?v=ID&feature=share
+		return extractVideoID("https://youtube.com" + parsed.Query().Get("u"))
+	default:
+		for _, prefix := range videoPathPrefixes {
+			if rest, ok := strings.CutPrefix(parsed.Path, prefix); ok {
+				candidate, _, _ = strings.Cut(rest, "/")
+				break
+			}
+		}
+	}
+
+	if youtubeVideoIDPattern.MatchString(candidate) {
+		return candidate
+	}
+	return ""
+}
+
+// Extract a channel reference from a channel URL: the UC… ID of /channel/
+// URLs or the @handle of handle URLs. Legacy /c/ and /user/ URLs carry
+// neither and return "".
+func channelRefFromURL(rawURL string) string {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+
+	var ref string
+	if id, ok := strings.CutPrefix(parsed.Path, "/channel/"); ok {
+		ref, _, _ = strings.Cut(id, "/")
+	} else if strings.HasPrefix(parsed.Path, "/@") {
+		ref, _, _ = strings.Cut(strings.TrimPrefix(parsed.Path, "/"), "/")
+		if unescaped, err := url.PathUnescape(ref); err == nil {
+			ref = unescaped
+		}
+	}
+
+	if youtubeChannelPattern.MatchString(ref) {
+		return ref
+	}
+	return ""
+}
+
+// Map a watch-history activity to a schema entry and classify it. Takeout
+// links every available video, so a "Watched ..." activity without a video
+// link is a removed or private video. Activities naming neither a video nor
+// a title are unusable.
+func (a takeoutActivity) watchEntry() (WatchHistoryEntry, bool) {
+	title := strings.TrimSpace(a.Title)
+	entry := WatchHistoryEntry{
+		VideoID:   extractVideoID(a.TitleURL),
+		Title:     strings.TrimPrefix(title, TAKEOUT_WATCHED_PREFIX),
+		Product:   a.Header,
+		WatchTime: a.timestamp(),
+	}
+
+	// Takeout prints the URL as the title when the video title is unavailable
+	if id := extractVideoID(entry.Title); id != "" {
+		if entry.VideoID == "" {
+			entry.VideoID = id
+		}
+		entry.Title = ""
+	}
+
+	if len(a.Subtitles) > 0 {
+		entry.ChannelName = strings.TrimSpace(a.Subtitles[0].Name)
+		entry.ChannelID = channelRefFromURL(a.Subtitles[0].URL)
+	}
+
+	switch {
+	case a.isAd():
+		entry.Kind = WATCH_ENTRY_AD
+	case entry.VideoID != "":
+		entry.Kind = WATCH_ENTRY_VIDEO
+	case strings.HasPrefix(title, TAKEOUT_WATCHED_PREFIX):
+		entry.Kind = WATCH_ENTRY_REMOVED
+		entry.Title = ""
+	case entry.Title != "":
+		entry.Kind = WATCH_ENTRY_VIDEO
+	default:
+		return WatchHistoryEntry{}, false
+	}
+	return entry, true
+}
+
+func (a takeoutActivity) isAd() bool {
+	for _, detail := range a.Details {
+		if strings.TrimSpace(detail.Name) == TAKEOUT_ADS_DETAIL {
+			return true
+		}
+	}
+	return false
+}
+
+// Read a Takeout activity from a decoded JSON object
+func takeoutActivityFromMap(data map[string]interface{}) takeoutActivity {
+	activity := takeoutActivity{
+		Header:    firstString(data, "header"),
+		Title:     firstString(data, "title"),
+		TitleURL:  firstString(data, "titleUrl"),
+		Time:      firstString(data, "time"),
+		Subtitles: takeoutSubtitlesFromValue(data["subtitles"]),
+		Details:   takeoutSubtitlesFromValue(data["details"]),
+	}
+	return activity
+}
+
+func takeoutSubtitlesFromValue(value interface{}) []takeoutSubtitle {
+	items, _ := value.([]interface{})
+	subtitles := make([]takeoutSubtitle, 0, len(items))
+	for _, item := range items {
+		if fields, ok := item.(map[string]interface{}); ok {
+			subtitles = append(subtitles, takeoutSubtitle{
+				Name: firstString(fields, "name"),
+				URL:  firstString(fields, "url"),
+			})
+		}
+	}
+	return subtitles
+}
+
+// Record how many watch entries of each kind a schema holds
+func countWatchEntryKinds(schema *YouTubeDataSchema) {
+	counts := map[string]int{}
+	for _, entry := range schema.WatchHistory {
+		counts[entry.Kind]++
+	}
+	schema.Metadata["adEntries"] = counts[WATCH_ENTRY_AD]
+	schema.Metadata["removedEntries"] = counts[WATCH_ENTRY_REMOVED]
+}
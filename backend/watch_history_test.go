@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Add a case to these tables for every URL shape or Takeout quirk found in
+// the wild
+func TestExtractVideoID(t *testing.T) {
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?feature=share&v=dQw4w9WgXcQ&t=42s", "dQw4w9WgXcQ"},
+		{"http://youtube.com/watch/?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ&list=RDAMVM", "dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ?si=abc&t=10", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtube.com/shorts/dQw4w9WgXcQ?feature=share", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ?autoplay=1", "dQw4w9WgXcQ"},
+		{"https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/live/dQw4w9WgXcQ?si=x", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/v/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/attribution_link?a=x&u=%2Fwatch%3Fv%3DdQw4w9WgXcQ%26feature%3Dshare", "dQw4w9WgXcQ"},
+		{"www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?v=short", ""},
+		{"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", ""},
+		{"https://www.youtube.com/results?search_query=dQw4w9WgXcQ", ""},
+		{"https://vimeo.com/watch?v=dQw4w9WgXcQ", ""},
+		{"https://notyoutube.com/watch?v=dQw4w9WgXcQ", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if got := extractVideoID(c.url); got != c.want {
+			t.Errorf("extractVideoID(%q) = %q, want %q", c.url, got, c.want)
+		}
+	}
+}
+
+func TestChannelRefFromURL(t *testing.T) {
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", "UCuAXFkgsw1L7xaCfnd5JJOw"},
+		{"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw/videos", "UCuAXFkgsw1L7xaCfnd5JJOw"},
+		{"https://www.youtube.com/@RickAstleyYT", "@RickAstleyYT"},
+		{"https://www.youtube.com/@RickAstleyYT/shorts", "@RickAstleyYT"},
+		{"https://www.youtube.com/c/RickAstley", ""},
+		{"https://www.youtube.com/user/RickAstleyVEVO", ""},
+		{"https://www.youtube.com/channel/not-a-channel", ""},
+	}
+	for _, c := range cases {
+		if got := channelRefFromURL(c.url); got != c.want {
+			t.Errorf("channelRefFromURL(%q) = %q, want %q", c.url, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeWatchHistoryEntry(t *testing.T) {
+	cases := []struct {
+		name     string
+		activity string
+		want     *WatchHistoryEntry
+	}{
+		{
+			name: "takeout video",
+			activity: `{"header":"YouTube","title":"Watched Never Gonna Give You Up","titleUrl":"https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+				"subtitles":[{"name":"Rick Astley","url":"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw"}],"time":"2024-01-02T03:04:05.678Z"}`,
+			want: &WatchHistoryEntry{VideoID: "dQw4w9WgXcQ", Title: "Never Gonna Give You Up", ChannelName: "Rick Astley",
+				ChannelID: "UCuAXFkgsw1L7xaCfnd5JJOw", Product: "YouTube", Kind: WATCH_ENTRY_VIDEO},
+		},
+		{
+			name:     "youtube music",
+			activity: `{"header":"YouTube Music","title":"Watched Song","titleUrl":"https://music.youtube.com/watch?v=dQw4w9WgXcQ","subtitles":[{"name":"Artist - Topic","url":"https://www.youtube.com/@artist"}],"time":"2024-01-02T03:04:05Z"}`,
+			want:     &WatchHistoryEntry{VideoID: "dQw4w9WgXcQ", Title: "Song", ChannelName: "Artist - Topic", ChannelID: "@artist", Product: "YouTube Music", Kind: WATCH_ENTRY_VIDEO},
+		},
+		{
+			name:     "shorts",
+			activity: `{"header":"YouTube","title":"Watched Short clip","titleUrl":"https://www.youtube.com/shorts/dQw4w9WgXcQ","time":"2024-01-02T03:04:05Z"}`,
+			want:     &WatchHistoryEntry{VideoID: "dQw4w9WgXcQ", Title: "Short clip", Product: "YouTube", Kind: WATCH_ENTRY_VIDEO},
+		},
+		{
+			name:     "title unavailable",
+			activity: `{"header":"YouTube","title":"Watched https://www.youtube.com/watch?v=dQw4w9WgXcQ","titleUrl":"https://www.youtube.com/watch?v=dQw4w9WgXcQ","time":"2024-01-02T03:04:05Z"}`,
+			want:     &WatchHistoryEntry{VideoID: "dQw4w9WgXcQ", Product: "YouTube", Kind: WATCH_ENTRY_VIDEO},
+		},
+		{
+			name:     "legacy url in title",
+			activity: `{"title":"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=5","time":"2024-01-02T03:04:05Z"}`,
+			want:     &WatchHistoryEntry{VideoID: "dQw4w9WgXcQ", Kind: WATCH_ENTRY_VIDEO},
+		},
+		{
+			name:     "removed video",
+			activity: `{"header":"YouTube","title":"Watched a video that has been removed","time":"2024-01-02T03:04:05Z"}`,
+			want:     &WatchHistoryEntry{Product: "YouTube", Kind: WATCH_ENTRY_REMOVED},
+		},
+		{
+			name: "ad",
+			activity: `{"header":"YouTube","title":"Watched Buy things","titleUrl":"https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+				"details":[{"name":"From Google Ads"}],"time":"2024-01-02T03:04:05Z"}`,
+			want: &WatchHistoryEntry{VideoID: "dQw4w9WgXcQ", Title: "Buy things", Product: "YouTube", Kind: WATCH_ENTRY_AD},
+		},
+		{
+			name:     "extension export with progress",
+			activity: `{"title":"Talk","videoId":"dQw4w9WgXcQ","duration":600,"watchedSeconds":150}`,
+			want:     &WatchHistoryEntry{VideoID: "dQw4w9WgXcQ", Title: "Talk", Duration: 600, PercentWatched: 25, Kind: WATCH_ENTRY_VIDEO},
+		},
+		{
+			name:     "percent capped",
+			activity: `{"title":"Watched Talk","titleUrl":"https://youtu.be/dQw4w9WgXcQ","durationSeconds":60,"percentWatched":140}`,
+			want:     &WatchHistoryEntry{VideoID: "dQw4w9WgXcQ", Title: "Talk", Duration: 60, PercentWatched: 100, Kind: WATCH_ENTRY_VIDEO},
+		},
+		{
+			name:     "empty",
+			activity: `{"header":"YouTube","time":"2024-01-02T03:04:05Z"}`,
+			want:     nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(c.activity), &data); err != nil {
+				t.Fatalf("invalid activity JSON: %v", err)
+			}
+
+			got := normalizeWatchHistoryEntry(data)
+			if got != nil {
+				// Timestamps are parsed by takeoutActivity; compare the rest
+				got.WatchTime = time.Time{}
+			}
+			switch {
+			case got == nil && c.want == nil:
+			case got == nil || c.want == nil || *got != *c.want:
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	WS_SUBPROTOCOL        = "tubedao.events.v1"
+	WS_TOKEN_PROTOCOL     = "bearer."
+	WS_MAX_MESSAGE_BYTES  = 512 * 1024
+	WS_SEND_BUFFER        = 64
+	WS_WRITE_WAIT         = 10 * time.Second
+	WS_PONG_WAIT          = 60 * time.Second
+	WS_PING_PERIOD        = 50 * time.Second
+	WS_CLOSE_GRACE_PERIOD = time.Second
+	// How stale a connection's view of consent may get. Revocations handled
+	// by other instances only reach it through the store.
+	WS_CONSENT_RECHECK = 10 * time.Second
+
+	NOTICE_CONSENT_REVOKED  = "consent_revoked"
+	NOTICE_SESSION_EXPIRED  = "session_expired"
+	NOTICE_REWARD_CREDITED  = "reward_credited"
+	NOTICE_UPLOAD_PROCESSED = "upload_processed"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	Subprotocols:    []string{WS_SUBPROTOCOL},
+	CheckOrigin:     checkWebSocketOrigin,
+}
+
+// Accept handshakes from the origins listed in WS_ALLOWED_ORIGINS, such as
+// the extension's chrome-extension:// origin. Clients other than browsers
+// send no Origin and are accepted.
+func checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(os.Getenv("WS_ALLOWED_ORIGINS"), ",") {
+		if strings.TrimSpace(allowed) == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Connected collectors by wallet address, used to push notices
+type noticeHub struct {
+	mu      sync.RWMutex
+	clients map[string]map[*wsClient]struct{}
+}
+
+var notices = &noticeHub{clients: make(map[string]map[*wsClient]struct{})}
+
+func (h *noticeHub) register(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.clients[client.address] == nil {
+		h.clients[client.address] = make(map[*wsClient]struct{})
+	}
+	h.clients[client.address][client] = struct{}{}
+}
+
+func (h *noticeHub) unregister(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients[client.address], client)
+	if len(h.clients[client.address]) == 0 {
+		delete(h.clients, client.address)
+	}
+}
+
+// Send a notice to every connection of address
+func (h *noticeHub) Publish(address string, notice Notice) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients[address] {
+		client.notify(notice)
+	}
+}
+
+// Notify and disconnect every connection authenticated with token, e.g.
+// after logout
+func (h *noticeHub) ExpireToken(token string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, clients := range h.clients {
+		for client := range clients {
+			if client.token == token {
+				client.notify(Notice{Type: NOTICE_SESSION_EXPIRED})
+				client.closeAfterFlush()
+			}
+		}
+	}
+}
+
+// Accept events again on every connection of address after consent is
+// granted
+func (h *noticeHub) RestoreConsent(address string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients[address] {
+		client.consentRevoked.Store(false)
+	}
+}
+
+// Close every connection, used during shutdown
+func (h *noticeHub) CloseAll() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, clients := range h.clients {
+		for client := range clients {
+			client.closeAfterFlush()
+		}
+	}
+}
+
+// Number of open connections
+func (h *noticeHub) Count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	count := 0
+	for _, clients := range h.clients {
+		count += len(clients)
+	}
+	return count
+}
+
+// One collector connection. All writes go through send so only the write
+// loop touches the socket.
+type wsClient struct {
+	conn    *websocket.Conn
+	address string
+	token   string
+	send    chan interface{}
+
+	consentRevoked atomic.Bool
+	// When consent was last read from the store; only the read loop uses it
+	consentCheckedAt time.Time
+	closing          chan struct{}
+	closeOnce        sync.Once
+}
+
+// Queue a message, dropping the connection if the client cannot keep up
+func (client *wsClient) enqueue(message interface{}) {
+	select {
+	case <-client.closing:
+	case client.send <- message:
+	default:
+		client.close()
+	}
+}
+
+func (client *wsClient) notify(notice Notice) {
+	if notice.Type == NOTICE_CONSENT_REVOKED {
+		client.consentRevoked.Store(true)
+	}
+	notice.Kind = "notice"
+	notice.Timestamp = time.Now()
+	client.enqueue(notice)
+}
+
+// Signal the write loop to send a close frame and shut the socket
+func (client *wsClient) close() {
+	client.closeOnce.Do(func() {
+		close(client.closing)
+	})
+}
+
+// Give the write loop a moment to deliver queued messages before closing
+func (client *wsClient) closeAfterFlush() {
+	time.AfterFunc(WS_CLOSE_GRACE_PERIOD, client.close)
+}
+
+// Move a token passed as ?token= or as a "bearer.<token>" subprotocol into
+// the Authorization header so jwtAuthMiddleware can verify it. Clients using
+// the subprotocol form must also offer WS_SUBPROTOCOL, which the server
+// selects.
+func wsTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			token := c.Query("token")
+			for _, protocol := range websocket.Subprotocols(c.Request) {
+				if strings.HasPrefix(protocol, WS_TOKEN_PROTOCOL) {
+					token = strings.TrimPrefix(protocol, WS_TOKEN_PROTOCOL)
+				}
+			}
+			if token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		c.Next()
+	}
+}
+
+// Upgrade to a WebSocket over which the collector streams event batches,
+// each acknowledged by sequence number, and receives notices
+func serveEventSocket(c *gin.Context) {
+	address := c.GetString("address")
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	if !requireEventConsent(c, address) {
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade already wrote an HTTP error response
+		loggerFromContext(c.Request.Context()).Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	client := &wsClient{
+		conn:             conn,
+		address:          address,
+		token:            token,
+		send:             make(chan interface{}, WS_SEND_BUFFER),
+		consentCheckedAt: time.Now(),
+		closing:          make(chan struct{}),
+	}
+
+	notices.register(client)
+	defer notices.unregister(client)
+	defer client.close()
+
+	if expiresAt, ok := c.Get("tokenExpiresAt"); ok {
+		timer := time.AfterFunc(time.Until(expiresAt.(time.Time)), func() {
+			client.notify(Notice{Type: NOTICE_SESSION_EXPIRED})
+			client.closeAfterFlush()
+		})
+		defer timer.Stop()
+	}
+
+	go client.writeLoop()
+	client.readLoop(c.Request.Context())
+}
+
+func (client *wsClient) readLoop(ctx context.Context) {
+	logger := loggerFromContext(ctx).With("address", client.address)
+
+	client.conn.SetReadLimit(WS_MAX_MESSAGE_BYTES)
+	client.conn.SetReadDeadline(time.Now().Add(WS_PONG_WAIT))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(WS_PONG_WAIT))
+	})
+
+	for {
+		_, data, err := client.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Warn("WebSocket closed unexpectedly", "error", err)
+			}
+			return
+		}
+
+		var message WSClientMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			client.enqueue(WSError{Kind: "error", Code: ERR_INVALID_REQUEST, Detail: fmt.Sprintf("invalid message: %v", err)})
+			continue
+		}
+
+		switch message.Type {
+		case "events":
+			client.handleEvents(ctx, message)
+		case "ping":
+			client.enqueue(WSAck{Kind: "pong", Seq: message.Seq})
+		default:
+			client.enqueue(WSError{Kind: "error", Seq: message.Seq, Code: ERR_INVALID_REQUEST, Detail: fmt.Sprintf("unknown message type %q", message.Type)})
+		}
+	}
+}
+
+// Store one batch of events and acknowledge it by sequence number
+func (client *wsClient) handleEvents(ctx context.Context, message WSClientMessage) {
+	fail := func(code ErrorCode, detail string) {
+		client.enqueue(WSError{Kind: "error", Seq: message.Seq, Code: code, Detail: detail})
+	}
+
+	if time.Since(client.consentCheckedAt) > WS_CONSENT_RECHECK {
+		granted, err := eventConsentGranted(ctx, client.address)
+		if err != nil {
+			fail(ERR_INTERNAL, "Failed to check consent")
+			return
+		}
+		client.consentCheckedAt = time.Now()
+		if !granted && !client.consentRevoked.Load() {
+			client.notify(Notice{Type: NOTICE_CONSENT_REVOKED})
+		}
+		client.consentRevoked.Store(!granted)
+	}
+
+	switch {
+	case client.consentRevoked.Load():
+		fail(ERR_CONSENT_REVOKED, "Event collection consent has been revoked")
+		return
+	case len(message.Events) == 0:
+		fail(ERR_EMPTY_BATCH, "No events provided")
+		return
+	case len(message.Events) > MAX_EVENTS_PER_BATCH:
+		fail(ERR_BATCH_TOO_LARGE, fmt.Sprintf("Too many events in batch (max %d)", MAX_EVENTS_PER_BATCH))
+		return
+	}
+
+	batchID := message.BatchID
+	if batchID == "" {
+		batchID = fmt.Sprintf("ws-%d", message.Seq)
+	}
+
+	result, err := ingestEventBatch(ctx, client.address, batchID, message.Events)
+	if err != nil {
+		fail(ERR_INTERNAL, "Failed to upload events")
+		return
+	}
+
+	client.enqueue(WSAck{
+		Kind:           "ack",
+		Seq:            message.Seq,
+		BatchID:        batchID,
+		AcceptedCount:  result.AcceptedCount,
+		DuplicateCount: result.DuplicateCount,
+		RejectedCount:  len(result.Rejected),
+		Rejected:       result.Rejected,
+	})
+}
+
+func (client *wsClient) writeLoop() {
+	ticker := time.NewTicker(WS_PING_PERIOD)
+	defer ticker.Stop()
+	defer client.conn.Close()
+	defer client.close()
+
+	for {
+		select {
+		case <-client.closing:
+			client.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(WS_WRITE_WAIT))
+			return
+		case message := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_WAIT))
+			data, err := json.Marshal(message)
+			if err != nil {
+				continue
+			}
+			if err := client.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := client.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WS_WRITE_WAIT)); err != nil {
+				return
+			}
+		}
+	}
+}
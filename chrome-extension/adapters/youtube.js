@@ -79,6 +79,7 @@ class YouTubeAdapter extends BaseAdapter {
       video_quality: TubeDAOUtils.getVideoQuality(video),
       is_paused: video ? video.paused : true,
       is_muted: video ? video.muted : false,
+      ...TubeDAOUtils.getChannelInfo(),
       ...additionalData
     };
 
@@ -141,6 +142,7 @@ class YouTubeAdapter extends BaseAdapter {
     const eventData = {
       video_id: TubeDAOUtils.extractVideoId(),
       ad_position: this.getAdPosition(),
+      ...TubeDAOUtils.getChannelInfo(),
       ...additionalData
     };
 
@@ -191,6 +193,7 @@ class YouTubeAdapter extends BaseAdapter {
       element_type: element.tagName.toLowerCase(),
       current_time_seconds: videoId && currentTime ? Math.round(currentTime * 100) / 100 : null,
       current_time_formatted: videoId && currentTime ? TubeDAOUtils.formatTime(currentTime) : null,
+      ...TubeDAOUtils.getChannelInfo(),
       ...additionalData
     });
   }
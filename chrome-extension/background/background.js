@@ -5,6 +5,13 @@ class TubeDAOBackground {
     this.isUnlocked = false;
     this.TUBEDAO_HOMEPAGE = 'http://localhost:3000';
     this.BACKEND_API = 'http://localhost:8080/api'; // Make sure this matches your backend port
+    this.EVENTS_SOCKET = 'ws://localhost:8080/api/events/ws';
+    this.SOCKET_PROTOCOL = 'tubedao.events.v1';
+    this.SOCKET_ACK_TIMEOUT = 15000;
+    this.SOCKET_RECONNECT_DELAY = 5000;
+    this.socket = null;
+    this.socketSeq = 0;
+    this.pendingAcks = new Map();
     this.VANA_MOKSHA_CHAIN_ID = 14800;
     
     console.log('TubeDAO Background Service Worker starting...');
@@ -84,6 +91,7 @@ class TubeDAOBackground {
 
   async clearAuthData() {
     await chrome.storage.session.clear();
+    this.closeEventSocket();
     this.isUnlocked = false;
     this.blockAllFeatures();
   }
@@ -173,6 +181,7 @@ class TubeDAOBackground {
   }
 
   notifyUnlocked() {
+    this.connectEventSocket();
     console.log('Broadcasting AUTH_SUCCESS to all tabs');
     this.broadcastMessage({
       type: 'AUTH_SUCCESS',
@@ -269,6 +278,15 @@ class TubeDAOBackground {
           sendResponse({ success: true });
           break;
           
+        case 'SYNC_CONSENT':
+          if (this.isUnlocked) {
+            const result = await this.syncConsent(message.enabled);
+            sendResponse(result);
+          } else {
+            sendResponse({ error: 'Authentication required' });
+          }
+          break;
+
         case 'CONSENT_CHANGED':
           const tabs = await chrome.tabs.query({});
           for (const tab of tabs) {
@@ -294,7 +312,7 @@ class TubeDAOBackground {
           
         case 'UPLOAD_EVENTS':
           if (this.isUnlocked) {
-            const result = await this.handleEventUpload(message.events);
+            const result = await this.handleEventUpload(message.batchId, message.events);
             sendResponse(result);
           } else {
             sendResponse({ error: 'Authentication required' });
@@ -498,7 +516,7 @@ class TubeDAOBackground {
     return { success: true };
   }
 
-  async handleEventUpload(events) {
+  async handleEventUpload(batchId, events) {
     if (!this.isUnlocked) {
       return { error: 'Authentication required' };
     }
@@ -510,6 +528,23 @@ class TubeDAOBackground {
         return { error: 'Authentication required' };
       }
 
+      const uploadEvents = events.map((event) => this.toUploadEvent(event));
+
+      // Prefer the open socket; fall back to HTTP when it is unavailable
+      const ack = await this.sendOverSocket(batchId, uploadEvents).catch((error) => {
+        if (error.code === 'consent_revoked') {
+          throw error;
+        }
+        console.log('Socket upload unavailable, using HTTP:', error.message);
+        return null;
+      });
+      if (ack) {
+        if (ack.rejectedCount > 0) {
+          console.warn('Backend rejected some events:', ack.rejected);
+        }
+        return { success: true, data: ack };
+      }
+
       const response = await fetch(`${this.BACKEND_API}/events/upload`, {
         method: 'POST',
         headers: {
@@ -518,25 +553,211 @@ class TubeDAOBackground {
         },
         body: JSON.stringify({
           address: sessionData.tubedao_address,
-          events: events
+          batchId,
+          events: uploadEvents
         })
       });
 
       if (!response.ok) {
-        const errorData = await response.json();
-        throw new Error(errorData.error || 'Failed to upload events');
+        const problem = await response.json().catch(() => ({}));
+        const error = new Error(problem.detail || problem.title || 'Failed to upload events');
+        error.code = problem.code;
+        error.status = response.status;
+        throw error;
       }
 
       const result = await response.json();
       console.log('Events uploaded successfully:', result);
+      if (result.rejectedCount > 0) {
+        console.warn('Backend rejected some events:', result.rejected);
+      }
       
       return { success: true, data: result };
     } catch (error) {
       console.error('Event upload failed:', error);
+      return { error: error.message, code: error.code, retryable: this.isRetryableUploadError(error) };
+    }
+  }
+
+  // Network failures, rate limiting and server errors may succeed later;
+  // any other rejection, such as invalid events or revoked consent, will not
+  isRetryableUploadError(error) {
+    if (error.code === 'consent_revoked') {
+      return false;
+    }
+    if (error.status === undefined) {
+      return true;
+    }
+    return error.status === 429 || error.status >= 500;
+  }
+
+  // Open the event socket. The token travels as a subprotocol because
+  // browsers cannot set headers on WebSocket handshakes.
+  async connectEventSocket() {
+    if (this.socket && this.socket.readyState <= WebSocket.OPEN) {
+      return;
+    }
+
+    const sessionData = await chrome.storage.session.get(['tubedao_auth_token']);
+    if (!sessionData.tubedao_auth_token) {
+      return;
+    }
+
+    let socket;
+    try {
+      socket = new WebSocket(this.EVENTS_SOCKET, [
+        this.SOCKET_PROTOCOL,
+        `bearer.${sessionData.tubedao_auth_token}`
+      ]);
+    } catch (error) {
+      console.error('Failed to open event socket:', error);
+      return;
+    }
+    this.socket = socket;
+
+    socket.onmessage = (event) => this.handleSocketMessage(event.data);
+    socket.onclose = () => {
+      if (this.socket !== socket) return;
+      this.socket = null;
+      this.rejectPendingAcks(new Error('Event socket closed'));
+      if (this.isUnlocked) {
+        setTimeout(() => this.isUnlocked && this.connectEventSocket(), this.SOCKET_RECONNECT_DELAY);
+      }
+    };
+  }
+
+  closeEventSocket() {
+    const socket = this.socket;
+    this.socket = null;
+    this.rejectPendingAcks(new Error('Event socket closed'));
+    if (socket) {
+      socket.close(1000);
+    }
+  }
+
+  rejectPendingAcks(error) {
+    for (const pending of this.pendingAcks.values()) {
+      clearTimeout(pending.timer);
+      pending.reject(error);
+    }
+    this.pendingAcks.clear();
+  }
+
+  // Send a batch over the socket and resolve with its acknowledgement
+  sendOverSocket(batchId, events) {
+    if (!this.socket || this.socket.readyState !== WebSocket.OPEN) {
+      return Promise.reject(new Error('Event socket not connected'));
+    }
+
+    const seq = ++this.socketSeq;
+    return new Promise((resolve, reject) => {
+      const timer = setTimeout(() => {
+        this.pendingAcks.delete(seq);
+        reject(new Error('Timed out waiting for acknowledgement'));
+      }, this.SOCKET_ACK_TIMEOUT);
+
+      this.pendingAcks.set(seq, { resolve, reject, timer });
+      this.socket.send(JSON.stringify({ type: 'events', seq, batchId, events }));
+    });
+  }
+
+  async handleSocketMessage(data) {
+    let message;
+    try {
+      message = JSON.parse(data);
+    } catch (error) {
+      console.error('Invalid event socket message:', error);
+      return;
+    }
+
+    if (message.type === 'notice') {
+      await this.handleNotice(message);
+      return;
+    }
+
+    const pending = this.pendingAcks.get(message.seq);
+    if (!pending) return;
+    this.pendingAcks.delete(message.seq);
+    clearTimeout(pending.timer);
+
+    if (message.type === 'ack') {
+      pending.resolve(message);
+    } else {
+      const error = new Error(message.detail || 'Failed to upload events');
+      error.code = message.code;
+      pending.reject(error);
+    }
+  }
+
+  async handleNotice(notice) {
+    switch (notice.notice) {
+      case 'consent_revoked':
+        await chrome.storage.local.set({ consentEnabled: false });
+        this.broadcastMessage({ type: 'CONSENT_CHANGED', enabled: false });
+        this.showNotification('TubeDAO', 'Data collection consent was revoked. Capture has stopped.');
+        break;
+
+      case 'session_expired':
+        await this.clearAuthData();
+        break;
+
+      case 'reward_credited':
+        this.showNotification('TubeDAO', `Reward credited: ${notice.data?.rewardAmount ?? 0} tokens`);
+        break;
+
+      default:
+        console.log('Unknown notice:', notice.notice);
+    }
+  }
+
+  // Store the consent setting on the backend so every device respects it
+  async syncConsent(enabled) {
+    try {
+      const sessionData = await chrome.storage.session.get(['tubedao_auth_token', 'tubedao_address']);
+
+      const response = await fetch(`${this.BACKEND_API}/user/${sessionData.tubedao_address}/consent`, {
+        method: 'PUT',
+        headers: {
+          'Authorization': `Bearer ${sessionData.tubedao_auth_token}`,
+          'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({ enabled })
+      });
+
+      if (!response.ok) {
+        const problem = await response.json().catch(() => ({}));
+        throw new Error(problem.detail || problem.title || 'Failed to update consent');
+      }
+
+      return { success: true, data: await response.json() };
+    } catch (error) {
+      console.error('Consent sync failed:', error);
       return { error: error.message };
     }
   }
 
+  // Convert a locally stored event into the typed upload schema: envelope
+  // fields in camelCase, adapter-specific fields under eventData
+  toUploadEvent(event) {
+    const {
+      event_id, event_type, category, timestamp, session_id, page_url,
+      site, adapter, user_agent, viewport, ...eventData
+    } = event;
+
+    return {
+      eventId: event_id,
+      eventType: event_type,
+      category,
+      timestamp,
+      sessionId: session_id,
+      pageUrl: page_url,
+      adapter,
+      userAgent: user_agent,
+      viewport,
+      eventData
+    };
+  }
+
   showNotification(title, message) {
     chrome.notifications.create({
       type: 'basic',
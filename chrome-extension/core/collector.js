@@ -3,6 +3,8 @@ class UniversalCollector {
     this.registry = registry;
     this.sessionId = this.generateSessionId();
     this.eventBuffer = [];
+    this.pendingBatches = [];
+    this.MAX_PENDING_BATCHES = 20;
     this.isUnlocked = false;
     this.isUploading = false;
     this.currentAdapter = null;
@@ -442,6 +444,7 @@ class UniversalCollector {
     if (!this.isUnlocked || !this.consentEnabled) return;
 
     const event = {
+      event_id: this.generateId(),
       event_type: eventType,
       category: category,
       timestamp: new Date().toISOString(),
@@ -473,7 +476,7 @@ class UniversalCollector {
   }
 
   async flushEvents() {
-    if (this.eventBuffer.length === 0 || this.isUploading) return;
+    if ((this.eventBuffer.length === 0 && this.pendingBatches.length === 0) || this.isUploading) return;
     
     this.isUploading = true;
     const eventsToUpload = [...this.eventBuffer];
@@ -485,24 +488,49 @@ class UniversalCollector {
         return;
       }
 
-      const result = await chrome.storage.local.get(['tubeDAOEvents']);
-      const existingEvents = result.tubeDAOEvents || [];
-      const updatedEvents = [...existingEvents, ...eventsToUpload];
-      await chrome.storage.local.set({ tubeDAOEvents: updatedEvents });
+      if (eventsToUpload.length > 0) {
+        const result = await chrome.storage.local.get(['tubeDAOEvents']);
+        const existingEvents = result.tubeDAOEvents || [];
+        const updatedEvents = [...existingEvents, ...eventsToUpload];
+        await chrome.storage.local.set({ tubeDAOEvents: updatedEvents });
 
-      try {
-        const uploadResult = await chrome.runtime.sendMessage({
-          type: 'UPLOAD_EVENTS',
-          events: eventsToUpload
-        });
+        this.pendingBatches.push({ batchId: this.generateId(), events: eventsToUpload });
+      }
 
-        if (uploadResult.success) {
-          console.log('Events uploaded successfully:', uploadResult.data);
-        } else {
-          console.log('Event upload failed, keeping local copy:', uploadResult.error);
+      // Failed batches are retried with the same batch and event IDs; the
+      // backend skips events it already stored. Batches the backend rejected
+      // outright are dropped, since resending them would fail the same way.
+      const batches = this.pendingBatches.splice(0);
+      for (const batch of batches) {
+        let uploaded = false;
+        let retryable = true;
+        try {
+          const uploadResult = await chrome.runtime.sendMessage({
+            type: 'UPLOAD_EVENTS',
+            batchId: batch.batchId,
+            events: batch.events
+          });
+
+          uploaded = uploadResult.success;
+          if (uploaded) {
+            console.log('Events uploaded successfully:', uploadResult.data);
+          } else if (uploadResult.retryable === false) {
+            retryable = false;
+            console.warn('Event upload rejected, dropping batch:', uploadResult.error);
+          } else {
+            console.log('Event upload failed, will retry:', uploadResult.error);
+          }
+        } catch (uploadError) {
+          console.log('Event upload failed, will retry:', uploadError);
         }
-      } catch (uploadError) {
-        console.log('Event upload failed, keeping local copy:', uploadError);
+
+        if (!uploaded && retryable) {
+          this.pendingBatches.push(batch);
+        }
+      }
+
+      if (this.pendingBatches.length > this.MAX_PENDING_BATCHES) {
+        this.pendingBatches.splice(0, this.pendingBatches.length - this.MAX_PENDING_BATCHES);
       }
     } catch (error) {
       console.error('Failed to flush events:', error);
@@ -531,6 +559,13 @@ class UniversalCollector {
     }
   }
 
+  generateId() {
+    if (crypto && crypto.randomUUID) {
+      return crypto.randomUUID();
+    }
+    return Date.now().toString(36) + '-' + Math.random().toString(36).substr(2, 12);
+  }
+
   generateSessionId() {
     return 'session_' + Date.now() + '_' + Math.random().toString(36).substr(2, 9);
   }
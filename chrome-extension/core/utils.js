@@ -4,6 +4,22 @@ const TubeDAOUtils = {
     return urlObj.searchParams.get('v');
   },
 
+  // Channel of the video on a watch page, read from the owner link under
+  // the player. Returns an empty object when the page has not rendered it.
+  getChannelInfo() {
+    const link = document.querySelector('ytd-watch-metadata ytd-channel-name a, #owner #channel-name a');
+    if (!link) return {};
+
+    const path = new URL(link.href, window.location.origin).pathname;
+    const match = path.match(/^\/(@[^/]+)|^\/channel\/(UC[\w-]{22})/);
+    if (!match) return {};
+
+    return {
+      channel_id: match[2] || decodeURIComponent(match[1]),
+      channel_name: link.textContent.trim() || undefined
+    };
+  },
+
   getPageType(pathname) {
     if (pathname.includes('/watch')) return 'watch';
     if (pathname.includes('/shorts')) return 'shorts';
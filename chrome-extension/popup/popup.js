@@ -125,6 +125,10 @@ class TubeDAOPopup {
         this.handleAuthSuccess(message);
       } else if (message.type === 'AUTH_REQUIRED') {
         this.handleAuthRequired();
+      } else if (message.type === 'CONSENT_CHANGED') {
+        this.consentEnabled = message.enabled;
+        this.consentToggle.checked = message.enabled;
+        this.updateUI();
       }
     });
   }
@@ -138,6 +142,7 @@ class TubeDAOPopup {
 
     this.consentEnabled = enabled;
     await chrome.storage.local.set({ consentEnabled: enabled });
+    chrome.runtime.sendMessage({ type: 'SYNC_CONSENT', enabled }).catch(() => {});
     
     chrome.tabs.query({}, (tabs) => {
       tabs.forEach(tab => {
@@ -1,7 +1,14 @@
 // API utilities for TubeDAO backend communication with authentication
 
+import { keccak256, type Hex } from 'viem';
+import { privateKeyToAccount } from 'viem/accounts';
+
 const API_BASE_URL = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080/api';
 
+// Resumable uploads send files in chunks and retry a failed chunk a few times
+const UPLOAD_CHUNK_BYTES = 8 * 1024 * 1024;
+const UPLOAD_MAX_RETRIES = 3;
+
 export interface NonceResponse {
   nonce: string;
 }
@@ -38,11 +45,32 @@ export interface RegistrationStatus {
   expiresAt?: number;
 }
 
+// RFC 7807 problem details returned by every failing endpoint
 export interface APIError {
-  error: string;
-  needsReg?: boolean;
-  address?: string;
-  chainId?: number;
+  type: string;
+  title: string;
+  status: number;
+  detail?: string;
+  instance?: string;
+  code: string;
+  details?: unknown;
+  requestId?: string;
+}
+
+export class APIRequestError extends Error {
+  readonly code: string;
+  readonly status: number;
+  readonly details?: unknown;
+  readonly requestId?: string;
+
+  constructor(problem: APIError) {
+    super(problem.detail || problem.title);
+    this.name = 'APIRequestError';
+    this.code = problem.code;
+    this.status = problem.status;
+    this.details = problem.details;
+    this.requestId = problem.requestId;
+  }
 }
 
 export interface UserContribution {
@@ -51,10 +79,13 @@ export interface UserContribution {
   dataType: string;
   fileName: string;
   fileSize: number;
-  dataContent: Record<string, unknown>;
+  // Dropped once the contribution is revoked
+  dataContent: Record<string, unknown> | null;
   rewardAmount: number;
   timestamp: string;
   status: string;
+  revokedAt?: string;
+  variants?: { variant: string; dataHash: string; ipfsHash: string }[];
 }
 
 export interface UserRewards {
@@ -63,12 +94,237 @@ export interface UserRewards {
   totalDatasets: number;
 }
 
+export interface DataSchemaInfo {
+  dataType: string;
+  platform: string;
+  description: string;
+  maskingRules: string[];
+}
+
+// Masking rule name (as listed by getDataSchemas) to whether it is applied
+export type MaskingRules = Record<string, boolean>;
+
+export interface MaskingPreferences {
+  address: string;
+  maskingRules: MaskingRules;
+  updatedAt?: string;
+}
+
+export interface PrivacyBudget {
+  datasetId: string;
+  dataType: string;
+  epsilonTotal: number;
+  epsilonSpent: number;
+  epsilonRemaining: number;
+  deltaSpent: number;
+  queries: number;
+  registered: boolean;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export interface AggregateQuery {
+  dataType: string;
+  query: 'watch_time_by_hour' | 'channel_audience_overlap';
+  channels?: string[];
+  epsilon: number;
+  mechanism?: 'laplace' | 'gaussian';
+}
+
+export interface AggregateQueryResult {
+  datasetId: string;
+  query: string;
+  mechanism: string;
+  epsilon: number;
+  delta: number;
+  noiseStdDev: number;
+  values: { label: string; value: number }[];
+  budget: PrivacyBudget;
+}
+
+export interface KeyRelease {
+  releaseId: string;
+  datasetId: string;
+  buyer: string;
+  // Address of the key encryptedKey is encrypted to
+  encryptedTo: string;
+  algorithm: string;
+  // ECIES ciphertext of the dataset key, hex; decrypt with the private key
+  // from deriveEncryptionKey
+  encryptedKey: string;
+}
+
+// Text to personal_sign for releaseDatasetKey; addresses and IDs as returned by the API
+export function keyReleaseMessage(datasetId: string, buyer: string, issuedAt: string, encryptionKey: string): string {
+  return `TubeDAO dataset key request\nDataset: ${datasetId}\nBuyer: ${buyer}\nIssued At: ${issuedAt}\nEncryption Key: ${encryptionKey}`;
+}
+
+// Text to personal_sign for a buyer's deriveEncryptionKey
+export function buyerKeyMessage(address: string): string {
+  return `TubeDAO buyer encryption key\nAddress: ${address}`;
+}
+
+export interface ContributorKey {
+  address: string;
+  publicKey: string;
+  keyAddress: string;
+  createdAt: string;
+}
+
+export interface ContributorDataKey {
+  datasetHash: string;
+  dataType: string;
+  variant?: string;
+  // ECIES ciphertext of the data key under keyAddress, hex
+  encryptedKey?: string;
+  keyAddress?: string;
+  createdAt: string;
+  revokedAt?: string;
+}
+
+export interface RevokeDataKeysResponse {
+  datasetHashes: string[];
+  contributionIds: string[];
+}
+
+// Text to personal_sign for a contributor's deriveEncryptionKey
+export function contributorKeyMessage(address: string): string {
+  return `TubeDAO contributor encryption key\nAddress: ${address}`;
+}
+
+// Derive an encryption keypair from a wallet signature of
+// contributorKeyMessage or buyerKeyMessage; wallets cannot decrypt ECIES
+// with their signing key. Wallets sign deterministically, so signing again
+// recovers the same private key. Only the public key goes to the backend.
+export function deriveEncryptionKey(signature: Hex): { privateKey: Hex; publicKey: Hex } {
+  const privateKey = keccak256(signature);
+  return { privateKey, publicKey: privateKeyToAccount(privateKey).publicKey };
+}
+
+export interface UploadSession {
+  id: string;
+  address: string;
+  dataType: string;
+  fileName: string;
+  length: number;
+  offset: number;
+  checksum?: string;
+  status: 'uploading' | 'processing' | 'completed' | 'failed';
+  contributionId?: string;
+  error?: string;
+  createdAt: string;
+  updatedAt: string;
+  expiresAt: string;
+}
+
+export interface TakeoutFileSummary {
+  name: string;
+  kind: 'watch_history' | 'search_history' | 'subscriptions';
+  format?: 'json' | 'html' | 'csv';
+  records: number;
+  skipped?: number;
+  error?: string;
+}
+
+export interface TakeoutImportResponse {
+  message: string;
+  data: UserContribution;
+  txHash: string;
+  import: {
+    archiveSha256?: string;
+    files: TakeoutFileSummary[];
+    watchHistory: number;
+    searchHistory: number;
+    subscriptions: number;
+  };
+}
+
 export interface RegisteredAddress {
   id: string;
   address: string;
   timestamp: string;
 }
 
+export interface UserEvent {
+  id: string;
+  eventId?: string;
+  batchId?: string;
+  address: string;
+  eventType: string;
+  category: string;
+  timestamp: string;
+  sessionId: string;
+  pageUrl: string;
+  pageDomain: string;
+  adapter?: string;
+  eventData: Record<string, unknown> | null;
+}
+
+export interface EventPage {
+  events: UserEvent[];
+  nextCursor?: string;
+}
+
+export interface EventFilters {
+  from?: string;
+  to?: string;
+  category?: string;
+  eventType?: string;
+  domain?: string;
+  limit?: number;
+  cursor?: string;
+}
+
+export interface AnalyticsRange {
+  from?: string;
+  to?: string;
+  tz?: string;
+  limit?: number;
+}
+
+export interface WatchTimeResponse {
+  from: string;
+  to: string;
+  timezone: string;
+  totalSeconds: number;
+  days: { day: string; watchSeconds: number; videoCount: number }[];
+}
+
+export interface TopChannelsResponse {
+  from: string;
+  to: string;
+  channels: { channelId: string; channelName: string; watchSeconds: number; videoCount: number }[];
+}
+
+export interface AdImpressionsResponse {
+  from: string;
+  to: string;
+  timezone: string;
+  impressions: number;
+  skips: number;
+  skipRate: number;
+  byPosition: Record<string, number>;
+  days: { day: string; impressions: number; skips: number }[];
+}
+
+export interface EngagementResponse {
+  from: string;
+  to: string;
+  total: number;
+  counts: { eventType: string; count: number }[];
+}
+
+function toQueryString(params: object): string {
+  const query = new URLSearchParams();
+  Object.entries(params).forEach(([key, value]) => {
+    if (value !== undefined && value !== '') {
+      query.set(key, String(value));
+    }
+  });
+  const encoded = query.toString();
+  return encoded ? `?${encoded}` : '';
+}
+
 class APIClient {
   private baseURL: string;
 
@@ -91,10 +347,16 @@ class APIClient {
     });
 
     if (!response.ok) {
-      const errorData = await response.json();
-      throw new Error(errorData.error || `API request failed: ${response.statusText}`);
+      const problem = await response.json().catch(() => null) as APIError | null;
+      if (problem?.code) {
+        throw new APIRequestError(problem);
+      }
+      throw new Error(`API request failed: ${response.statusText}`);
     }
 
+    if (response.status === 204) {
+      return undefined as T;
+    }
     return response.json();
   }
 
@@ -191,7 +453,8 @@ class APIClient {
     fileName: string, 
     fileSize: number, 
     dataContent: Record<string, unknown>,
-    token: string
+    token: string,
+    maskingRules?: MaskingRules
   ): Promise<UserContribution> {
     const response = await this.request<{ data: UserContribution }>('/upload-data', {
       method: 'POST',
@@ -204,11 +467,216 @@ class APIClient {
         fileName,
         fileSize,
         dataContent,
+        maskingRules,
       }),
     });
     return response.data;
   }
 
+  async importTakeout(
+    address: string,
+    archive: File,
+    token: string,
+    maskingRules?: MaskingRules
+  ): Promise<TakeoutImportResponse> {
+    const form = new FormData();
+    form.append('address', address);
+    form.append('file', archive);
+    if (maskingRules) {
+      form.append('maskingRules', JSON.stringify(maskingRules));
+    }
+
+    // Authorization only, so the browser sets the multipart boundary
+    return this.request<TakeoutImportResponse>('/takeout/import', {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+      body: form,
+    });
+  }
+
+  // Upload a large file in chunks, resuming from the server's offset after
+  // a failed chunk. Resolves once the upload is queued for refinement; poll
+  // getUpload for the outcome.
+  async uploadResumable(
+    address: string,
+    dataType: string,
+    file: File,
+    token: string,
+    onProgress?: (uploaded: number, total: number) => void,
+    maskingRules?: MaskingRules
+  ): Promise<UploadSession> {
+    const headers = { Authorization: `Bearer ${token}` };
+    let session = await this.request<UploadSession>('/uploads', {
+      method: 'POST',
+      headers: { ...headers, 'Content-Type': 'application/json' },
+      body: JSON.stringify({ address, dataType, fileName: file.name, length: file.size, maskingRules }),
+    });
+
+    let offset = 0;
+    let failures = 0;
+    while (offset < file.size) {
+      const chunk = await file.slice(offset, offset + UPLOAD_CHUNK_BYTES).arrayBuffer();
+      const digest = await crypto.subtle.digest('SHA-256', chunk);
+      const checksum = btoa(String.fromCharCode(...new Uint8Array(digest)));
+
+      const response = await fetch(`${this.baseURL}/uploads/${session.id}`, {
+        method: 'PUT',
+        headers: {
+          ...headers,
+          'Content-Type': 'application/offset+octet-stream',
+          'Upload-Offset': String(offset),
+          'Upload-Checksum': `sha256 ${checksum}`,
+        },
+        body: chunk,
+      }).catch(() => null);
+
+      if (response?.ok) {
+        offset = Number(response.headers.get('Upload-Offset'));
+        failures = 0;
+        onProgress?.(offset, file.size);
+        continue;
+      }
+
+      if (++failures > UPLOAD_MAX_RETRIES) {
+        const problem = await response?.json().catch(() => null) as APIError | null;
+        if (problem?.code) {
+          throw new APIRequestError(problem);
+        }
+        throw new Error('Upload failed after repeated errors');
+      }
+      const head = await fetch(`${this.baseURL}/uploads/${session.id}`, { method: 'HEAD', headers }).catch(() => null);
+      if (head?.ok) {
+        offset = Number(head.headers.get('Upload-Offset'));
+      }
+    }
+
+    session = await this.request<UploadSession>(`/uploads/${session.id}/finalize`, {
+      method: 'POST',
+      headers,
+    });
+    return session;
+  }
+
+  async getMaskingPreferences(address: string, token: string): Promise<MaskingPreferences> {
+    return this.request<MaskingPreferences>(`/user/${address}/masking`, {
+      method: 'GET',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+    });
+  }
+
+  async updateMaskingPreferences(
+    address: string,
+    maskingRules: MaskingRules,
+    token: string
+  ): Promise<MaskingPreferences> {
+    return this.request<MaskingPreferences>(`/user/${address}/masking`, {
+      method: 'PUT',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+      body: JSON.stringify({ maskingRules }),
+    });
+  }
+
+  async getUpload(id: string, token: string): Promise<UploadSession> {
+    return this.request<UploadSession>(`/uploads/${id}`, {
+      method: 'GET',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+    });
+  }
+
+  async getAggregateDatasets(): Promise<PrivacyBudget[]> {
+    const response = await this.request<{ data: PrivacyBudget[] }>('/aggregates', {
+      method: 'GET',
+    });
+    return response.data;
+  }
+
+  async queryAggregate(query: AggregateQuery, token: string): Promise<AggregateQueryResult> {
+    return this.request<AggregateQueryResult>('/aggregates/query', {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+      body: JSON.stringify(query),
+    });
+  }
+
+  // Sign keyReleaseMessage with the same encryptionKey, the public key
+  // from deriveEncryptionKey
+  async releaseDatasetKey(
+    datasetId: string,
+    issuedAt: string,
+    encryptionKey: string,
+    signature: string,
+    token: string
+  ): Promise<KeyRelease> {
+    return this.request<KeyRelease>(`/data/${datasetId}/key`, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+      body: JSON.stringify({ issuedAt, encryptionKey, signature }),
+    });
+  }
+
+  async getContributorKey(address: string, token: string): Promise<ContributorKey> {
+    return this.request<ContributorKey>(`/user/${address}/encryption-key`, {
+      method: 'GET',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+    });
+  }
+
+  // Pass the public key from deriveEncryptionKey
+  async setContributorKey(
+    address: string,
+    key: { publicKey: string },
+    token: string
+  ): Promise<ContributorKey> {
+    return this.request<ContributorKey>(`/user/${address}/encryption-key`, {
+      method: 'PUT',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+      body: JSON.stringify(key),
+    });
+  }
+
+  async getContributorDataKeys(address: string, token: string): Promise<ContributorDataKey[]> {
+    const response = await this.request<{ data: ContributorDataKey[] }>(`/user/${address}/data-keys`, {
+      method: 'GET',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+    });
+    return response.data;
+  }
+
+  // Revokes every variant of the contributions the dataset was refined in
+  async revokeDataKey(address: string, datasetHash: string, token: string): Promise<RevokeDataKeysResponse> {
+    return this.request<RevokeDataKeysResponse>(`/user/${address}/data-keys/${datasetHash}`, {
+      method: 'DELETE',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+    });
+  }
+
+  async getDataSchemas(): Promise<DataSchemaInfo[]> {
+    const response = await this.request<{ data: DataSchemaInfo[] }>('/schemas', {
+      method: 'GET',
+    });
+    return response.data;
+  }
+
   async getUserData(address: string, token: string): Promise<RegisteredAddress> {
     const response = await this.request<{ data: RegisteredAddress }>(`/user/${address}`, {
       method: 'GET',
@@ -238,6 +706,40 @@ class APIClient {
     });
     return response.data;
   }
+
+  async getUserEvents(address: string, token: string, filters: EventFilters = {}): Promise<EventPage> {
+    return this.request<EventPage>(`/events/user/${address}${toQueryString(filters)}`, {
+      method: 'GET',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+    });
+  }
+
+  async getWatchTime(address: string, token: string, range: AnalyticsRange = {}): Promise<WatchTimeResponse> {
+    return this.getAnalytics<WatchTimeResponse>(address, 'watch-time', token, range);
+  }
+
+  async getTopChannels(address: string, token: string, range: AnalyticsRange = {}): Promise<TopChannelsResponse> {
+    return this.getAnalytics<TopChannelsResponse>(address, 'top-channels', token, range);
+  }
+
+  async getAdImpressions(address: string, token: string, range: AnalyticsRange = {}): Promise<AdImpressionsResponse> {
+    return this.getAnalytics<AdImpressionsResponse>(address, 'ads', token, range);
+  }
+
+  async getEngagement(address: string, token: string, range: AnalyticsRange = {}): Promise<EngagementResponse> {
+    return this.getAnalytics<EngagementResponse>(address, 'engagement', token, range);
+  }
+
+  private async getAnalytics<T>(address: string, report: string, token: string, range: AnalyticsRange): Promise<T> {
+    return this.request<T>(`/events/user/${address}/analytics/${report}${toQueryString(range)}`, {
+      method: 'GET',
+      headers: {
+        Authorization: `Bearer ${token}`,
+      },
+    });
+  }
 }
 
 export const apiClient = new APIClient();
\ No newline at end of file